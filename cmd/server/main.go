@@ -4,7 +4,6 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"net"
 	"os"
 	"os/signal"
 	"syscall"
@@ -50,11 +49,6 @@ func main() {
 		log.Fatalf("Failed to create data directory: %v", err)
 	}
 
-	// FIX: Set bootstrap DNS BEFORE initializing filter engine
-	// This prevents the chicken-and-egg DNS problem where DNS Filter
-	// can't download blocklists because it uses itself for DNS lookups
-	setBootstrapDNS()
-
 	// Initialize database
 	db, err := database.New(cfg.Database.Path)
 	if err != nil {
@@ -64,7 +58,8 @@ func main() {
 
 	log.Info("Database initialized successfully")
 
-	// Initialize filter engine (downloads blocklists using bootstrap DNS)
+	// Initialize filter engine (downloads blocklists through the outbound
+	// settings, so it never depends on itself for DNS lookups)
 	filterEngine, err := filter.New(cfg, db)
 	if err != nil {
 		log.Fatalf("Failed to initialize filter engine: %v", err)
@@ -72,9 +67,9 @@ func main() {
 
 	log.Infof("Filter engine initialized with %d blocklist entries", filterEngine.GetBlockedCount())
 
-	// Start blocklist auto-updater
+	// Start blocklist auto-updater (sources may also set their own interval)
+	go filterEngine.StartAutoUpdate(time.Duration(cfg.Blocklists.AutoUpdateInterval) * time.Hour)
 	if cfg.Blocklists.AutoUpdateInterval > 0 {
-		go filterEngine.StartAutoUpdate(time.Duration(cfg.Blocklists.AutoUpdateInterval) * time.Hour)
 		log.Infof("Blocklist auto-update enabled (every %d hours)", cfg.Blocklists.AutoUpdateInterval)
 	}
 
@@ -84,6 +79,15 @@ func main() {
 		log.Fatalf("Failed to initialize DNS server: %v", err)
 	}
 
+	// Reload custom YAML files when they change and drop only the cached
+	// answers for the names that changed
+	filterEngine.OnCustomChange(dnsServer.ForgetDomains)
+	go func() {
+		if err := filterEngine.WatchCustomBlocklists(); err != nil {
+			log.Warnf("Custom blocklist watcher stopped: %v", err)
+		}
+	}()
+
 	go func() {
 		log.Infof("Starting DNS server on %s:%d", cfg.Server.DNSHost, cfg.Server.DNSPort)
 		if err := dnsServer.Start(); err != nil {
@@ -120,23 +124,14 @@ func main() {
 	if err := apiServer.Shutdown(ctx); err != nil {
 		log.Errorf("API server shutdown error: %v", err)
 	}
+	// Writes the side effects still queued before the database closes
+	if err := filterEngine.Close(); err != nil {
+		log.Errorf("Filter engine shutdown error: %v", err)
+	}
 
 	log.Info("Shutdown complete. Goodbye!")
 }
 
-// setBootstrapDNS sets the Go default resolver to use Google DNS (8.8.8.8)
-// This fixes the chicken-and-egg problem: DNS Filter can't download
-// blocklists if it is its own DNS server and no blocklists are loaded yet.
-func setBootstrapDNS() {
-	net.DefaultResolver = &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{Timeout: 10 * time.Second}
-			return d.DialContext(ctx, "udp", "8.8.8.8:53")
-		},
-	}
-}
-
 func printBanner() {
 	fmt.Printf(`
 ╔═══════════════════════════════════════════════════╗
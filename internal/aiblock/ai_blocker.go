@@ -18,6 +18,9 @@ type AIBlocker struct {
 	model   *NaiveBayesModel
 	mu      sync.RWMutex
 	enabled bool
+
+	// sink receives predictions instead of writing each one to the database
+	sink func(PredictionResult)
 }
 
 type PredictionResult struct {
@@ -365,6 +368,19 @@ func (m *NaiveBayesModel) extractWords(domain string) []string {
 // ── Prediction ───────────────────────────────────────────────────
 
 func (ab *AIBlocker) Predict(domain string) PredictionResult {
+	result := ab.predict(domain)
+	if ab.enabled {
+		ab.logPrediction(result)
+	}
+	return result
+}
+
+// Evaluate predicts like Predict without logging the prediction
+func (ab *AIBlocker) Evaluate(domain string) PredictionResult {
+	return ab.predict(domain)
+}
+
+func (ab *AIBlocker) predict(domain string) PredictionResult {
 	if !ab.enabled {
 		return PredictionResult{
 			Domain:     domain,
@@ -404,9 +420,6 @@ func (ab *AIBlocker) Predict(domain string) PredictionResult {
 		},
 	}
 
-	// Log prediction
-	ab.logPrediction(result)
-
 	return result
 }
 
@@ -531,7 +544,20 @@ func (ab *AIBlocker) ReportFalsePositive(domain, predictedCategory, actualCatego
 
 // ── Statistics ───────────────────────────────────────────────────
 
+// SetPredictionSink hands every prediction to sink, which must not block,
+// instead of writing it during Predict. The sink is expected to write them
+// in batches with LogPredictions.
+func (ab *AIBlocker) SetPredictionSink(sink func(PredictionResult)) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+	ab.sink = sink
+}
+
 func (ab *AIBlocker) logPrediction(result PredictionResult) {
+	if ab.sink != nil {
+		ab.sink(result)
+		return
+	}
 	ab.db.Exec(`
 		INSERT INTO ai_predictions (domain, category, confidence, blocked, features)
 		VALUES (?, ?, ?, ?, ?)
@@ -539,6 +565,37 @@ func (ab *AIBlocker) logPrediction(result PredictionResult) {
 	   fmt.Sprintf("%v", result.Features))
 }
 
+// LogPredictions writes a batch of predictions in one transaction
+func (ab *AIBlocker) LogPredictions(results []PredictionResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	tx, err := ab.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO ai_predictions (domain, category, confidence, blocked, features)
+		VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, result := range results {
+		if _, err := stmt.Exec(result.Domain, result.Category, result.Confidence, result.Blocked,
+			fmt.Sprintf("%v", result.Features)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (ab *AIBlocker) GetModelStats() map[string]interface{} {
 	var totalPredictions, falsePositives int
 	var accuracy float64
@@ -635,6 +692,18 @@ func boolToFloat(b bool) float64 {
 // ── Bulk Training ────────────────────────────────────────────────
 
 func (ab *AIBlocker) TrainBulk(data []struct{ Domain, Category string }) error {
+	return ab.train(data, "bulk_import")
+}
+
+// LearnFromBlocks is LearnFromBlock for a batch of domains, in one transaction
+func (ab *AIBlocker) LearnFromBlocks(data []struct{ Domain, Category string }) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return ab.train(data, "user_block")
+}
+
+func (ab *AIBlocker) train(data []struct{ Domain, Category string }, source string) error {
 	tx, err := ab.db.Begin()
 	if err != nil {
 		return err
@@ -654,7 +723,7 @@ func (ab *AIBlocker) TrainBulk(data []struct{ Domain, Category string }) error {
 	defer ab.mu.Unlock()
 
 	for _, item := range data {
-		stmt.Exec(item.Domain, item.Category, 1.0, "bulk_import")
+		stmt.Exec(item.Domain, item.Category, 1.0, source)
 		ab.model.Train(item.Domain, item.Category)
 	}
 
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -67,11 +69,20 @@ func (s *Server) setupRoutes() {
 		api.GET("/stats/blocked", s.getBlockedStats)
 		api.GET("/stats/top-blocked", s.getTopBlocked)
 		api.GET("/recent", s.getRecentBlocked)
+		api.GET("/check", s.checkDomain)
 		api.GET("/whitelist", s.getWhitelist)
 		api.POST("/whitelist", s.addToWhitelist)
 		api.DELETE("/whitelist/:domain", s.removeFromWhitelist)
+		api.GET("/whitelist/entries", s.getCustomEntries(filter.CustomListAllow))
 		api.POST("/blocklist/update", s.updateBlocklists)
 		api.GET("/blocklist/count", s.getBlocklistCount)
+		api.GET("/blocklist/sources", s.getBlocklistSources)
+		api.POST("/blocklist/sources", s.addBlocklistSource)
+		api.POST("/blocklist/sources/test", s.testBlocklistSource)
+		api.PUT("/blocklist/sources/:name", s.updateBlocklistSource)
+		api.DELETE("/blocklist/sources/:name", s.removeBlocklistSource)
+		api.GET("/blocklist/versions", s.getBlocklistVersions)
+		api.POST("/blocklist/versions/:id/rollback", s.rollbackBlocklist)
 		api.GET("/settings", s.getSettings)
 		api.POST("/settings", s.updateSettings)
 		api.POST("/system/restart", s.restartService)
@@ -79,7 +90,32 @@ func (s *Server) setupRoutes() {
 		api.GET("/custom-blocklist", s.getCustomBlocklist)
 		api.POST("/custom-blocklist/add", s.addToCustomBlocklist)
 		api.DELETE("/custom-blocklist/:domain", s.removeFromCustomBlocklist)
+		api.GET("/custom-blocklist/entries", s.getCustomEntries(filter.CustomListBlock))
+		api.POST("/custom-blocklist/sync", s.syncCustomBlocklist)
 		api.POST("/blocklist/reload-custom", s.reloadCustomBlocklists)
+		api.GET("/walled-garden", s.getWalledGarden)
+		api.POST("/walled-garden", s.allowInWalledGarden)
+		api.DELETE("/walled-garden/:domain", s.removeFromWalledGarden)
+		api.GET("/schedule/now", s.getScheduleNow)
+		api.GET("/budgets", s.getBudgets)
+		api.POST("/budgets/reset", s.resetBudget)
+		api.GET("/shadow/report", s.getShadowReport)
+		api.GET("/stages", s.getStages)
+		api.GET("/tunnel", s.getTunnelAlerts)
+		api.DELETE("/tunnel/blocks/:domain", s.removeTunnelBlock)
+		api.GET("/new-domains", s.getNewDomains)
+		api.GET("/encrypted-dns/ips", s.getEncryptedDNSIPs)
+		api.GET("/pause", s.getPauses)
+		api.POST("/pause", s.pauseFiltering)
+		api.DELETE("/pause/:id", s.resumeFiltering)
+		api.GET("/overrides", s.getOverrides)
+		api.POST("/overrides", s.addOverride)
+		api.DELETE("/overrides/:id", s.removeOverride)
+		api.GET("/rules", s.getPatternRules)
+		api.POST("/rules", s.addPatternRule)
+		api.POST("/rules/validate", s.validatePatternRule)
+		api.PUT("/rules/:id", s.updatePatternRule)
+		api.DELETE("/rules/:id", s.removePatternRule)
 	}
 }
 
@@ -213,6 +249,8 @@ func (s *Server) getStats(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"blocked_domains": blockedCount,
 		"stats":           dbStats,
+		"pauses":          s.filter.GetPauses(),
+		"events":          s.filter.GetEventStats(),
 		"timestamp":       time.Now().Unix(),
 	})
 }
@@ -256,18 +294,45 @@ func (s *Server) addToWhitelist(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
-	s.filter.AddToWhitelist(data.Domain)
+	if err := s.filter.AddToWhitelist(data.Domain); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 func (s *Server) removeFromWhitelist(c *gin.Context) {
-	s.filter.RemoveFromWhitelist(c.Param("domain"))
+	if err := s.filter.RemoveFromWhitelist(c.Param("domain")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if s.dnsServer != nil {
+		s.dnsServer.ClearCache()
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// getCustomEntries lists the stored entries of one list with their origin
+func (s *Server) getCustomEntries(list string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entries, err := s.filter.GetCustomEntries(list)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, entries)
+	}
+}
+
 func (s *Server) updateBlocklists(c *gin.Context) {
+	// force=true applies the update even if it exceeds max_change_percent
+	force := c.Query("force") == "true"
 	go func() {
-		s.filter.UpdateBlocklists()
+		if force {
+			s.filter.ForceUpdateBlocklists()
+		} else {
+			s.filter.UpdateBlocklists()
+		}
 		if s.dnsServer != nil {
 			s.dnsServer.ClearCache()
 		}
@@ -279,6 +344,180 @@ func (s *Server) getBlocklistCount(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"count": s.filter.GetBlockedCount()})
 }
 
+func (s *Server) getBlocklistSources(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"sources": s.filter.GetSourceStatus(),
+		"formats": filter.ListFormats(),
+	})
+}
+
+// blocklistSourceRequest holds the fields of a source; fields left out of a
+// PUT keep their current value
+type blocklistSourceRequest struct {
+	Name           string  `json:"name"`
+	URL            *string `json:"url"`
+	Category       *string `json:"category"`
+	Format         *string `json:"format"`
+	Zone           *string `json:"zone"`
+	Enabled        *bool   `json:"enabled"`
+	UpdateInterval *int    `json:"update_interval"`
+	SHA256         *string `json:"sha256"`
+	ChecksumURL    *string `json:"checksum_url"`
+	SignatureURL   *string `json:"signature_url"`
+	PublicKey      *string `json:"public_key"`
+	Shadow         *bool   `json:"shadow"`
+	Limit          int     `json:"limit"`
+}
+
+func (r *blocklistSourceRequest) apply(source *config.BlocklistSource) {
+	if r.URL != nil {
+		source.URL = *r.URL
+	}
+	if r.Category != nil {
+		source.Category = *r.Category
+	}
+	if r.Format != nil {
+		source.Format = *r.Format
+	}
+	if r.Zone != nil {
+		source.Zone = *r.Zone
+	}
+	if r.Enabled != nil {
+		source.Enabled = *r.Enabled
+	}
+	if r.UpdateInterval != nil {
+		source.UpdateInterval = *r.UpdateInterval
+	}
+	if r.SHA256 != nil {
+		source.SHA256 = *r.SHA256
+	}
+	if r.ChecksumURL != nil {
+		source.ChecksumURL = *r.ChecksumURL
+	}
+	if r.SignatureURL != nil {
+		source.SignatureURL = *r.SignatureURL
+	}
+	if r.PublicKey != nil {
+		source.PublicKey = *r.PublicKey
+	}
+	if r.Shadow != nil {
+		source.Shadow = *r.Shadow
+	}
+}
+
+// rebuildBlocklist merges the sources again in the background and clears
+// the cache once the new list is in place
+func (s *Server) rebuildBlocklist() {
+	go func() {
+		if err := s.filter.RebuildBlocklist(); err != nil {
+			s.log.Errorf("Failed to rebuild blocklist: %v", err)
+		}
+		if s.dnsServer != nil {
+			s.dnsServer.ClearCache()
+		}
+	}()
+}
+
+func (s *Server) addBlocklistSource(c *gin.Context) {
+	var data blocklistSourceRequest
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	source := config.BlocklistSource{Name: data.Name, Enabled: true}
+	data.apply(&source)
+
+	if err := s.filter.AddSource(source); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.rebuildBlocklist()
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Source added, blocklist rebuild started"})
+}
+
+func (s *Server) updateBlocklistSource(c *gin.Context) {
+	name := c.Param("name")
+	source, ok := s.filter.GetSource(name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Source not found"})
+		return
+	}
+
+	var data blocklistSourceRequest
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	data.apply(&source)
+
+	if err := s.filter.UpdateSource(name, source); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.rebuildBlocklist()
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Source updated, blocklist rebuild started"})
+}
+
+func (s *Server) removeBlocklistSource(c *gin.Context) {
+	if err := s.filter.RemoveSource(c.Param("name")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s.rebuildBlocklist()
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// testBlocklistSource fetches a source and returns the first parsed domains
+// without saving anything
+func (s *Server) testBlocklistSource(c *gin.Context) {
+	var data blocklistSourceRequest
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	source := config.BlocklistSource{Name: data.Name}
+	if existing, ok := s.filter.GetSource(data.Name); ok {
+		source = existing
+	}
+	data.apply(&source)
+
+	preview, err := s.filter.PreviewSource(source, data.Limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, preview)
+}
+
+func (s *Server) getBlocklistVersions(c *gin.Context) {
+	limit := 20
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	versions, err := s.filter.GetBlocklistVersions(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+func (s *Server) rollbackBlocklist(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version id"})
+		return
+	}
+	if err := s.filter.RollbackBlocklist(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if s.dnsServer != nil {
+		s.dnsServer.ClearCache()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "count": s.filter.GetBlockedCount()})
+}
+
 func (s *Server) getSettings(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"dns_port":  s.cfg.Server.DNSPort,
@@ -314,27 +553,443 @@ func (s *Server) addToCustomBlocklist(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
-	s.filter.AddToCustomBlocklist(data.Domain)
+	if err := s.filter.AddToCustomBlocklist(data.Domain); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if s.dnsServer != nil {
+		s.dnsServer.ClearCache()
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "domain": data.Domain})
 }
 
 func (s *Server) removeFromCustomBlocklist(c *gin.Context) {
-	s.filter.RemoveFromCustomBlocklist(c.Param("domain"))
+	if err := s.filter.RemoveFromCustomBlocklist(c.Param("domain")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if s.dnsServer != nil {
+		s.dnsServer.ClearCache()
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-func (s *Server) reloadCustomBlocklists(c *gin.Context) {
-	count, err := s.filter.ReloadCustomBlocklists()
+// syncCustomBlocklist writes the custom blocks added through the API to
+// custom-blocklist.yaml
+func (s *Server) syncCustomBlocklist(c *gin.Context) {
+	count, err := s.filter.SyncCustomBlocklistFile()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if s.dnsServer != nil {
-		s.dnsServer.ClearCache()
+	c.JSON(http.StatusOK, gin.H{"success": true, "count": count})
+}
+
+// reloadCustomBlocklists reads the custom YAML files again and reports each
+// file. Cached answers of the names that changed are dropped by the engine.
+func (s *Server) reloadCustomBlocklists(c *gin.Context) {
+	reload, err := s.filter.ReloadCustomBlocklists()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"message": "Custom blocklists reloaded and cache cleared",
-		"count":   count,
+		"message": "Custom blocklists reloaded",
+		"count":   reload.Count,
+		"files":   reload.Files,
+		"changed": reload.Changed,
+	})
+}
+
+// getWalledGarden lists the allowed domains of the walled garden and the
+// learned ones waiting for approval
+func (s *Server) getWalledGarden(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": s.cfg.Filtering.WalledGarden.Enabled,
+		"learn":   s.cfg.Filtering.WalledGarden.Learn,
+		"domains": s.filter.GetWalledGarden(),
+	})
+}
+
+// allowInWalledGarden adds a domain to the allowlist or approves a learned one
+func (s *Server) allowInWalledGarden(c *gin.Context) {
+	var data struct {
+		Domain string `json:"domain" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if err := s.filter.AllowInWalledGarden(data.Domain); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "domain": data.Domain})
+}
+
+func (s *Server) removeFromWalledGarden(c *gin.Context) {
+	if err := s.filter.RemoveFromWalledGarden(c.Param("domain")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// checkDomain explains how a query for ?domain= from ?client= would be
+// answered, stage by stage
+func (s *Server) checkDomain(c *gin.Context) {
+	decision, err := s.filter.Explain(c.Query("domain"), c.Query("client"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, decision)
+}
+
+// getScheduleNow previews which schedule rules apply to a client, now or at
+// the time given as ?at= (RFC 3339)
+func (s *Server) getScheduleNow(c *gin.Context) {
+	at := time.Now()
+	if value := c.Query("at"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "at must be an RFC 3339 time"})
+			return
+		}
+		at = t
+	}
+	client := c.Query("client")
+
+	rules := s.filter.GetScheduleStatus(client, at)
+	actions := []string{}
+	for _, rule := range rules {
+		if rule.Applies {
+			actions = append(actions, rule.Action)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": s.cfg.Filtering.Schedule.Enabled,
+		"at":      at,
+		"client":  client,
+		"actions": actions,
+		"rules":   rules,
+	})
+}
+
+func (s *Server) getPauses(c *gin.Context) {
+	c.JSON(http.StatusOK, s.filter.GetPauses())
+}
+
+// pauseFiltering stops blocking for some minutes, for everyone or for a
+// group or client. The pause records who asked for it.
+func (s *Server) pauseFiltering(c *gin.Context) {
+	var data struct {
+		Scope   string `json:"scope"`
+		Target  string `json:"target"`
+		Minutes int    `json:"minutes" binding:"required"`
+		By      string `json:"by"`
+		Note    string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if data.Scope == "" {
+		data.Scope = filter.PauseGlobal
+	}
+	if data.By == "" {
+		data.By = s.cfg.Security.AdminUsername
+	}
+	pausedBy := fmt.Sprintf("%s (%s)", data.By, c.ClientIP())
+
+	pause, err := s.filter.PauseFiltering(data.Scope, data.Target, time.Duration(data.Minutes)*time.Minute, pausedBy, data.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "pause": pause})
+}
+
+func (s *Server) resumeFiltering(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pause id"})
+		return
+	}
+	if err := s.filter.ResumeFiltering(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// getBudgets lists the time budgets used today, or those of one client given
+// as ?client= including the ones it has not touched yet
+func (s *Server) getBudgets(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": s.cfg.Filtering.Budgets.Enabled,
+		"budgets": s.filter.GetBudgets(c.Query("client")),
+	})
+}
+
+// resetBudget gives a client, or every client, its full budget back
+func (s *Server) resetBudget(c *gin.Context) {
+	var data struct {
+		Name   string `json:"name" binding:"required"`
+		Client string `json:"client"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if err := s.filter.ResetBudget(data.Name, data.Client); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// getShadowReport compares what the shadow stages would block with the live
+// blocks over the last ?days= days (7 by default)
+func (s *Server) getShadowReport(c *gin.Context) {
+	days := 7
+	if d, err := strconv.Atoi(c.Query("days")); err == nil && d > 0 {
+		days = d
+	}
+	report, err := s.filter.GetShadowReport(days)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// getStages lists the filter stages in the order they run
+func (s *Server) getStages(c *gin.Context) {
+	c.JSON(http.StatusOK, s.filter.GetStages())
+}
+
+// getTunnelAlerts lists the recent tunneling and DGA alerts and the parent
+// domains blocked because of them
+func (s *Server) getTunnelAlerts(c *gin.Context) {
+	alerts, blocks := s.filter.GetTunnelAlerts()
+	c.JSON(http.StatusOK, gin.H{
+		"enabled": s.cfg.Filtering.Tunnel.Enabled,
+		"alerts":  alerts,
+		"blocks":  blocks,
 	})
 }
+
+func (s *Server) removeTunnelBlock(c *gin.Context) {
+	if err := s.filter.RemoveTunnelBlock(c.Param("domain")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// getNewDomains describes the first-seen store and lists the domains first
+// seen in the last ?hours= (default the configured age)
+func (s *Server) getNewDomains(c *gin.Context) {
+	hours, _ := strconv.Atoi(c.Query("hours"))
+	c.JSON(http.StatusOK, s.filter.GetNewDomains(hours))
+}
+
+// getEncryptedDNSIPs exports the addresses of public encrypted DNS resolvers
+// for a firewall: ?format=text (one per line, the default), json, iptables
+// or nftables
+func (s *Server) getEncryptedDNSIPs(c *gin.Context) {
+	ips := s.filter.GetEncryptedDNSIPs()
+
+	var b strings.Builder
+	switch c.DefaultQuery("format", "text") {
+	case "text":
+		for _, ip := range ips {
+			b.WriteString(ip + "\n")
+		}
+	case "json":
+		c.JSON(http.StatusOK, gin.H{
+			"enabled": s.cfg.Filtering.EncryptedDNS.Enabled,
+			"ips":     ips,
+		})
+		return
+	case "iptables":
+		// DoH and DoT both go to port 443 or 853 of the resolver
+		for _, ip := range ips {
+			cmd := "iptables"
+			if strings.Contains(ip, ":") {
+				cmd = "ip6tables"
+			}
+			for _, rule := range []string{"-p tcp -m multiport --dports 443,853", "-p udp -m multiport --dports 443,853"} {
+				fmt.Fprintf(&b, "%s -I FORWARD -d %s %s -j REJECT\n", cmd, ip, rule)
+			}
+		}
+	case "nftables":
+		var v4, v6 []string
+		for _, ip := range ips {
+			if strings.Contains(ip, ":") {
+				v6 = append(v6, ip)
+			} else {
+				v4 = append(v4, ip)
+			}
+		}
+		// nft rejects a set with no elements, so empty sets and their
+		// rules are left out
+		b.WriteString("table inet encrypted_dns {\n")
+		if len(v4) > 0 {
+			fmt.Fprintf(&b, "\tset resolvers4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\telements = { %s }\n\t}\n", strings.Join(v4, ", "))
+		}
+		if len(v6) > 0 {
+			fmt.Fprintf(&b, "\tset resolvers6 {\n\t\ttype ipv6_addr\n\t\tflags interval\n\t\telements = { %s }\n\t}\n", strings.Join(v6, ", "))
+		}
+		b.WriteString("\tchain forward {\n\t\ttype filter hook forward priority 0;\n")
+		if len(v4) > 0 {
+			b.WriteString("\t\tip daddr @resolvers4 meta l4proto { tcp, udp } th dport { 443, 853 } reject\n")
+		}
+		if len(v6) > 0 {
+			b.WriteString("\t\tip6 daddr @resolvers6 meta l4proto { tcp, udp } th dport { 443, 853 } reject\n")
+		}
+		b.WriteString("\t}\n}\n")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be text, json, iptables or nftables"})
+		return
+	}
+	c.String(http.StatusOK, b.String())
+}
+
+func (s *Server) getOverrides(c *gin.Context) {
+	c.JSON(http.StatusOK, s.filter.GetOverrides())
+}
+
+// addOverride allows or blocks a domain for some minutes, for everyone or for
+// one client. Cached answers for the domain are dropped by the engine.
+func (s *Server) addOverride(c *gin.Context) {
+	var data struct {
+		Domain  string `json:"domain" binding:"required"`
+		Action  string `json:"action"`
+		Client  string `json:"client"`
+		Minutes int    `json:"minutes" binding:"required"`
+		Note    string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if data.Action == "" {
+		data.Action = filter.OverrideAllow
+	}
+	override, err := s.filter.AddOverride(data.Domain, data.Action, data.Client, time.Duration(data.Minutes)*time.Minute, data.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "override": override})
+}
+
+func (s *Server) removeOverride(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid override id"})
+		return
+	}
+	if err := s.filter.RemoveOverride(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+type patternRuleRequest struct {
+	Pattern string `json:"pattern" binding:"required"`
+	Kind    string `json:"kind"`
+	Action  string `json:"action"`
+	Note    string `json:"note"`
+}
+
+func (s *Server) getPatternRules(c *gin.Context) {
+	c.JSON(http.StatusOK, s.filter.GetPatternRules())
+}
+
+func (s *Server) addPatternRule(c *gin.Context) {
+	var data patternRuleRequest
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if data.Kind == "" {
+		data.Kind = filter.PatternKindRegex
+	}
+	rule, err := s.filter.AddPatternRule(data.Pattern, data.Kind, data.Action, data.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "pattern": data.Pattern})
+		return
+	}
+	if s.dnsServer != nil {
+		s.dnsServer.ClearCache()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "rule": rule})
+}
+
+func (s *Server) validatePatternRule(c *gin.Context) {
+	var data patternRuleRequest
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if data.Kind == "" {
+		data.Kind = filter.PatternKindRegex
+	}
+	if err := s.filter.ValidatePattern(data.Pattern, data.Kind, data.Action); err != nil {
+		c.JSON(http.StatusOK, gin.H{"valid": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"valid": true})
+}
+
+func (s *Server) updatePatternRule(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule id"})
+		return
+	}
+	var data struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "enabled is required"})
+		return
+	}
+	if _, ok := s.filter.GetPatternRule(id); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	if err := s.filter.SetPatternRuleEnabled(id, *data.Enabled); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if s.dnsServer != nil {
+		s.dnsServer.ClearCache()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (s *Server) removePatternRule(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule id"})
+		return
+	}
+	if _, ok := s.filter.GetPatternRule(id); !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	if err := s.filter.RemovePatternRule(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if s.dnsServer != nil {
+		s.dnsServer.ClearCache()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
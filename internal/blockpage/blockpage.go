@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 )
@@ -20,6 +22,18 @@ type BlockPageServer struct {
 	template *template.Template
 	stats    *BlockStats
 	mu       sync.RWMutex
+
+	// Looks up the time budget that covers a client and domain
+	budgetLookup func(clientIP, domain string) *BudgetStatus
+}
+
+// BudgetStatus is the daily time budget shown on the block page
+type BudgetStatus struct {
+	Name      string
+	Used      int // minutes
+	Limit     int
+	Remaining int
+	ResetsAt  time.Time
 }
 
 type BlockStats struct {
@@ -44,6 +58,7 @@ type BlockPageData struct {
 	ShowRamadanMode  bool
 	CanRequestUnblock bool
 	UnblockMinutes   int
+	Budget           *BudgetStatus
 }
 
 func NewBlockPageServer(db *sql.DB, port int) (*BlockPageServer, error) {
@@ -205,6 +220,13 @@ func (bps *BlockPageServer) loadTemplate() error {
             font-size: 1.1em;
         }
 
+        .budget-box {
+            background: rgba(255, 200, 0, 0.05);
+            border-left: 4px solid #ffc800;
+            padding: 20px;
+            margin: 20px 0;
+        }
+
         .stats-grid {
             display: grid;
             grid-template-columns: repeat(auto-fit, minmax(150px, 1fr));
@@ -373,6 +395,14 @@ func (bps *BlockPageServer) loadTemplate() error {
             {{end}}
         </div>
 
+        {{if .Budget}}
+        <div class="budget-box">
+            <div class="reason-title">⏳ Daily time budget: {{.Budget.Name}}</div>
+            <p>{{.Budget.Used}} of {{.Budget.Limit}} minutes used, {{.Budget.Remaining}} left.</p>
+            <p style="margin-top: 10px;">Resets at {{.Budget.ResetsAt.Format "Mon 15:04"}}</p>
+        </div>
+        {{end}}
+
         <div class="stats-grid">
             <div class="stat-card">
                 <span class="stat-number">{{.BlockedToday}}</span>
@@ -480,6 +510,14 @@ func (bps *BlockPageServer) handleBlockPage(w http.ResponseWriter, r *http.Reque
 		UnblockMinutes:    30,
 	}
 
+	if bps.budgetLookup != nil {
+		clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			clientIP = r.RemoteAddr
+		}
+		data.Budget = bps.budgetLookup(clientIP, domain)
+	}
+
 	// Extract keywords if in reason
 	if keywords := r.URL.Query().Get("keywords"); keywords != "" {
 		// Parse keywords from comma-separated string
@@ -581,6 +619,25 @@ func (bps *BlockPageServer) formatReason(reason string) string {
 		return formatted
 	}
 
+	if strings.HasPrefix(reason, "blocklist:") {
+		return "This domain is listed by " + strings.TrimPrefix(reason, "blocklist:")
+	}
+	if strings.HasPrefix(reason, "budget:") {
+		return "The daily time budget for " + strings.TrimPrefix(reason, "budget:") + " is used up"
+	}
+	if strings.HasPrefix(reason, "tunnel:") {
+		return "Traffic to " + strings.TrimPrefix(reason, "tunnel:") + " looked like a DNS tunnel and is blocked for a while"
+	}
+	if strings.HasPrefix(reason, "homograph:") {
+		return "This address imitates " + strings.TrimPrefix(reason, "homograph:") + " and may be a phishing site"
+	}
+	if strings.HasPrefix(reason, "encrypted-dns:") {
+		return "Encrypted DNS resolvers that bypass your DNS filter are blocked on this network"
+	}
+	if strings.HasPrefix(reason, "new-domain:") {
+		return "This site was registered or first visited very recently, which is common for phishing and malware"
+	}
+
 	return "This site has been blocked by your DNS filter"
 }
 
@@ -620,6 +677,12 @@ func (bps *BlockPageServer) isRamadanMode() bool {
 
 // ── Server Control ───────────────────────────────────────────────
 
+// SetBudgetLookup sets how the page finds the time budget of the client
+// for the blocked domain
+func (bps *BlockPageServer) SetBudgetLookup(lookup func(clientIP, domain string) *BudgetStatus) {
+	bps.budgetLookup = lookup
+}
+
 func (bps *BlockPageServer) Start() error {
 	go bps.server.ListenAndServe()
 	return nil
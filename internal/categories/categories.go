@@ -452,6 +452,32 @@ func (cm *CategoryManager) IsBlocked(domain string) (bool, string) {
 	return false, ""
 }
 
+// Lookup returns the category of domain or of its closest listed parent,
+// whether that category is enabled or not
+func (cm *CategoryManager) Lookup(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	for name := domain; name != ""; {
+		var categoryID string
+		err := cm.db.QueryRow(`
+			SELECT category_id FROM category_domains WHERE domain = ? LIMIT 1
+		`, name).Scan(&categoryID)
+		if err == nil {
+			return categoryID
+		}
+
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return ""
+}
+
 func (cm *CategoryManager) GetDomainCategory(domain string) string {
 	domain = strings.ToLower(strings.TrimSpace(domain))
 	
@@ -17,6 +17,8 @@ type Config struct {
 	Blocklists BlocklistsConfig `yaml:"blocklists"`
 	Whitelist  WhitelistConfig  `yaml:"whitelist"`
 	Advanced   AdvancedConfig   `yaml:"advanced"`
+	Outbound   OutboundConfig   `yaml:"outbound"`
+	Clients    ClientsConfig    `yaml:"clients"`
 }
 
 type ServerConfig struct {
@@ -38,19 +40,126 @@ type FilteringConfig struct {
 	SafeSearch       bool             `yaml:"safe_search"`
 	YoutubeRestrict  bool             `yaml:"youtube_restricted"`
 	Schedule         ScheduleConfig   `yaml:"schedule"`
+	WalledGarden     WalledGardenConfig `yaml:"walled_garden"`
+	Budgets          BudgetsConfig    `yaml:"budgets"`
+	Shadow           ShadowConfig     `yaml:"shadow"`
+	Stages           map[string]StageConfig `yaml:"stages"`
+	Homograph        HomographConfig  `yaml:"homograph"`
+	Tunnel           TunnelConfig     `yaml:"tunnel"`
+	NewDomains       NewDomainsConfig `yaml:"new_domains"`
+	EncryptedDNS     EncryptedDNSConfig `yaml:"encrypted_dns"`
+}
+
+// EncryptedDNSConfig keeps clients from resolving around the filter with
+// browser DoH, iCloud Private Relay or a public DoH/DoT resolver
+type EncryptedDNSConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Resolvers []string `yaml:"resolvers"` // host names added to the shipped list
+	IPs       []string `yaml:"ips"`       // addresses added to the firewall export
+}
+
+// NewDomainsConfig blocks or warns about domains first seen on the network
+// a short while ago, or listed by a newly registered domains feed
+type NewDomainsConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Action     string `yaml:"action"`      // block or warn, defaults to block
+	Hours      int    `yaml:"hours"`       // younger than this counts as new, defaults to 24
+	LearnHours int    `yaml:"learn_hours"` // observe this long before acting, defaults to hours
+	Feed       string `yaml:"feed"`        // file of newly registered domains, in any blocklist format
+	Match      string `yaml:"match"`       // either (default) or both: first seen recently and in the feed
+}
+
+// TunnelConfig watches the query stream of each client for DNS tunneling and
+// domain generation algorithms. Zero values use the defaults.
+type TunnelConfig struct {
+	Enabled          bool     `yaml:"enabled"`
+	Window           int      `yaml:"window"`            // seconds of queries looked at, defaults to 300
+	UniqueSubdomains int      `yaml:"unique_subdomains"` // distinct subdomains of one parent, defaults to 50
+	MinEntropy       float64  `yaml:"min_entropy"`       // their average bits per character, defaults to 3.5
+	LongLabel        int      `yaml:"long_label"`        // labels at least this long, defaults to 40
+	LongQueries      int      `yaml:"long_queries"`      // queries with such labels, defaults to 10
+	TXTQueries       int      `yaml:"txt_queries"`       // TXT and NULL queries to one parent, defaults to 50
+	DGADomains       int      `yaml:"dga_domains"`       // random-looking domains from one client, defaults to 30
+	Block            bool     `yaml:"block"`             // block the parent domain of a tunnel
+	BlockMinutes     int      `yaml:"block_minutes"`     // defaults to 60
+	Ignore           []string `yaml:"ignore"`            // parents never analysed, e.g. CDNs
+}
+
+// HomographConfig catches names made to look like protected brands, such as
+// paypal.com spelled with a Cyrillic a
+type HomographConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Action  string   `yaml:"action"` // block or flag (log only), defaults to block
+	Brands  []string `yaml:"brands"` // domains to protect, with their subdomains
+}
+
+// StageConfig changes where a filter stage runs, or turns it off. Stages run
+// from the lowest priority up.
+type StageConfig struct {
+	Priority int   `yaml:"priority"` // 0 keeps the stage's own priority
+	Enabled  *bool `yaml:"enabled"`  // unset keeps the stage on
+}
+
+// ShadowConfig names the stages that only log what they would block. Shadow
+// blocklist sources are marked on the source itself.
+type ShadowConfig struct {
+	Categories   []string `yaml:"categories"`
+	KeywordLists []string `yaml:"keyword_lists"`
+	AI           bool     `yaml:"ai"`
+	AIThreshold  float64  `yaml:"ai_threshold"` // confidence in percent, 0 keeps the built-in thresholds
+}
+
+// BudgetsConfig limits the time clients spend on some sites each day
+type BudgetsConfig struct {
+	Enabled  bool         `yaml:"enabled"`
+	ResetAt  string       `yaml:"reset_at"`  // HH:MM when budgets start over, defaults to 00:00
+	Timezone string       `yaml:"timezone"`  // IANA name, defaults to the local zone
+	BurstGap int          `yaml:"burst_gap"` // minutes between queries still counted as active, defaults to 5
+	Rules    []BudgetRule `yaml:"rules"`
+}
+
+// BudgetRule gives each client it covers Minutes a day on the sites of some
+// categories or domains
+type BudgetRule struct {
+	Name       string   `yaml:"name"`
+	Categories []string `yaml:"categories"`
+	Domains    []string `yaml:"domains"` // with their subdomains
+	Minutes    int      `yaml:"minutes"`
+	Days       []string `yaml:"days"`    // days the budget applies, empty for every day
+	Clients    []string `yaml:"clients"` // IPs or CIDRs, with groups; both empty for everyone
+	Groups     []string `yaml:"groups"`
+}
+
+// WalledGardenConfig denies everything except an allowlist to some clients
+type WalledGardenConfig struct {
+	Enabled      bool                `yaml:"enabled"`
+	Clients      []string            `yaml:"clients"` // IPs or CIDRs, with groups; both empty for everyone
+	Groups       []string            `yaml:"groups"`
+	Allow        []string            `yaml:"allow"`        // sites allowed with their subdomains
+	Dependencies map[string][]string `yaml:"dependencies"` // extra domains each site needs
+	Learn        bool                `yaml:"learn"`        // record what allowed sites pull in
 }
 
 type ScheduleConfig struct {
-	Enabled bool           `yaml:"enabled"`
-	Rules   []ScheduleRule `yaml:"rules"`
+	Enabled  bool           `yaml:"enabled"`
+	Rules    []ScheduleRule `yaml:"rules"`
+	Holidays []string       `yaml:"holidays"` // YYYY-MM-DD dates on which no rule applies
 }
 
+// ScheduleRule applies an action on some days between two times. A range
+// whose end is before its start runs past midnight.
 type ScheduleRule struct {
 	Name       string   `yaml:"name"`
 	Days       []string `yaml:"days"`
 	StartTime  string   `yaml:"start_time"`
 	EndTime    string   `yaml:"end_time"`
-	StrictMode bool     `yaml:"strict_mode"`
+	StrictMode bool     `yaml:"strict_mode"` // same as action block_all
+	Timezone   string   `yaml:"timezone"`    // IANA name, defaults to the local zone
+	Clients    []string `yaml:"clients"`     // IPs or CIDRs, with groups; both empty for everyone
+	Groups     []string `yaml:"groups"`
+	Action     string   `yaml:"action"`     // block_all, block_categories or safe_search
+	Categories []string `yaml:"categories"` // for block_categories
+	Except     []string `yaml:"except"`     // YYYY-MM-DD dates on which the rule is skipped
 }
 
 type DatabaseConfig struct {
@@ -83,13 +192,50 @@ type BlocklistsConfig struct {
 	AutoUpdateInterval int               `yaml:"auto_update_interval"`
 	Sources            []BlocklistSource  `yaml:"sources"`
 	CustomPath         string             `yaml:"custom_path"`
+
+	// Refuse updates that grow or shrink the list by more than this many
+	// percent (-1 disables the check), and keep this many versions for rollback
+	MaxChangePercent int `yaml:"max_change_percent"`
+	KeepVersions     int `yaml:"keep_versions"`
+
+	// Let the API add and preview file:// and axfr:// sources. Off by
+	// default because they read local files and connect anywhere.
+	AllowLocalSources bool `yaml:"allow_local_sources"`
+}
+
+// OutboundConfig controls how blocklists and other remote files are fetched
+type OutboundConfig struct {
+	BootstrapDNS []string `yaml:"bootstrap_dns"` // resolvers for fetches, defaults to upstream_dns
+	Proxy        string   `yaml:"proxy"`         // http://, https://, socks5:// or socks5h:// URL
+	NoProxy      []string `yaml:"no_proxy"`      // hosts, domains or CIDRs reached directly
+}
+
+// ClientsConfig names groups of clients that rules can be limited to
+type ClientsConfig struct {
+	Groups []ClientGroup `yaml:"groups"`
+}
+
+type ClientGroup struct {
+	Name    string   `yaml:"name"`
+	Clients []string `yaml:"clients"` // IP addresses or CIDRs
 }
 
 type BlocklistSource struct {
 	Name     string `yaml:"name"`
 	URL      string `yaml:"url"`
 	Category string `yaml:"category"`
+	Format   string `yaml:"format"` // auto, hosts, adblock, domains, dnsmasq, unbound, rpz
+	Zone     string `yaml:"zone"`   // RPZ zone name, required for axfr:// sources
 	Enabled  bool   `yaml:"enabled"`
+	Shadow   bool   `yaml:"shadow"` // only log what the source would block
+
+	UpdateInterval int `yaml:"update_interval"` // hours, 0 uses auto_update_interval
+
+	// Optional integrity checks of the downloaded content
+	SHA256       string `yaml:"sha256"`        // pinned SHA-256 of the list
+	ChecksumURL  string `yaml:"checksum_url"`  // file with the SHA-256 (sha256sum format)
+	SignatureURL string `yaml:"signature_url"` // detached minisign or ed25519 signature
+	PublicKey    string `yaml:"public_key"`    // minisign or base64 ed25519 public key
 }
 
 type WhitelistConfig struct {
@@ -137,6 +283,12 @@ func Load(path string) (*Config, error) {
 	if cfg.Blocklists.CustomPath == "" {
 		cfg.Blocklists.CustomPath = "./configs/custom*.yaml"
 	}
+	if cfg.Blocklists.MaxChangePercent == 0 {
+		cfg.Blocklists.MaxChangePercent = 50
+	}
+	if cfg.Blocklists.KeepVersions == 0 {
+		cfg.Blocklists.KeepVersions = 10
+	}
 
 	// Historical/example hash in configs may not match the documented default
 	// password (`changeme`). If the shipped example bcrypt hash is present,
@@ -1,7 +1,10 @@
 package database
 
 import (
+	"bytes"
+	"compress/gzip"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -12,6 +15,139 @@ type DB struct {
 	conn *sql.DB
 }
 
+type PatternRule struct {
+	ID      int64
+	Pattern string
+	Kind    string
+	Action  string
+	Note    string
+	Enabled bool
+	Hits    int64
+}
+
+// CustomEntry is a custom block or whitelist entry. Origin tells where it
+// was defined: "api", "config" (config.yaml) or "file" (a custom YAML file,
+// named by File). Entries from config and files are replaced on every sync.
+type CustomEntry struct {
+	Domain   string
+	List     string // "block" or "allow"
+	Origin   string
+	File     string
+	Category string
+	Note     string
+	Enabled  bool
+	Options  string // JSON scope of the entry (match, expiry, schedule, clients, rewrite)
+	AddedAt  time.Time
+}
+
+// Override is a temporary allow or block of a domain, for every client or for
+// the clients in Client (an IP or CIDR)
+type Override struct {
+	ID        int64
+	Domain    string
+	Action    string
+	Client    string
+	Note      string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// Pause suspends blocking until Until, for everyone (scope "global"), for a
+// client group or for a client IP or CIDR named by Target
+type Pause struct {
+	ID       int64
+	Scope    string
+	Target   string
+	PausedBy string
+	Note     string
+	Started  time.Time
+	Until    time.Time
+}
+
+// GardenDomain is a domain of the walled garden. Approved domains were added
+// through the API or approved after being learned; the others were requested
+// right after the allowed site Parent and wait for approval.
+type GardenDomain struct {
+	Domain    string
+	Parent    string
+	Client    string
+	Hits      int64
+	Approved  bool
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// BudgetUsage is the time a client spent on the sites of a budget during the
+// period starting at Period
+type BudgetUsage struct {
+	Budget   string
+	Client   string
+	Period   time.Time
+	Used     time.Duration
+	LastSeen time.Time
+}
+
+// ShadowHit counts the queries for Domain that the shadow stage Stage would
+// have blocked on Day (YYYY-MM-DD), and how many of them were blocked anyway
+type ShadowHit struct {
+	Stage       string
+	Domain      string
+	Day         string
+	Hits        int64
+	LiveBlocked int64
+	LastSeen    time.Time
+}
+
+// DomainCount is a domain with a number of queries
+type DomainCount struct {
+	Domain string
+	Count  int64
+}
+
+// BlocklistSource is the stored state of a blocklist source after its last
+// fetch. Sources with origin "api" were added at runtime and are defined by
+// their row; for sources from config.yaml the row only holds state and an
+// optional enabled override.
+type BlocklistSource struct {
+	Name            string
+	URL             string
+	Category        string
+	Format          string
+	Zone            string
+	Origin          string
+	UpdateInterval  int
+	Enabled         bool
+	EnabledOverride *bool
+	Shadow          bool
+	SHA256          string
+	ChecksumURL     string
+	SignatureURL    string
+	PublicKey       string
+	ETag            string
+	LastModified    string
+	Checksum        string
+	DomainCount     int
+	ParseErrors     int
+	Hits            int64
+	LastError       string
+	LastChecked     time.Time
+	LastUpdated     time.Time
+}
+
+// BlocklistVersion is one recorded change of the compiled blocklist. Summary
+// is a JSON document with samples of the added and removed domains.
+type BlocklistVersion struct {
+	ID          int64
+	CreatedAt   time.Time
+	Cause       string
+	Status      string
+	DomainCount int
+	Added       int
+	Removed     int
+	Summary     string
+	HasSnapshot bool
+}
+
 type BlockedQuery struct {
 	ID        int64
 	Domain    string
@@ -56,14 +192,77 @@ func (db *DB) initialize() error {
 
 	CREATE TABLE IF NOT EXISTS blocklist (
 		domain TEXT PRIMARY KEY,
+		sources TEXT,
 		added_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
-	CREATE TABLE IF NOT EXISTS whitelist (
+	CREATE TABLE IF NOT EXISTS custom_entries (
+		domain TEXT NOT NULL,
+		list TEXT NOT NULL,
+		origin TEXT NOT NULL,
+		file TEXT NOT NULL DEFAULT '',
+		category TEXT,
+		note TEXT,
+		enabled BOOLEAN DEFAULT 1,
+		options TEXT NOT NULL DEFAULT '',
+		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (domain, list, origin, file, options)
+	);
+
+	CREATE TABLE IF NOT EXISTS overrides (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		domain TEXT NOT NULL,
+		action TEXT NOT NULL,
+		client TEXT NOT NULL DEFAULT '',
+		note TEXT,
+		expires_at DATETIME NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS pauses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		scope TEXT NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		paused_by TEXT,
+		note TEXT,
+		started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		until DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS garden_domains (
 		domain TEXT PRIMARY KEY,
-		added_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		parent TEXT NOT NULL DEFAULT '',
+		client TEXT NOT NULL DEFAULT '',
+		hits INTEGER DEFAULT 0,
+		approved BOOLEAN DEFAULT 0,
+		first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_seen DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS budget_usage (
+		budget TEXT NOT NULL,
+		client TEXT NOT NULL,
+		period DATETIME NOT NULL,
+		used_seconds INTEGER DEFAULT 0,
+		last_seen DATETIME,
+		PRIMARY KEY (budget, client)
+	);
+
+	CREATE TABLE IF NOT EXISTS shadow_hits (
+		stage TEXT NOT NULL,
+		domain TEXT NOT NULL,
+		day TEXT NOT NULL,
+		hits INTEGER DEFAULT 0,
+		live_blocked INTEGER DEFAULT 0,
+		last_seen DATETIME,
+		PRIMARY KEY (stage, domain, day)
 	);
 
+	CREATE TABLE IF NOT EXISTS first_seen (
+		domain TEXT PRIMARY KEY,
+		seen INTEGER NOT NULL
+	) WITHOUT ROWID;
+
 	CREATE TABLE IF NOT EXISTS settings (
 		key TEXT PRIMARY KEY,
 		value TEXT,
@@ -77,12 +276,194 @@ func (db *DB) initialize() error {
 		category TEXT,
 		enabled BOOLEAN DEFAULT 1,
 		last_updated DATETIME,
-		domain_count INTEGER DEFAULT 0
+		domain_count INTEGER DEFAULT 0,
+		format TEXT,
+		etag TEXT,
+		last_modified TEXT,
+		checksum TEXT,
+		parse_errors INTEGER DEFAULT 0,
+		last_error TEXT,
+		last_checked DATETIME,
+		hits INTEGER DEFAULT 0,
+		origin TEXT DEFAULT 'config',
+		zone TEXT,
+		update_interval INTEGER DEFAULT 0,
+		enabled_override BOOLEAN,
+		shadow BOOLEAN DEFAULT 0,
+		sha256 TEXT,
+		checksum_url TEXT,
+		signature_url TEXT,
+		public_key TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS blocklist_versions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		cause TEXT,
+		status TEXT NOT NULL DEFAULT 'applied',
+		domain_count INTEGER DEFAULT 0,
+		added INTEGER DEFAULT 0,
+		removed INTEGER DEFAULT 0,
+		summary TEXT,
+		snapshot BLOB
+	);
+
+	CREATE TABLE IF NOT EXISTS pattern_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		pattern TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		action TEXT NOT NULL DEFAULT 'block',
+		note TEXT,
+		enabled BOOLEAN DEFAULT 1,
+		hits INTEGER DEFAULT 0,
+		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (pattern, kind, action)
 	);
 	`
 
-	_, err := db.conn.Exec(schema)
-	return err
+	if _, err := db.conn.Exec(schema); err != nil {
+		return err
+	}
+
+	return db.migrate()
+}
+
+// migrate adds the columns introduced after a table was first created
+func (db *DB) migrate() error {
+	columns := []struct {
+		table, name, definition string
+	}{
+		{"blocklist_sources", "format", "TEXT"},
+		{"blocklist_sources", "etag", "TEXT"},
+		{"blocklist_sources", "last_modified", "TEXT"},
+		{"blocklist_sources", "checksum", "TEXT"},
+		{"blocklist_sources", "parse_errors", "INTEGER DEFAULT 0"},
+		{"blocklist_sources", "last_error", "TEXT"},
+		{"blocklist_sources", "last_checked", "DATETIME"},
+		{"blocklist_sources", "hits", "INTEGER DEFAULT 0"},
+		{"blocklist_sources", "origin", "TEXT DEFAULT 'config'"},
+		{"blocklist_sources", "zone", "TEXT"},
+		{"blocklist_sources", "update_interval", "INTEGER DEFAULT 0"},
+		{"blocklist_sources", "enabled_override", "BOOLEAN"},
+		{"blocklist_sources", "sha256", "TEXT"},
+		{"blocklist_sources", "checksum_url", "TEXT"},
+		{"blocklist_sources", "signature_url", "TEXT"},
+		{"blocklist_sources", "public_key", "TEXT"},
+		{"blocklist_sources", "shadow", "BOOLEAN DEFAULT 0"},
+		{"blocklist", "sources", "TEXT"},
+		{"custom_entries", "options", "TEXT"},
+	}
+
+	for _, col := range columns {
+		exists, err := db.hasColumn(col.table, col.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.definition)
+		if _, err := db.conn.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.conn.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_sources_name ON blocklist_sources(name)"); err != nil {
+		return err
+	}
+
+	// Scoped entries for one domain differ only in their options, which were
+	// not part of the key of custom_entries at first
+	keys, err := db.tableColumns("custom_entries")
+	if err != nil {
+		return err
+	}
+	if keys["options"] == 0 {
+		if err := db.rekeyCustomEntries(); err != nil {
+			return fmt.Errorf("custom_entries: %w", err)
+		}
+	}
+
+	// Whitelist entries added through the API used to have their own table
+	var legacy int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'whitelist'").Scan(&legacy); err != nil {
+		return err
+	}
+	if legacy > 0 {
+		_, err := db.conn.Exec(`INSERT OR IGNORE INTO custom_entries (domain, list, origin, added_at)
+			SELECT domain, 'allow', 'api', added_at FROM whitelist;
+			DROP TABLE whitelist;`)
+		return err
+	}
+	return nil
+}
+
+// rekeyCustomEntries rebuilds custom_entries with options in the primary key
+func (db *DB) rekeyCustomEntries() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	CREATE TABLE custom_entries_rekeyed (
+		domain TEXT NOT NULL,
+		list TEXT NOT NULL,
+		origin TEXT NOT NULL,
+		file TEXT NOT NULL DEFAULT '',
+		category TEXT,
+		note TEXT,
+		enabled BOOLEAN DEFAULT 1,
+		options TEXT NOT NULL DEFAULT '',
+		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (domain, list, origin, file, options)
+	);
+	INSERT OR IGNORE INTO custom_entries_rekeyed
+		SELECT domain, list, origin, file, category, note, enabled, COALESCE(options, ''), added_at
+		FROM custom_entries;
+	DROP TABLE custom_entries;
+	ALTER TABLE custom_entries_rekeyed RENAME TO custom_entries;`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	columns, err := db.tableColumns(table)
+	if err != nil {
+		return false, err
+	}
+	_, ok := columns[column]
+	return ok, nil
+}
+
+// tableColumns returns the columns of a table with their position in the
+// primary key, 0 for columns outside it
+func (db *DB) tableColumns(table string) (map[string]int, error) {
+	rows, err := db.conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]int)
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			ctype     string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dfltValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = pk
+	}
+	return columns, rows.Err()
 }
 
 func (db *DB) LogBlockedQuery(domain, clientIP string, timestamp time.Time) error {
@@ -171,7 +552,9 @@ func (db *DB) GetTopBlockedDomains(limit int) (map[string]int, error) {
 	return results, rows.Err()
 }
 
-func (db *DB) SaveBlocklist(domains map[string]bool) error {
+// SaveBlocklist replaces the blocklist. Each domain maps to the names of
+// the sources that list it.
+func (db *DB) SaveBlocklist(domains map[string][]string) error {
 	tx, err := db.conn.Begin()
 	if err != nil {
 		return err
@@ -184,14 +567,14 @@ func (db *DB) SaveBlocklist(domains map[string]bool) error {
 	}
 
 	// Insert new blocklist
-	stmt, err := tx.Prepare("INSERT INTO blocklist (domain) VALUES (?)")
+	stmt, err := tx.Prepare("INSERT INTO blocklist (domain, sources) VALUES (?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	for domain := range domains {
-		if _, err := stmt.Exec(domain); err != nil {
+	for domain, sources := range domains {
+		if _, err := stmt.Exec(domain, encodeSources(sources)); err != nil {
 			return err
 		}
 	}
@@ -199,58 +582,494 @@ func (db *DB) SaveBlocklist(domains map[string]bool) error {
 	return tx.Commit()
 }
 
-func (db *DB) LoadBlocklist() (map[string]bool, error) {
-	query := "SELECT domain FROM blocklist"
+// UpdateBlocklist applies the difference between two versions of the
+// blocklist instead of rewriting the whole table. upserts holds new domains
+// and domains whose sources changed.
+func (db *DB) UpdateBlocklist(upserts map[string][]string, removed []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	del, err := tx.Prepare("DELETE FROM blocklist WHERE domain = ?")
+	if err != nil {
+		return err
+	}
+	defer del.Close()
+
+	for _, domain := range removed {
+		if _, err := del.Exec(domain); err != nil {
+			return err
+		}
+	}
+
+	ins, err := tx.Prepare(`INSERT INTO blocklist (domain, sources) VALUES (?, ?)
+		ON CONFLICT(domain) DO UPDATE SET sources = excluded.sources`)
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+
+	for domain, sources := range upserts {
+		if _, err := ins.Exec(domain, encodeSources(sources)); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LoadBlocklist returns every blocked domain with the sources that list it.
+// Rows written before sources were tracked have no sources.
+func (db *DB) LoadBlocklist() (map[string][]string, error) {
+	query := "SELECT domain, COALESCE(sources, '') FROM blocklist"
 	rows, err := db.conn.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	domains := make(map[string]bool)
+	domains := make(map[string][]string)
 	for rows.Next() {
-		var domain string
-		if err := rows.Scan(&domain); err != nil {
+		var domain, sources string
+		if err := rows.Scan(&domain, &sources); err != nil {
 			return nil, err
 		}
-		domains[domain] = true
+		domains[domain] = decodeSources(sources)
 	}
 
 	return domains, rows.Err()
 }
 
-func (db *DB) AddToWhitelist(domain string) error {
-	query := "INSERT OR REPLACE INTO whitelist (domain) VALUES (?)"
-	_, err := db.conn.Exec(query, domain)
+func encodeSources(sources []string) string {
+	if len(sources) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(sources)
+	return string(data)
+}
+
+func decodeSources(data string) []string {
+	var sources []string
+	if data != "" {
+		json.Unmarshal([]byte(data), &sources)
+	}
+	return sources
+}
+
+func (db *DB) AddCustomEntry(entry CustomEntry) error {
+	query := `INSERT OR REPLACE INTO custom_entries (domain, list, origin, file, category, note, enabled, options)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.conn.Exec(query, entry.Domain, entry.List, entry.Origin, entry.File, entry.Category, entry.Note, entry.Enabled, entry.Options)
+	return err
+}
+
+func (db *DB) DeleteCustomEntry(domain, list, origin, file string) error {
+	query := "DELETE FROM custom_entries WHERE domain = ? AND list = ? AND origin = ? AND file = ?"
+	_, err := db.conn.Exec(query, domain, list, origin, file)
 	return err
 }
 
-func (db *DB) RemoveFromWhitelist(domain string) error {
-	query := "DELETE FROM whitelist WHERE domain = ?"
+// ReplaceCustomEntries swaps the entries of one origin and file for a new set,
+// keeping the time each surviving entry was first added
+func (db *DB) ReplaceCustomEntries(origin, file string, entries []CustomEntry) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	added := make(map[string]time.Time)
+	rows, err := tx.Query("SELECT domain, list, options, added_at FROM custom_entries WHERE origin = ? AND file = ?", origin, file)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var domain, list, options string
+		var addedAt time.Time
+		if err := rows.Scan(&domain, &list, &options, &addedAt); err != nil {
+			rows.Close()
+			return err
+		}
+		added[list+" "+domain+" "+options] = addedAt
+	}
+	rows.Close()
+
+	if _, err := tx.Exec("DELETE FROM custom_entries WHERE origin = ? AND file = ?", origin, file); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO custom_entries (domain, list, origin, file, category, note, enabled, options, added_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, entry := range entries {
+		addedAt, ok := added[entry.List+" "+entry.Domain+" "+entry.Options]
+		if !ok {
+			addedAt = now
+		}
+		if _, err := stmt.Exec(entry.Domain, entry.List, origin, file, entry.Category, entry.Note, entry.Enabled, entry.Options, addedAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) LoadCustomEntries() ([]CustomEntry, error) {
+	query := `SELECT domain, list, origin, file, COALESCE(category, ''), COALESCE(note, ''), enabled,
+		COALESCE(options, ''), added_at
+		FROM custom_entries ORDER BY list, domain`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []CustomEntry
+	for rows.Next() {
+		var e CustomEntry
+		if err := rows.Scan(&e.Domain, &e.List, &e.Origin, &e.File, &e.Category, &e.Note, &e.Enabled, &e.Options, &e.AddedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func (db *DB) AddOverride(o Override) (int64, error) {
+	query := "INSERT INTO overrides (domain, action, client, note, expires_at) VALUES (?, ?, ?, ?, ?)"
+	res, err := db.conn.Exec(query, o.Domain, o.Action, o.Client, o.Note, o.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (db *DB) DeleteOverride(id int64) error {
+	query := "DELETE FROM overrides WHERE id = ?"
+	_, err := db.conn.Exec(query, id)
+	return err
+}
+
+func (db *DB) LoadOverrides() ([]Override, error) {
+	query := `SELECT id, domain, action, client, COALESCE(note, ''), expires_at, created_at
+		FROM overrides ORDER BY expires_at`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var overrides []Override
+	for rows.Next() {
+		var o Override
+		if err := rows.Scan(&o.ID, &o.Domain, &o.Action, &o.Client, &o.Note, &o.ExpiresAt, &o.CreatedAt); err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, o)
+	}
+
+	return overrides, rows.Err()
+}
+
+func (db *DB) AddPause(p Pause) (int64, error) {
+	query := "INSERT INTO pauses (scope, target, paused_by, note, started_at, until) VALUES (?, ?, ?, ?, ?, ?)"
+	res, err := db.conn.Exec(query, p.Scope, p.Target, p.PausedBy, p.Note, p.Started, p.Until)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (db *DB) DeletePause(id int64) error {
+	query := "DELETE FROM pauses WHERE id = ?"
+	_, err := db.conn.Exec(query, id)
+	return err
+}
+
+func (db *DB) LoadPauses() ([]Pause, error) {
+	query := `SELECT id, scope, target, COALESCE(paused_by, ''), COALESCE(note, ''), started_at, until
+		FROM pauses ORDER BY until`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pauses []Pause
+	for rows.Next() {
+		var p Pause
+		if err := rows.Scan(&p.ID, &p.Scope, &p.Target, &p.PausedBy, &p.Note, &p.Started, &p.Until); err != nil {
+			return nil, err
+		}
+		pauses = append(pauses, p)
+	}
+
+	return pauses, rows.Err()
+}
+
+// SaveGardenDomains stores walled garden domains, keeping the approval and
+// first sighting of the ones already stored
+func (db *DB) SaveGardenDomains(domains []GardenDomain) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO garden_domains (domain, parent, client, hits, approved, first_seen, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(domain) DO UPDATE SET hits = excluded.hits, last_seen = excluded.last_seen,
+			approved = approved OR excluded.approved`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, d := range domains {
+		if _, err := stmt.Exec(d.Domain, d.Parent, d.Client, d.Hits, d.Approved, d.FirstSeen, d.LastSeen); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) SetGardenApproved(domain string, approved bool) error {
+	query := "UPDATE garden_domains SET approved = ? WHERE domain = ?"
+	res, err := db.conn.Exec(query, approved, domain)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *DB) DeleteGardenDomain(domain string) error {
+	query := "DELETE FROM garden_domains WHERE domain = ?"
 	_, err := db.conn.Exec(query, domain)
 	return err
 }
 
-func (db *DB) GetWhitelist() ([]string, error) {
-	query := "SELECT domain FROM whitelist ORDER BY domain"
+func (db *DB) LoadGardenDomains() ([]GardenDomain, error) {
+	query := `SELECT domain, parent, client, hits, approved, first_seen, last_seen
+		FROM garden_domains ORDER BY domain`
 	rows, err := db.conn.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var domains []string
+	var domains []GardenDomain
 	for rows.Next() {
-		var domain string
-		if err := rows.Scan(&domain); err != nil {
+		var d GardenDomain
+		if err := rows.Scan(&d.Domain, &d.Parent, &d.Client, &d.Hits, &d.Approved, &d.FirstSeen, &d.LastSeen); err != nil {
 			return nil, err
 		}
-		domains = append(domains, domain)
+		domains = append(domains, d)
 	}
 
 	return domains, rows.Err()
 }
 
+// AddShadowHits adds to the stored shadow counts
+func (db *DB) AddShadowHits(hits []ShadowHit) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO shadow_hits (stage, domain, day, hits, live_blocked, last_seen)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(stage, domain, day) DO UPDATE SET hits = hits + excluded.hits,
+			live_blocked = live_blocked + excluded.live_blocked, last_seen = excluded.last_seen`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, h := range hits {
+		if _, err := stmt.Exec(h.Stage, h.Domain, h.Day, h.Hits, h.LiveBlocked, h.LastSeen); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LoadShadowHits sums the shadow counts of each stage and domain from the
+// day since (YYYY-MM-DD) on
+func (db *DB) LoadShadowHits(since string) ([]ShadowHit, error) {
+	query := `SELECT stage, domain, SUM(hits), SUM(live_blocked), MAX(last_seen)
+		FROM shadow_hits WHERE day >= ? GROUP BY stage, domain`
+	rows, err := db.conn.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []ShadowHit
+	for rows.Next() {
+		var h ShadowHit
+		var lastSeen string
+		if err := rows.Scan(&h.Stage, &h.Domain, &h.Hits, &h.LiveBlocked, &lastSeen); err != nil {
+			return nil, err
+		}
+		h.LastSeen, _ = time.Parse("2006-01-02 15:04:05.999999999-07:00", lastSeen)
+		hits = append(hits, h)
+	}
+
+	return hits, rows.Err()
+}
+
+func (db *DB) DeleteShadowHitsBefore(day string) error {
+	query := "DELETE FROM shadow_hits WHERE day < ?"
+	_, err := db.conn.Exec(query, day)
+	return err
+}
+
+// GetBlockedSince counts the blocked queries since a time and returns the
+// most blocked domains
+func (db *DB) GetBlockedSince(since time.Time, limit int) (int64, []DomainCount, error) {
+	var total int64
+	query := "SELECT COUNT(*) FROM blocked_queries WHERE timestamp > ?"
+	if err := db.conn.QueryRow(query, since).Scan(&total); err != nil {
+		return 0, nil, err
+	}
+
+	query = `SELECT domain, COUNT(*) AS count FROM blocked_queries WHERE timestamp > ?
+		GROUP BY domain ORDER BY count DESC LIMIT ?`
+	rows, err := db.conn.Query(query, since, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var top []DomainCount
+	for rows.Next() {
+		var d DomainCount
+		if err := rows.Scan(&d.Domain, &d.Count); err != nil {
+			return 0, nil, err
+		}
+		top = append(top, d)
+	}
+
+	return total, top, rows.Err()
+}
+
+// AddFirstSeen stores when domains were first seen, as Unix seconds. Domains
+// already stored keep their first time.
+func (db *DB) AddFirstSeen(seen map[string]int64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT OR IGNORE INTO first_seen (domain, seen) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for domain, at := range seen {
+		if _, err := stmt.Exec(domain, at); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// LoadFirstSeen returns when each known domain was first seen, as Unix
+// seconds
+func (db *DB) LoadFirstSeen() (map[string]int64, error) {
+	rows, err := db.conn.Query("SELECT domain, seen FROM first_seen")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[string]int64)
+	for rows.Next() {
+		var domain string
+		var at int64
+		if err := rows.Scan(&domain, &at); err != nil {
+			return nil, err
+		}
+		seen[domain] = at
+	}
+
+	return seen, rows.Err()
+}
+
+// SaveBudgetUsage stores the usage of budgets, replacing that of earlier
+// periods
+func (db *DB) SaveBudgetUsage(usage []BudgetUsage) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO budget_usage (budget, client, period, used_seconds, last_seen)
+		VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, u := range usage {
+		if _, err := stmt.Exec(u.Budget, u.Client, u.Period, int64(u.Used/time.Second), u.LastSeen); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (db *DB) LoadBudgetUsage() ([]BudgetUsage, error) {
+	query := `SELECT budget, client, period, used_seconds, last_seen FROM budget_usage`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []BudgetUsage
+	for rows.Next() {
+		var u BudgetUsage
+		var seconds int64
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&u.Budget, &u.Client, &u.Period, &seconds, &lastSeen); err != nil {
+			return nil, err
+		}
+		u.Used = time.Duration(seconds) * time.Second
+		u.LastSeen = lastSeen.Time
+		usage = append(usage, u)
+	}
+
+	return usage, rows.Err()
+}
+
+// ResetBudgetUsage clears the usage of a budget, for one client or for all
+// of them when client is empty
+func (db *DB) ResetBudgetUsage(budget, client string) error {
+	query := "DELETE FROM budget_usage WHERE budget = ? AND (? = '' OR client = ?)"
+	_, err := db.conn.Exec(query, budget, client, client)
+	return err
+}
+
 func (db *DB) CleanupOldLogs(days int) error {
 	query := "DELETE FROM blocked_queries WHERE timestamp < datetime('now', '-' || ? || ' days')"
 	_, err := db.conn.Exec(query, days)
@@ -272,3 +1091,275 @@ func (db *DB) SetSetting(key, value string) error {
 	_, err := db.conn.Exec(query, key, value)
 	return err
 }
+
+func (db *DB) AddPatternRule(pattern, kind, action, note string) (int64, error) {
+	query := "INSERT INTO pattern_rules (pattern, kind, action, note) VALUES (?, ?, ?, ?)"
+	res, err := db.conn.Exec(query, pattern, kind, action, note)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (db *DB) DeletePatternRule(id int64) error {
+	query := "DELETE FROM pattern_rules WHERE id = ?"
+	_, err := db.conn.Exec(query, id)
+	return err
+}
+
+func (db *DB) SetPatternRuleEnabled(id int64, enabled bool) error {
+	query := "UPDATE pattern_rules SET enabled = ? WHERE id = ?"
+	_, err := db.conn.Exec(query, enabled, id)
+	return err
+}
+
+func (db *DB) LoadPatternRules() ([]PatternRule, error) {
+	query := "SELECT id, pattern, kind, action, COALESCE(note, ''), enabled, hits FROM pattern_rules ORDER BY id"
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []PatternRule
+	for rows.Next() {
+		var r PatternRule
+		if err := rows.Scan(&r.ID, &r.Pattern, &r.Kind, &r.Action, &r.Note, &r.Enabled, &r.Hits); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	return rules, rows.Err()
+}
+
+// SavePatternRuleHits stores the current hit counters, keyed by rule ID
+func (db *DB) SavePatternRuleHits(hits map[int64]uint64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE pattern_rules SET hits = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for id, count := range hits {
+		if _, err := stmt.Exec(int64(count), id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SaveBlocklistSource stores the state of a source, keyed by name
+func (db *DB) SaveBlocklistSource(src BlocklistSource) error {
+	query := `
+	INSERT INTO blocklist_sources (name, url, category, format, enabled, etag, last_modified, checksum,
+		domain_count, parse_errors, last_error, last_checked, last_updated)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(name) DO UPDATE SET
+		url = excluded.url,
+		category = excluded.category,
+		format = excluded.format,
+		enabled = excluded.enabled,
+		etag = excluded.etag,
+		last_modified = excluded.last_modified,
+		checksum = excluded.checksum,
+		domain_count = excluded.domain_count,
+		parse_errors = excluded.parse_errors,
+		last_error = excluded.last_error,
+		last_checked = excluded.last_checked,
+		last_updated = excluded.last_updated
+	`
+	_, err := db.conn.Exec(query, src.Name, src.URL, src.Category, src.Format, src.Enabled,
+		src.ETag, src.LastModified, src.Checksum, src.DomainCount, src.ParseErrors, src.LastError,
+		nullTime(src.LastChecked), nullTime(src.LastUpdated))
+	return err
+}
+
+func (db *DB) LoadBlocklistSources() ([]BlocklistSource, error) {
+	query := `
+	SELECT name, url, COALESCE(category, ''), COALESCE(format, ''), enabled, COALESCE(etag, ''),
+		COALESCE(last_modified, ''), COALESCE(checksum, ''), domain_count, COALESCE(parse_errors, 0),
+		COALESCE(last_error, ''), COALESCE(hits, 0), last_checked, last_updated,
+		COALESCE(origin, 'config'), COALESCE(zone, ''), COALESCE(update_interval, 0), enabled_override,
+		COALESCE(sha256, ''), COALESCE(checksum_url, ''), COALESCE(signature_url, ''), COALESCE(public_key, ''),
+		COALESCE(shadow, 0)
+	FROM blocklist_sources ORDER BY name
+	`
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []BlocklistSource
+	for rows.Next() {
+		var src BlocklistSource
+		var lastChecked, lastUpdated sql.NullTime
+		var override sql.NullBool
+		if err := rows.Scan(&src.Name, &src.URL, &src.Category, &src.Format, &src.Enabled, &src.ETag,
+			&src.LastModified, &src.Checksum, &src.DomainCount, &src.ParseErrors, &src.LastError,
+			&src.Hits, &lastChecked, &lastUpdated, &src.Origin, &src.Zone, &src.UpdateInterval, &override,
+			&src.SHA256, &src.ChecksumURL, &src.SignatureURL, &src.PublicKey, &src.Shadow); err != nil {
+			return nil, err
+		}
+		src.LastChecked = lastChecked.Time
+		src.LastUpdated = lastUpdated.Time
+		if override.Valid {
+			src.EnabledOverride = &override.Bool
+		}
+		sources = append(sources, src)
+	}
+
+	return sources, rows.Err()
+}
+
+// AddBlocklistSource stores a source added at runtime
+func (db *DB) AddBlocklistSource(src BlocklistSource) error {
+	query := `INSERT INTO blocklist_sources (name, url, category, format, zone, update_interval, enabled,
+		sha256, checksum_url, signature_url, public_key, shadow, origin)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'api')`
+	_, err := db.conn.Exec(query, src.Name, src.URL, src.Category, src.Format, src.Zone, src.UpdateInterval, src.Enabled,
+		src.SHA256, src.ChecksumURL, src.SignatureURL, src.PublicKey, src.Shadow)
+	return err
+}
+
+// UpdateBlocklistSource changes the definition of a source added at runtime
+func (db *DB) UpdateBlocklistSource(src BlocklistSource) error {
+	query := `UPDATE blocklist_sources SET url = ?, category = ?, format = ?, zone = ?, update_interval = ?, enabled = ?,
+		sha256 = ?, checksum_url = ?, signature_url = ?, public_key = ?, shadow = ?
+		WHERE name = ? AND origin = 'api'`
+	_, err := db.conn.Exec(query, src.URL, src.Category, src.Format, src.Zone, src.UpdateInterval, src.Enabled,
+		src.SHA256, src.ChecksumURL, src.SignatureURL, src.PublicKey, src.Shadow, src.Name)
+	return err
+}
+
+// SetBlocklistSourceEnabled enables or disables a source. For sources from
+// config.yaml the value is kept as an override of the file.
+func (db *DB) SetBlocklistSourceEnabled(name, url string, enabled bool) error {
+	query := `INSERT INTO blocklist_sources (name, url, enabled, enabled_override) VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET enabled = excluded.enabled, enabled_override = excluded.enabled_override`
+	_, err := db.conn.Exec(query, name, url, enabled, enabled)
+	return err
+}
+
+func (db *DB) DeleteBlocklistSource(name string) error {
+	query := "DELETE FROM blocklist_sources WHERE name = ?"
+	_, err := db.conn.Exec(query, name)
+	return err
+}
+
+// SaveBlocklistSourceHits stores the hit counters of sources, keyed by name
+func (db *DB) SaveBlocklistSourceHits(hits map[string]uint64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE blocklist_sources SET hits = ? WHERE name = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for name, count := range hits {
+		if _, err := stmt.Exec(int64(count), name); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// AddBlocklistVersion records a version. snapshot is stored compressed and
+// may be nil for versions that were not applied.
+func (db *DB) AddBlocklistVersion(v BlocklistVersion, snapshot map[string][]string) (int64, error) {
+	var blob []byte
+	if snapshot != nil {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if err := json.NewEncoder(zw).Encode(snapshot); err != nil {
+			return 0, err
+		}
+		if err := zw.Close(); err != nil {
+			return 0, err
+		}
+		blob = buf.Bytes()
+	}
+
+	query := `INSERT INTO blocklist_versions (created_at, cause, status, domain_count, added, removed, summary, snapshot)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := db.conn.Exec(query, v.CreatedAt, v.Cause, v.Status, v.DomainCount, v.Added, v.Removed, v.Summary, blob)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ListBlocklistVersions returns the most recent versions first
+func (db *DB) ListBlocklistVersions(limit int) ([]BlocklistVersion, error) {
+	query := `SELECT id, created_at, COALESCE(cause, ''), status, domain_count, added, removed,
+		COALESCE(summary, ''), snapshot IS NOT NULL
+		FROM blocklist_versions ORDER BY id DESC LIMIT ?`
+	rows, err := db.conn.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []BlocklistVersion
+	for rows.Next() {
+		var v BlocklistVersion
+		if err := rows.Scan(&v.ID, &v.CreatedAt, &v.Cause, &v.Status, &v.DomainCount, &v.Added, &v.Removed,
+			&v.Summary, &v.HasSnapshot); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	return versions, rows.Err()
+}
+
+// LoadBlocklistSnapshot returns the blocklist stored with a version, or nil
+// if the version has no snapshot
+func (db *DB) LoadBlocklistSnapshot(id int64) (map[string][]string, error) {
+	var blob []byte
+	query := "SELECT snapshot FROM blocklist_versions WHERE id = ?"
+	if err := db.conn.QueryRow(query, id).Scan(&blob); err != nil {
+		return nil, err
+	}
+	if blob == nil {
+		return nil, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var snapshot map[string][]string
+	if err := json.NewDecoder(zr).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// PruneBlocklistVersions keeps only the newest versions
+func (db *DB) PruneBlocklistVersions(keep int) error {
+	query := `DELETE FROM blocklist_versions WHERE id NOT IN
+		(SELECT id FROM blocklist_versions ORDER BY id DESC LIMIT ?)`
+	_, err := db.conn.Exec(query, keep)
+	return err
+}
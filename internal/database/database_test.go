@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestScopedCustomEntriesSurviveReplace(t *testing.T) {
+	db, err := New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	entries := []CustomEntry{
+		{Domain: "discord.com", List: "block", Enabled: true, Options: `{"groups":["kids"]}`},
+		{Domain: "discord.com", List: "block", Enabled: true, Options: `{"clients":["192.168.1.20"]}`},
+		{Domain: "discord.com", List: "allow", Enabled: true},
+		{Domain: "discord.com", List: "block", Enabled: true, Options: `{"groups":["kids"]}`},
+	}
+	for round := 0; round < 2; round++ {
+		if err := db.ReplaceCustomEntries("file", "custom.yaml", entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := db.LoadCustomEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 3 {
+		t.Fatalf("loaded %d entries, want 3: %+v", len(loaded), loaded)
+	}
+
+	if err := db.AddCustomEntry(CustomEntry{Domain: "discord.com", List: "block", Origin: "api", Enabled: true, Options: `{"match":"exact"}`}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddCustomEntry(CustomEntry{Domain: "discord.com", List: "block", Origin: "api", Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, _ = db.LoadCustomEntries(); len(loaded) != 5 {
+		t.Errorf("loaded %d entries after adding two from the API, want 5", len(loaded))
+	}
+}
+
+func TestCustomEntriesRekeyed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`
+	CREATE TABLE custom_entries (
+		domain TEXT NOT NULL,
+		list TEXT NOT NULL,
+		origin TEXT NOT NULL,
+		file TEXT NOT NULL DEFAULT '',
+		category TEXT,
+		note TEXT,
+		enabled BOOLEAN DEFAULT 1,
+		options TEXT,
+		added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (domain, list, origin, file)
+	);
+	INSERT INTO custom_entries (domain, list, origin, note) VALUES ('a.com', 'block', 'api', 'kept');
+	INSERT INTO custom_entries (domain, list, origin, options) VALUES ('b.com', 'block', 'file', '{"match":"exact"}');`)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	keys, err := db.tableColumns("custom_entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys["options"] == 0 {
+		t.Error("options is not part of the primary key")
+	}
+
+	loaded, err := db.LoadCustomEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 2 || loaded[0].Note != "kept" || loaded[1].Options != `{"match":"exact"}` {
+		t.Errorf("entries after the migration: %+v", loaded)
+	}
+}
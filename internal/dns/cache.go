@@ -1,6 +1,7 @@
 package dns
 
 import (
+	"strings"
 	"sync"
 	"time"
 
@@ -75,6 +76,42 @@ func (c *DNSCache) Clear() {
 	c.entries = make(map[string]*cacheEntry)
 }
 
+// RemoveDomains drops the cached responses for the given names and their
+// subdomains, for every query type
+func (c *DNSCache) RemoveDomains(domains []string) int {
+	names := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		names[strings.TrimSuffix(strings.ToLower(domain), ".")] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key := range c.entries {
+		name := key
+		if i := strings.LastIndex(name, ":"); i >= 0 {
+			name = name[:i]
+		}
+		name = strings.TrimSuffix(strings.ToLower(name), ".")
+
+		// Walk up the parents, since a rule for a domain covers its subdomains
+		for name != "" {
+			if names[name] {
+				delete(c.entries, key)
+				removed++
+				break
+			}
+			i := strings.Index(name, ".")
+			if i < 0 {
+				break
+			}
+			name = name[i+1:]
+		}
+	}
+	return removed
+}
+
 func (c *DNSCache) Size() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/filter"
+	"github.com/miekg/dns"
+)
+
+// handleRPZPolicy answers a query with the action of a response policy zone rule
+func (s *Server) handleRPZPolicy(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg, domain string, clientIP string, policy *filter.RPZPolicy) {
+	s.stats.mu.Lock()
+	s.stats.BlockedQueries++
+	s.stats.mu.Unlock()
+
+	s.log.Infof("🛡️  BLOCKED: %s (reason: %s, action: %s)", domain, policy.Reason(), policy.Action)
+	s.db.LogBlockedQuery(domain, clientIP, time.Now())
+
+	switch policy.Action {
+	case filter.RPZActionDrop:
+		// No response at all, the client times out
+		return
+
+	case filter.RPZActionNXDOMAIN:
+		m.SetRcode(r, dns.RcodeNameError)
+
+	case filter.RPZActionNODATA:
+		// NOERROR with an empty answer
+
+	case filter.RPZActionLocalData:
+		qtype := r.Question[0].Qtype
+		m.Answer = policy.Answer(r.Question[0].Name, qtype)
+
+		// Follow a rewrite to another name so the client gets its records
+		if len(m.Answer) == 1 && qtype != dns.TypeCNAME {
+			if cname, ok := m.Answer[0].(*dns.CNAME); ok {
+				m.Answer = append(m.Answer, s.resolveTarget(cname.Target, qtype)...)
+			}
+		}
+	}
+
+	w.WriteMsg(m)
+}
+
+// resolveTarget looks up the target of a local-data CNAME upstream
+func (s *Server) resolveTarget(name string, qtype uint16) []dns.RR {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+
+	client := &dns.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	response, _, err := client.Exchange(query, s.upstreamPool.Get())
+	if err != nil {
+		s.log.Warnf("Failed to resolve RPZ target %s: %v", name, err)
+		return nil
+	}
+	return response.Answer
+}
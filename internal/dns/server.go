@@ -82,6 +82,17 @@ func (s *Server) ClearCache() {
 	s.log.Info("DNS cache cleared")
 }
 
+// ForgetDomains removes the cached responses for the given names and their
+// subdomains. A nil slice clears the whole cache.
+func (s *Server) ForgetDomains(domains []string) {
+	if domains == nil {
+		s.ClearCache()
+		return
+	}
+	removed := s.cache.RemoveDomains(domains)
+	s.log.Infof("DNS cache: dropped %d responses for %d changed domains", removed, len(domains))
+}
+
 // GetStats returns current server statistics
 func (s *Server) GetStats() (total, blocked, cached uint64) {
 	s.stats.mu.RLock()
@@ -117,8 +128,12 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 		s.log.Debugf("DNS Query: %s from %s (type: %s)", domain, clientIP, dns.TypeToString[question.Qtype])
 	}
 
-	// Check cache first
-	if cachedResponse := s.cache.Get(domain, question.Qtype); cachedResponse != nil {
+	// Feed the tunneling detector every query, cached or not
+	s.filter.ObserveQuery(domain, clientIP, question.Qtype)
+
+	// Check cache first, unless the answer depends on the client or the time
+	cacheable := !s.cfg.Filtering.Enabled || s.filter.IsCacheable(domain, clientIP)
+	if cachedResponse := s.cache.Get(domain, question.Qtype); cacheable && cachedResponse != nil {
 		s.stats.mu.Lock()
 		s.stats.CachedResponses++
 		s.stats.mu.Unlock()
@@ -129,17 +144,60 @@ func (s *Server) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 	}
 
 	// Check if domain should be blocked
+	checkRPZ := s.cfg.Filtering.Enabled
 	if s.cfg.Filtering.Enabled {
-		blocked, reason := s.filter.ShouldBlock(domain, clientIP)
-		if blocked {
-			s.log.Infof("🛡️  BLOCKED: %s (reason: %s)", domain, reason)
-			s.handleBlockedDomain(w, r, m, domain, clientIP, reason)
+		// While filtering is paused for the client the query is answered
+		// normally, but what would have been blocked is still logged. The
+		// answer is not cached so it is not served once the pause ends.
+		if pause := s.filter.PauseFor(clientIP); pause != nil {
+			if blocked, reason := s.filter.WouldBlock(domain, clientIP); blocked {
+				s.log.Infof("⏸️  PAUSED: %s from %s would be blocked (reason: %s, paused by %s)", domain, clientIP, reason, pause.PausedBy)
+			}
+			s.forwardToUpstream(w, r, m, domain, question.Qtype, false, false)
+			return
+		}
+
+		if s.filter.OverrideFor(domain, clientIP) != nil {
+			// A temporary override wins over rewrites and policy zones as
+			// well; the override stage applies it below
+			checkRPZ = false
+		} else {
+			// DNS canaries keep encrypted DNS off whatever the rewrites
+			// and policy zones say
+			if policy := s.filter.CheckDNSCanary(domain); policy != nil {
+				s.handleRPZPolicy(w, r, m, domain, clientIP, policy)
+				return
+			}
+
+			// Rewrites from custom blocklist files come first
+			if policy := s.filter.CheckRewrite(domain, clientIP); policy != nil {
+				s.handleRPZPolicy(w, r, m, domain, clientIP, policy)
+				return
+			}
+
+			// Response policy zones take precedence over the other filters
+			if policy := s.filter.CheckRPZ(domain); policy != nil {
+				if policy.Action != filter.RPZActionPassthru {
+					s.handleRPZPolicy(w, r, m, domain, clientIP, policy)
+					return
+				}
+				checkRPZ = false
+			}
+		}
+
+		switch result := s.filter.Decide(domain, clientIP); result.Verdict {
+		case filter.VerdictBlock:
+			s.log.Infof("🛡️  BLOCKED: %s (reason: %s)", domain, result.Reason)
+			s.handleBlockedDomain(w, r, m, domain, clientIP, result.Reason)
+			return
+		case filter.VerdictRewrite:
+			s.handleRPZPolicy(w, r, m, domain, clientIP, result.Rewrite)
 			return
 		}
 	}
 
 	// Forward to upstream DNS
-	s.forwardToUpstream(w, r, m, domain, question.Qtype)
+	s.forwardToUpstream(w, r, m, domain, question.Qtype, checkRPZ, cacheable)
 }
 
 func (s *Server) handleBlockedDomain(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg, domain string, clientIP string, reason string) {
@@ -194,7 +252,7 @@ func (s *Server) handleBlockedDomain(w dns.ResponseWriter, r *dns.Msg, m *dns.Ms
 	w.WriteMsg(m)
 }
 
-func (s *Server) forwardToUpstream(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg, domain string, qtype uint16) {
+func (s *Server) forwardToUpstream(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg, domain string, qtype uint16, checkRPZ, cacheable bool) {
 	// Get upstream DNS server
 	upstream := s.upstreamPool.Get()
 
@@ -212,8 +270,16 @@ func (s *Server) forwardToUpstream(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg,
 		return
 	}
 
+	// Addresses in the answer may hit an rpz-ip trigger
+	if checkRPZ {
+		if policy := s.filter.CheckRPZResponse(domain, response.Answer); policy != nil && policy.Action != filter.RPZActionPassthru {
+			s.handleRPZPolicy(w, r, m, domain, getClientIP(w), policy)
+			return
+		}
+	}
+
 	// Cache successful response
-	if response.Rcode == dns.RcodeSuccess && len(response.Answer) > 0 {
+	if cacheable && response.Rcode == dns.RcodeSuccess && len(response.Answer) > 0 {
 		s.cache.Set(domain, qtype, response)
 	}
 
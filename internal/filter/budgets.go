@@ -0,0 +1,453 @@
+package filter
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/blockpage"
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+	"github.com/RDXFGXY1/dns-filter-app/internal/database"
+)
+
+// ─── Time Budgets ─────────────────────────────────────────────────────────────
+//
+// A budget gives each client it covers some minutes a day on the sites of a
+// few categories or domains. Active time is estimated from the query stream:
+// queries for the budget's sites less than burst_gap apart count as one burst
+// and the time between them is charged, and a query that starts a new burst
+// is charged a minute. Once the budget is used up the sites are blocked for
+// that client until the next reset.
+
+const (
+	defaultBurstGap = 5 * time.Minute
+	burstCharge     = time.Minute // charged for the first query of a burst
+)
+
+// budgetRule is a compiled budget rule
+type budgetRule struct {
+	config.BudgetRule
+	limit      time.Duration
+	days       timeWindow
+	networks   []*net.IPNet
+	categories map[string]bool
+	domains    map[string]bool
+}
+
+// budgetUsage is the time one client spent on the sites of one budget
+type budgetUsage struct {
+	period time.Time
+	used   time.Duration
+	last   time.Time
+}
+
+// Budget is the state of a budget for a client as shown by the API
+type Budget struct {
+	Name       string    `json:"name"`
+	Client     string    `json:"client"`
+	Categories []string  `json:"categories,omitempty"`
+	Domains    []string  `json:"domains,omitempty"`
+	Limit      int       `json:"limit_minutes"`
+	Used       int       `json:"used_minutes"`
+	Remaining  int       `json:"remaining_minutes"`
+	Exhausted  bool      `json:"exhausted"`
+	LastSeen   time.Time `json:"last_seen,omitempty"`
+	ResetsAt   time.Time `json:"resets_at"`
+}
+
+// loadBudgets compiles the budget rules from the config and reads the usage
+// of the current period from the database
+func (e *Engine) loadBudgets() error {
+	cfg := e.cfg.Filtering.Budgets
+
+	location := time.Local
+	if cfg.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", cfg.Timezone)
+		}
+	}
+	resetAt := 0
+	if cfg.ResetAt != "" {
+		var err error
+		if resetAt, err = parseClock(cfg.ResetAt); err != nil {
+			return err
+		}
+	}
+	burstGap := defaultBurstGap
+	if cfg.BurstGap > 0 {
+		burstGap = time.Duration(cfg.BurstGap) * time.Minute
+	}
+
+	rules := make(map[string]*budgetRule)
+	var order []string
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("budget %d", i+1)
+		}
+		if _, ok := rules[rule.Name]; ok {
+			return fmt.Errorf("budget %s is defined twice", rule.Name)
+		}
+		compiled, err := e.compileBudgetRule(rule)
+		if err != nil {
+			return fmt.Errorf("budget %s: %v", rule.Name, err)
+		}
+		rules[rule.Name] = compiled
+		order = append(order, rule.Name)
+	}
+
+	stored, err := e.db.LoadBudgetUsage()
+	if err != nil {
+		return err
+	}
+	usage := make(map[string]map[string]*budgetUsage)
+	for _, u := range stored {
+		if _, ok := rules[u.Budget]; !ok {
+			continue
+		}
+		if usage[u.Budget] == nil {
+			usage[u.Budget] = make(map[string]*budgetUsage)
+		}
+		usage[u.Budget][u.Client] = &budgetUsage{period: u.Period, used: u.Used, last: u.LastSeen}
+	}
+
+	e.mu.Lock()
+	e.budgetRules = rules
+	e.budgetOrder = order
+	e.budgetUsage = usage
+	e.budgetReset = resetAt
+	e.budgetZone = location
+	e.budgetGap = burstGap
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *Engine) compileBudgetRule(cfg config.BudgetRule) (*budgetRule, error) {
+	if cfg.Minutes <= 0 {
+		return nil, fmt.Errorf("minutes must be positive")
+	}
+	if len(cfg.Categories) == 0 && len(cfg.Domains) == 0 {
+		return nil, fmt.Errorf("needs categories or domains")
+	}
+
+	days, err := parseTimeWindow(cfg.Days, "00:00", "00:00")
+	if err != nil {
+		return nil, err
+	}
+	rule := &budgetRule{
+		BudgetRule: cfg,
+		limit:      time.Duration(cfg.Minutes) * time.Minute,
+		days:       days,
+		categories: make(map[string]bool),
+		domains:    make(map[string]bool),
+	}
+	for _, category := range cfg.Categories {
+		rule.categories[strings.ToLower(category)] = true
+	}
+	for _, domain := range cfg.Domains {
+		if domain = normalizeDomain(domain); domain != "" {
+			rule.domains[domain] = true
+		}
+	}
+	for _, client := range cfg.Clients {
+		network, err := parseClientNetwork(client)
+		if err != nil {
+			return nil, err
+		}
+		rule.networks = append(rule.networks, network)
+	}
+	for _, group := range cfg.Groups {
+		if _, ok := e.clientGroups[group]; !ok {
+			return nil, fmt.Errorf("unknown client group %q", group)
+		}
+	}
+	return rule, nil
+}
+
+// appliesTo reports whether the rule covers ip
+func (r *budgetRule) appliesTo(ip net.IP, groups map[string][]*net.IPNet) bool {
+	if len(r.networks) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range r.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	for _, group := range r.Groups {
+		for _, network := range groups[group] {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// budgetCovers reports whether domain is one of the sites of a budget
+func (e *Engine) budgetCovers(r *budgetRule, domain string) bool {
+	for name := domain; ; {
+		if r.domains[name] {
+			return true
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	if len(r.categories) == 0 {
+		return false
+	}
+	return r.categories[e.domainCategory(domain, r.categories)]
+}
+
+// budgetPeriod returns the start of the budget period that contains t.
+// Must be called with e.mu held.
+func (e *Engine) budgetPeriod(t time.Time) time.Time {
+	local := t.In(e.budgetZone)
+	start := time.Date(local.Year(), local.Month(), local.Day(), e.budgetReset/60, e.budgetReset%60, 0, 0, e.budgetZone)
+	if local.Before(start) {
+		start = start.AddDate(0, 0, -1)
+	}
+	return start
+}
+
+// budgetsFor returns the budgets that apply to clientIP today. Must be
+// called with e.mu held.
+func (e *Engine) budgetsFor(clientIP string, period time.Time) []*budgetRule {
+	ip := net.ParseIP(clientIP)
+	var rules []*budgetRule
+	for _, name := range e.budgetOrder {
+		rule := e.budgetRules[name]
+		if rule.days.onDay(period.Weekday()) && rule.appliesTo(ip, e.clientGroups) {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// checkBudget charges a query of clientIP for domain to the budgets that
+// cover it, and returns the block reason of the first one that is used up
+func (e *Engine) checkBudget(domain, clientIP string) string {
+	if !e.cfg.Filtering.Budgets.Enabled {
+		return ""
+	}
+
+	now := time.Now()
+	e.mu.RLock()
+	period := e.budgetPeriod(now)
+	candidates := e.budgetsFor(clientIP, period)
+	e.mu.RUnlock()
+
+	var rules []*budgetRule
+	for _, rule := range candidates {
+		if e.budgetCovers(rule, domain) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return ""
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rule := range rules {
+		if u := e.usageOf(rule.Name, clientIP, period); u.used >= rule.limit {
+			return "budget:" + rule.Name
+		}
+	}
+	for _, rule := range rules {
+		u := e.usageOf(rule.Name, clientIP, period)
+		if gap := now.Sub(u.last); !u.last.IsZero() && gap <= e.budgetGap {
+			u.used += gap
+		} else {
+			u.used += burstCharge
+		}
+		u.last = now
+		if u.used >= rule.limit {
+			e.log.Infof("Time budget %s used up by %s", rule.Name, clientIP)
+		}
+	}
+	e.budgetDirty = true
+	return ""
+}
+
+// usageOf returns the usage of a budget by a client in period, starting it
+// over when an earlier period ended. Must be called with e.mu held.
+func (e *Engine) usageOf(budget, clientIP string, period time.Time) *budgetUsage {
+	clients := e.budgetUsage[budget]
+	if clients == nil {
+		clients = make(map[string]*budgetUsage)
+		e.budgetUsage[budget] = clients
+	}
+	u := clients[clientIP]
+	if u == nil || !u.period.Equal(period) {
+		u = &budgetUsage{period: period}
+		clients[clientIP] = u
+	}
+	return u
+}
+
+// budgetApplies reports whether a budget covers clientIP today, so answers
+// for it must not come from the cache
+func (e *Engine) budgetApplies(clientIP string) bool {
+	if !e.cfg.Filtering.Budgets.Enabled {
+		return false
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return len(e.budgetsFor(clientIP, e.budgetPeriod(time.Now()))) > 0
+}
+
+// flushBudgetUsage stores the usage charged since the last flush
+func (e *Engine) flushBudgetUsage() {
+	e.mu.Lock()
+	if !e.budgetDirty {
+		e.mu.Unlock()
+		return
+	}
+	var usage []database.BudgetUsage
+	for budget, clients := range e.budgetUsage {
+		for client, u := range clients {
+			usage = append(usage, database.BudgetUsage{
+				Budget:   budget,
+				Client:   client,
+				Period:   u.period,
+				Used:     u.used,
+				LastSeen: u.last,
+			})
+		}
+	}
+	e.budgetDirty = false
+	e.mu.Unlock()
+
+	if err := e.db.SaveBudgetUsage(usage); err != nil {
+		e.log.Warnf("Failed to save budget usage: %v", err)
+	}
+}
+
+// GetBudgets lists the budgets each client has used today, or only those of
+// clientIP with the ones it has not touched yet
+func (e *Engine) GetBudgets(clientIP string) []Budget {
+	now := time.Now()
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	period := e.budgetPeriod(now)
+	resets := period.AddDate(0, 0, 1)
+	var list []Budget
+	add := func(rule *budgetRule, client string, u *budgetUsage) {
+		b := Budget{
+			Name:       rule.Name,
+			Client:     client,
+			Categories: rule.Categories,
+			Domains:    rule.Domains,
+			Limit:      rule.Minutes,
+			ResetsAt:   resets,
+		}
+		if u != nil && u.period.Equal(period) {
+			b.Used = int(u.used / time.Minute)
+			b.LastSeen = u.last
+			b.Exhausted = u.used >= rule.limit
+		}
+		if b.Remaining = b.Limit - b.Used; b.Remaining < 0 || b.Exhausted {
+			b.Remaining = 0
+		}
+		list = append(list, b)
+	}
+
+	if clientIP != "" {
+		for _, rule := range e.budgetsFor(clientIP, period) {
+			add(rule, clientIP, e.budgetUsage[rule.Name][clientIP])
+		}
+		return list
+	}
+	for _, name := range e.budgetOrder {
+		for client, u := range e.budgetUsage[name] {
+			if u.period.Equal(period) {
+				add(e.budgetRules[name], client, u)
+			}
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Client < list[j].Client })
+	return list
+}
+
+// ResetBudget gives a client, or every client when clientIP is empty, its
+// full budget back for today
+func (e *Engine) ResetBudget(name, clientIP string) error {
+	e.mu.Lock()
+	_, ok := e.budgetRules[name]
+	if ok {
+		if clientIP == "" {
+			delete(e.budgetUsage, name)
+		} else {
+			delete(e.budgetUsage[name], clientIP)
+		}
+	}
+	e.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("budget %q not found", name)
+	}
+	if err := e.db.ResetBudgetUsage(name, clientIP); err != nil {
+		return err
+	}
+	e.log.Infof("Time budget %s reset for %s", name, clientOrEveryone(clientIP))
+	return nil
+}
+
+func clientOrEveryone(clientIP string) string {
+	if clientIP == "" {
+		return "everyone"
+	}
+	return clientIP
+}
+
+// budgetFor returns the budget of clientIP that covers domain, preferring
+// one that is used up, or nil
+func (e *Engine) budgetFor(domain, clientIP string) *Budget {
+	if !e.cfg.Filtering.Budgets.Enabled || clientIP == "" {
+		return nil
+	}
+
+	var match *Budget
+	for _, b := range e.GetBudgets(clientIP) {
+		e.mu.RLock()
+		rule := e.budgetRules[b.Name]
+		e.mu.RUnlock()
+		if e.budgetCovers(rule, domain) {
+			b := b
+			match = &b
+			if b.Exhausted {
+				break
+			}
+		}
+	}
+	return match
+}
+
+// blockPageBudget finds the budget shown on the block page for a client
+// that was sent there for domain
+func (e *Engine) blockPageBudget(clientIP, domain string) *blockpage.BudgetStatus {
+	b := e.budgetFor(normalizeDomain(domain), clientIP)
+	if b == nil {
+		return nil
+	}
+	return &blockpage.BudgetStatus{
+		Name:      b.Name,
+		Used:      b.Used,
+		Limit:     b.Limit,
+		Remaining: b.Remaining,
+		ResetsAt:  b.ResetsAt,
+	}
+}
@@ -0,0 +1,164 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+)
+
+// budgetsConfig turns on budgets in UTC with rules
+func budgetsConfig(rules ...config.BudgetRule) *config.Config {
+	cfg := &config.Config{}
+	cfg.Filtering.Budgets = config.BudgetsConfig{Enabled: true, Timezone: "UTC", Rules: rules}
+	return cfg
+}
+
+func TestCompileBudgetRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    config.BudgetRule
+		wantErr bool
+	}{
+		{"domains", config.BudgetRule{Domains: []string{"Roblox.com."}, Minutes: 60}, false},
+		{"categories", config.BudgetRule{Categories: []string{"Gaming"}, Minutes: 60, Days: []string{"saturday"}}, false},
+		{"no minutes", config.BudgetRule{Domains: []string{"roblox.com"}}, true},
+		{"no sites", config.BudgetRule{Minutes: 60}, true},
+		{"bad day", config.BudgetRule{Domains: []string{"roblox.com"}, Minutes: 60, Days: []string{"someday"}}, true},
+		{"bad client", config.BudgetRule{Domains: []string{"roblox.com"}, Minutes: 60, Clients: []string{"kid"}}, true},
+		{"unknown group", config.BudgetRule{Domains: []string{"roblox.com"}, Minutes: 60, Groups: []string{"kids"}}, true},
+	}
+	e := newTestEngine(t, budgetsConfig())
+	for _, tt := range tests {
+		if _, err := e.compileBudgetRule(tt.rule); (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBudgetPeriod(t *testing.T) {
+	cfg := budgetsConfig()
+	cfg.Filtering.Budgets.ResetAt = "04:00"
+	e := newTestEngine(t, cfg)
+
+	tests := []struct {
+		t    time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), time.Date(2026, 10, 16, 4, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 16, 4, 0, 0, 0, time.UTC), time.Date(2026, 10, 16, 4, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 16, 3, 59, 0, 0, time.UTC), time.Date(2026, 10, 15, 4, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 1, 1, 0, 0, 0, time.UTC), time.Date(2026, 9, 30, 4, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := e.budgetPeriod(tt.t); !got.Equal(tt.want) {
+			t.Errorf("budgetPeriod(%s) = %s, want %s", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestBudgetsFor(t *testing.T) {
+	e := newTestEngine(t, budgetsConfig(
+		config.BudgetRule{Name: "weekend", Domains: []string{"roblox.com"}, Minutes: 60, Days: []string{"saturday", "sunday"}},
+		config.BudgetRule{Name: "kid", Domains: []string{"youtube.com"}, Minutes: 30, Clients: []string{"10.0.0.5"}},
+	))
+
+	friday := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	saturday := friday.AddDate(0, 0, 1)
+	tests := []struct {
+		client string
+		period time.Time
+		want   []string
+	}{
+		{"10.0.0.5", friday, []string{"kid"}},
+		{"10.0.0.5", saturday, []string{"weekend", "kid"}},
+		{"10.0.0.6", friday, nil},
+		{"10.0.0.6", saturday, []string{"weekend"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, rule := range e.budgetsFor(tt.client, tt.period) {
+			got = append(got, rule.Name)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("budgetsFor(%s, %s) = %v, want %v", tt.client, tt.period.Weekday(), got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("budgetsFor(%s, %s) = %v, want %v", tt.client, tt.period.Weekday(), got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCheckBudget(t *testing.T) {
+	e := newTestEngine(t, budgetsConfig(config.BudgetRule{Name: "games", Domains: []string{"roblox.com"}, Minutes: 4}))
+	const kid = "10.0.0.5"
+
+	usage := func() *budgetUsage {
+		return e.budgetUsage["games"][kid]
+	}
+
+	steps := []struct {
+		name     string
+		domain   string
+		lastAgo  time.Duration // moves the last query of the kid back, 0 to leave it
+		want     string
+		wantUsed time.Duration
+	}{
+		{"other site", "example.com", 0, "", 0},
+		{"first query of a burst", "www.roblox.com", 0, "", time.Minute},
+		{"within the burst gap", "roblox.com", 90 * time.Second, "", time.Minute + 90*time.Second},
+		{"after the burst gap", "roblox.com", 10 * time.Minute, "", 2*time.Minute + 90*time.Second},
+		{"used up", "roblox.com", time.Minute, "", 3*time.Minute + 90*time.Second},
+		{"blocked", "cdn.roblox.com", 0, "budget:games", 3*time.Minute + 90*time.Second},
+	}
+	for _, step := range steps {
+		if u := usage(); u != nil && step.lastAgo > 0 {
+			u.last = time.Now().Add(-step.lastAgo)
+		}
+		if got := e.checkBudget(step.domain, kid); got != step.want {
+			t.Errorf("%s: checkBudget = %q, want %q", step.name, got, step.want)
+		}
+		used := time.Duration(0)
+		if u := usage(); u != nil {
+			used = u.used
+		}
+		if diff := used - step.wantUsed; diff < 0 || diff > time.Second {
+			t.Errorf("%s: used %s, want %s", step.name, used, step.wantUsed)
+		}
+	}
+
+	if got := e.checkBudget("roblox.com", "10.0.0.6"); got != "" {
+		t.Errorf("another client is blocked by the kid's budget: %q", got)
+	}
+
+	e.budgetUsage["games"][kid].period = e.budgetPeriod(time.Now()).AddDate(0, 0, -1)
+	if got := e.checkBudget("roblox.com", kid); got != "" {
+		t.Errorf("the budget did not start over in a new period: %q", got)
+	}
+
+	e.cfg.Filtering.Budgets.Enabled = false
+	if got := e.checkBudget("roblox.com", kid); got != "" || e.budgetApplies(kid) {
+		t.Errorf("budgets apply while disabled")
+	}
+}
+
+func TestBudgetStageDryRun(t *testing.T) {
+	e := newTestEngine(t, budgetsConfig(config.BudgetRule{Name: "games", Domains: []string{"roblox.com"}, Minutes: 1}))
+	const kid = "10.0.0.5"
+
+	if got := e.budgetStage(&Query{Domain: "roblox.com", ClientIP: kid, DryRun: true}); got.Verdict != VerdictContinue {
+		t.Errorf("dry run of an unused budget: %+v", got)
+	}
+	if e.budgetUsage["games"][kid] != nil || e.budgetDirty {
+		t.Errorf("a dry run charged the budget")
+	}
+
+	e.budgetStage(&Query{Domain: "roblox.com", ClientIP: kid})
+	if got := e.budgetStage(&Query{Domain: "roblox.com", ClientIP: kid, DryRun: true}); got.Reason != "budget:games" {
+		t.Errorf("dry run of a used up budget: %+v", got)
+	}
+}
@@ -0,0 +1,622 @@
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/database"
+)
+
+// ─── Custom Entries ───────────────────────────────────────────────────────────
+//
+// Custom blocks and whitelist entries live in one store, whether they were
+// added through the API, listed in config.yaml or read from a custom YAML
+// file. Entries from the config and from files are replaced every time those
+// are read; API entries stay until they are removed or synced to a file.
+
+const (
+	CustomListBlock = "block"
+	CustomListAllow = "allow"
+
+	EntryOriginAPI    = "api"
+	EntryOriginConfig = "config"
+	EntryOriginFile   = "file"
+
+	defaultCustomFile = "custom-blocklist.yaml"
+)
+
+// CustomEntry is a custom block or whitelist entry and where it came from
+type CustomEntry struct {
+	Domain   string      `json:"domain"`
+	List     string      `json:"list"`
+	Origin   string      `json:"origin"`
+	File     string      `json:"file,omitempty"`
+	Category string      `json:"category,omitempty"`
+	Note     string      `json:"note,omitempty"`
+	Enabled  bool        `json:"enabled"`
+	Scope    *EntryScope `json:"scope,omitempty"`
+	AddedAt  time.Time   `json:"added_at"`
+}
+
+// CustomReload is the outcome of reading the custom YAML files
+type CustomReload struct {
+	Count   int                `json:"count"`   // enabled domains in all files
+	Files   []CustomFileStatus `json:"files"`   // one entry per file matching custom_path
+	Changed []string           `json:"changed"` // names blocked or allowed differently than before
+}
+
+// CustomFileStatus reports how one custom YAML file was read
+type CustomFileStatus struct {
+	File    string `json:"file"`
+	Domains int    `json:"domains"`
+	Error   string `json:"error,omitempty"`
+}
+
+// syncCustomEntries reads the whitelist from the config and the custom YAML
+// files into the store and loads the result
+func (e *Engine) syncCustomEntries() error {
+	var entries []database.CustomEntry
+	for _, domain := range e.cfg.Whitelist.Domains {
+		if IsPattern(domain) {
+			continue // compiled with the other pattern rules
+		}
+		if domain = normalizeDomain(domain); domain != "" {
+			entries = append(entries, database.CustomEntry{Domain: domain, List: CustomListAllow, Enabled: true})
+		}
+	}
+	if err := e.db.ReplaceCustomEntries(EntryOriginConfig, "", entries); err != nil {
+		return err
+	}
+
+	_, err := e.syncCustomFiles()
+	return err
+}
+
+// syncCustomFiles reads every file matching custom_path into the store and
+// reports the result per file. A file that cannot be read keeps its previous
+// entries. Entries of files that were deleted or no longer match are dropped,
+// and the registered handlers are told which names changed.
+func (e *Engine) syncCustomFiles() (*CustomReload, error) {
+	e.customMu.Lock()
+	defer e.customMu.Unlock()
+
+	reload := &CustomReload{}
+	files, err := filepath.Glob(e.cfg.Blocklists.CustomPath)
+	if err != nil {
+		return reload, err
+	}
+
+	e.mu.RLock()
+	previous := e.customPatterns
+	e.mu.RUnlock()
+
+	var patterns []*PatternRule
+	seen := make(map[string]bool)
+
+	for _, file := range files {
+		seen[file] = true
+		status := CustomFileStatus{File: file}
+
+		entries, rules, err := e.readCustomFile(file)
+		if err != nil {
+			e.log.Warnf("Failed to load custom blocklist %s: %v (keeping previous entries)", file, err)
+			status.Error = err.Error()
+			reload.Files = append(reload.Files, status)
+			for _, rule := range previous {
+				if rule.Source == file {
+					patterns = append(patterns, rule)
+				}
+			}
+			continue
+		}
+
+		if err := e.db.ReplaceCustomEntries(EntryOriginFile, file, entries); err != nil {
+			return reload, err
+		}
+		patterns = append(patterns, rules...)
+
+		for _, entry := range entries {
+			if entry.Enabled {
+				status.Domains++
+			}
+		}
+		reload.Count += status.Domains
+		reload.Files = append(reload.Files, status)
+		e.log.Infof("Loaded custom blocklist: %s (%d enabled domains)", file, status.Domains)
+	}
+
+	// Drop the entries of files that no longer match custom_path
+	stored, err := e.db.LoadCustomEntries()
+	if err != nil {
+		return reload, err
+	}
+	for _, entry := range stored {
+		if entry.Origin == EntryOriginFile && !seen[entry.File] {
+			if err := e.db.ReplaceCustomEntries(EntryOriginFile, entry.File, nil); err != nil {
+				return reload, err
+			}
+			seen[entry.File] = true
+		}
+	}
+
+	patternsChanged := !samePatterns(previous, patterns)
+	e.setCustomPatterns(patterns)
+	if reload.Changed, err = e.reloadCustomEntries(); err != nil {
+		return reload, err
+	}
+
+	// A changed pattern may match any name, so the handlers get nil
+	switch {
+	case patternsChanged:
+		e.notifyCustomChange(nil)
+	case len(reload.Changed) > 0:
+		e.notifyCustomChange(reload.Changed)
+	}
+	return reload, nil
+}
+
+// samePatterns reports whether two sets of custom file rules are equal
+func samePatterns(a, b []*PatternRule) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	keys := make(map[string]int)
+	for _, rule := range a {
+		keys[rule.Source+"|"+rule.key()]++
+	}
+	for _, rule := range b {
+		k := rule.Source + "|" + rule.key()
+		if keys[k] == 0 {
+			return false
+		}
+		keys[k]--
+	}
+	return true
+}
+
+// OnCustomChange registers fn to be called with the names whose custom entry
+// changed after the custom files were read, or whose temporary override was
+// added, revoked or expired. A nil slice means any name may be affected.
+func (e *Engine) OnCustomChange(fn func(domains []string)) {
+	e.mu.Lock()
+	e.customHandlers = append(e.customHandlers, fn)
+	e.mu.Unlock()
+}
+
+func (e *Engine) notifyCustomChange(domains []string) {
+	e.mu.RLock()
+	handlers := e.customHandlers
+	e.mu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(domains)
+	}
+}
+
+// readCustomFile parses one custom YAML file into domain entries and pattern
+// rules. Disabled domains are kept so they show up in the store.
+func (e *Engine) readCustomFile(file string) ([]database.CustomEntry, []*PatternRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var bl CustomBlocklist
+	if err := yaml.Unmarshal(data, &bl); err != nil {
+		return nil, nil, err
+	}
+
+	var entries []database.CustomEntry
+	var patterns []*PatternRule
+	for _, entry := range bl.Domains {
+		list, scope, err := scopeFromEntry(entry)
+		if err != nil {
+			e.log.Warnf("Skipping %q in %s: %v", entry.Domain, file, err)
+			continue
+		}
+		for _, group := range entry.Groups {
+			if _, ok := e.clientGroups[group]; !ok {
+				e.log.Warnf("%q in %s refers to unknown client group %q", entry.Domain, file, group)
+			}
+		}
+
+		if IsPattern(entry.Domain) {
+			if !entry.Enabled {
+				continue
+			}
+			// Patterns match the whole name for every client
+			if list == CustomListRewrite || (scope != nil && (scope.Match != MatchSubtree ||
+				scope.Expires != nil || scope.Schedule != nil || len(scope.Clients) > 0 || len(scope.Groups) > 0)) {
+				e.log.Warnf("Skipping pattern %q in %s: patterns only support action block or allow", entry.Domain, file)
+				continue
+			}
+			rule, err := ParsePatternRule(entry.Domain, list)
+			if err != nil {
+				e.log.Warnf("Invalid pattern %q in %s: %v", entry.Domain, file, err)
+				continue
+			}
+			rule.Source = file
+			rule.Note = entry.Note
+			patterns = append(patterns, rule)
+			continue
+		}
+
+		domain := normalizeDomain(entry.Domain)
+		if domain == "" {
+			continue
+		}
+		entries = append(entries, database.CustomEntry{
+			Domain:   domain,
+			List:     list,
+			Category: entry.Category,
+			Note:     entry.Note,
+			Enabled:  entry.Enabled,
+			Options:  encodeScope(scope),
+		})
+	}
+	return entries, patterns, nil
+}
+
+// reloadCustomEntries rebuilds the custom block and whitelist sets from the
+// store and returns the names that were added to or removed from either set
+func (e *Engine) reloadCustomEntries() ([]string, error) {
+	entries, err := e.db.LoadCustomEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := make(map[string]bool)
+	allowed := make(map[string]bool)
+	scoped := make(map[string][]*customRule)
+	scopedKeys := make(map[string]bool) // domain|list|options, to find changes
+	for _, entry := range entries {
+		if !entry.Enabled {
+			continue
+		}
+		if entry.Options != "" {
+			scope, err := decodeScope(entry.Options)
+			if err == nil {
+				var rule *customRule
+				if rule, err = compileCustomRule(entry.Domain, entry.List, *scope); err == nil {
+					scoped[entry.Domain] = append(scoped[entry.Domain], rule)
+					scopedKeys[entry.Domain+"|"+entry.List+"|"+entry.Options] = true
+				}
+			}
+			if err != nil {
+				e.log.Warnf("Skipping custom entry %s: %v", entry.Domain, err)
+			}
+			continue
+		}
+		if entry.List == CustomListAllow {
+			allowed[entry.Domain] = true
+		} else {
+			blocked[entry.Domain] = true
+		}
+	}
+
+	e.mu.Lock()
+	changed := diffSets(e.customBlocked, blocked)
+	changed = append(changed, diffSets(e.whitelist, allowed)...)
+	for _, key := range diffSets(e.scopedKeys, scopedKeys) {
+		changed = append(changed, key[:strings.Index(key, "|")])
+	}
+	e.customBlocked = blocked
+	e.whitelist = allowed
+	e.scopedRules = scoped
+	e.scopedKeys = scopedKeys
+	e.mu.Unlock()
+
+	sort.Strings(changed)
+	return dedupSorted(changed), nil
+}
+
+// diffSets returns the keys that are in only one of old and new
+func diffSets(old, new map[string]bool) []string {
+	var diff []string
+	for name := range old {
+		if !new[name] {
+			diff = append(diff, name)
+		}
+	}
+	for name := range new {
+		if !old[name] {
+			diff = append(diff, name)
+		}
+	}
+	return diff
+}
+
+func dedupSorted(names []string) []string {
+	out := names[:0]
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// GetCustomEntries lists the stored entries of one list, or of both when
+// list is empty
+func (e *Engine) GetCustomEntries(list string) ([]CustomEntry, error) {
+	records, err := e.db.LoadCustomEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]CustomEntry, 0, len(records))
+	for _, rec := range records {
+		if list != "" && rec.List != list {
+			continue
+		}
+		scope, _ := decodeScope(rec.Options)
+		entries = append(entries, CustomEntry{
+			Domain:   rec.Domain,
+			List:     rec.List,
+			Origin:   rec.Origin,
+			File:     rec.File,
+			Category: rec.Category,
+			Note:     rec.Note,
+			Enabled:  rec.Enabled,
+			Scope:    scope,
+			AddedAt:  rec.AddedAt,
+		})
+	}
+	return entries, nil
+}
+
+// addCustomEntry stores an API entry for domain
+func (e *Engine) addCustomEntry(domain, list string) error {
+	if domain == "" {
+		return fmt.Errorf("domain is required")
+	}
+	err := e.db.AddCustomEntry(database.CustomEntry{
+		Domain:  domain,
+		List:    list,
+		Origin:  EntryOriginAPI,
+		Enabled: true,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = e.reloadCustomEntries()
+	return err
+}
+
+// removeCustomEntry removes domain from a list. API entries are deleted and
+// file entries are disabled in their file; entries from config.yaml cannot be
+// removed here.
+func (e *Engine) removeCustomEntry(domain, list string) error {
+	entries, err := e.db.LoadCustomEntries()
+	if err != nil {
+		return err
+	}
+
+	inConfig, fileChanged := false, false
+	for _, entry := range entries {
+		if entry.Domain != domain || entry.List != list {
+			continue
+		}
+		switch entry.Origin {
+		case EntryOriginAPI:
+			if err := e.db.DeleteCustomEntry(entry.Domain, entry.List, entry.Origin, entry.File); err != nil {
+				return err
+			}
+		case EntryOriginFile:
+			if !entry.Enabled {
+				continue
+			}
+			if err := disableInCustomFile(entry.File, domain, entry.List, entry.Options); err != nil {
+				return fmt.Errorf("failed to update %s: %v", entry.File, err)
+			}
+			fileChanged = true
+		case EntryOriginConfig:
+			inConfig = true
+		}
+	}
+
+	if fileChanged {
+		if _, err := e.syncCustomFiles(); err != nil {
+			return err
+		}
+	} else if _, err := e.reloadCustomEntries(); err != nil {
+		return err
+	}
+
+	if inConfig {
+		return fmt.Errorf("%s is set in config.yaml", domain)
+	}
+	return nil
+}
+
+// SyncCustomBlocklistFile writes the custom blocks added through the API to
+// custom-blocklist.yaml, where they become file entries. It returns the
+// number of entries written.
+func (e *Engine) SyncCustomBlocklistFile() (int, error) {
+	path := filepath.Join(filepath.Dir(e.cfg.Blocklists.CustomPath), defaultCustomFile)
+	if ok, _ := filepath.Match(e.cfg.Blocklists.CustomPath, path); !ok {
+		return 0, fmt.Errorf("%s does not match custom_path %q", path, e.cfg.Blocklists.CustomPath)
+	}
+
+	entries, err := e.db.LoadCustomEntries()
+	if err != nil {
+		return 0, err
+	}
+	var pending []database.CustomEntry
+	for _, entry := range entries {
+		if entry.Origin == EntryOriginAPI && entry.List == CustomListBlock {
+			pending = append(pending, entry)
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	err = editCustomFile(path, func(domains *yaml.Node) bool {
+		for _, entry := range pending {
+			if items := findCustomItems(domains, entry.Domain, entry.List, entry.Options); len(items) > 0 {
+				for _, item := range items {
+					setMappingScalar(item, "enabled", "true", "!!bool")
+				}
+				continue
+			}
+			category := entry.Category
+			if category == "" {
+				category = "custom"
+			}
+			domains.Content = append(domains.Content, customItemNode(CustomBlocklistEntry{
+				Domain:   entry.Domain,
+				Category: category,
+				Note:     entry.Note,
+				Enabled:  true,
+			}))
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range pending {
+		if err := e.db.DeleteCustomEntry(entry.Domain, entry.List, entry.Origin, entry.File); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := e.syncCustomFiles(); err != nil {
+		return len(pending), err
+	}
+
+	e.log.Infof("Wrote %d custom blocklist entries to %s", len(pending), path)
+	return len(pending), nil
+}
+
+// ─── Custom YAML Editing ──────────────────────────────────────────────────────
+//
+// Files are edited as YAML nodes so comments and the order of entries survive.
+
+// disableInCustomFile sets enabled: false on the entries for domain with the
+// given list and options
+func disableInCustomFile(path, domain, list, options string) error {
+	return editCustomFile(path, func(domains *yaml.Node) bool {
+		items := findCustomItems(domains, domain, list, options)
+		for _, item := range items {
+			setMappingScalar(item, "enabled", "false", "!!bool")
+		}
+		return len(items) > 0
+	})
+}
+
+// editCustomFile loads a custom YAML file, or starts a new one, lets edit
+// change its domains sequence and writes the file back if edit reports a change
+func editCustomFile(path string, edit func(domains *yaml.Node) bool) error {
+	var doc yaml.Node
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+		setMappingScalar(doc.Content[0], "version", "2.0", "")
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("not a custom blocklist")
+	}
+	domains := mappingValue(root, "domains")
+	switch {
+	case domains == nil:
+		domains = &yaml.Node{Kind: yaml.SequenceNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "domains"}, domains)
+	case domains.Kind == yaml.ScalarNode && domains.Tag == "!!null":
+		domains.Kind, domains.Tag, domains.Value = yaml.SequenceNode, "", ""
+	case domains.Kind != yaml.SequenceNode:
+		return fmt.Errorf("domains is not a list")
+	}
+
+	if !edit(domains) {
+		return nil
+	}
+	setMappingScalar(root, "last_updated", time.Now().Format("2006-01-02"), "")
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	enc.Close()
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// findCustomItems returns the items for domain that belong to list and have
+// the stored options, as readCustomFile would read them. One file can hold
+// a block, an allow and scoped entries for the same domain.
+func findCustomItems(domains *yaml.Node, domain, list, options string) []*yaml.Node {
+	var items []*yaml.Node
+	for _, item := range domains.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		var entry CustomBlocklistEntry
+		if err := item.Decode(&entry); err != nil || normalizeDomain(entry.Domain) != domain {
+			continue
+		}
+		entryList, scope, err := scopeFromEntry(entry)
+		if err == nil && entryList == list && encodeScope(scope) == options {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func customItemNode(entry CustomBlocklistEntry) *yaml.Node {
+	item := &yaml.Node{Kind: yaml.MappingNode}
+	for _, field := range [][2]string{{"domain", entry.Domain}, {"category", entry.Category}, {"note", entry.Note}} {
+		item.Content = append(item.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: field[0]},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: field[1], Style: yaml.DoubleQuotedStyle})
+	}
+	setMappingScalar(item, "enabled", fmt.Sprint(entry.Enabled), "!!bool")
+	return item
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingScalar sets key to a scalar value, adding the key if needed.
+// Strings keep the quoting style of the value they replace.
+func setMappingScalar(mapping *yaml.Node, key, value, tag string) {
+	if node := mappingValue(mapping, key); node != nil {
+		node.Kind, node.Value, node.Tag = yaml.ScalarNode, value, tag
+		return
+	}
+	style := yaml.Style(0)
+	if tag == "" {
+		style = yaml.DoubleQuotedStyle
+	}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Value: value, Tag: tag, Style: style})
+}
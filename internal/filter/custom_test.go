@@ -0,0 +1,73 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const customFileWithScopes = `version: "2.0"
+domains:
+  - domain: "discord.com"
+    enabled: true
+  - domain: "Discord.com."
+    action: allow
+    enabled: true
+  - domain: "discord.com"
+    groups: ["kids"]
+    enabled: true
+  - domain: "discord.com"
+    action: rewrite
+    rewrite: "10.0.0.1"
+    enabled: true
+  - domain: "example.com"
+    enabled: true
+`
+
+func TestDisableInCustomFile(t *testing.T) {
+	allow := encodeScope(&EntryScope{Match: MatchSubtree})
+	kids := encodeScope(&EntryScope{Match: MatchSubtree, Groups: []string{"kids"}})
+	rewrite := encodeScope(&EntryScope{Match: MatchSubtree, Rewrite: "10.0.0.1"})
+
+	tests := []struct {
+		name         string
+		domain, list string
+		options      string
+		wantDisabled []int // indexes of the items disabled afterwards
+	}{
+		{"plain block", "discord.com", CustomListBlock, "", []int{0}},
+		{"allow", "discord.com", CustomListAllow, allow, []int{1}},
+		{"scoped block", "discord.com", CustomListBlock, kids, []int{2}},
+		{"rewrite", "discord.com", CustomListRewrite, rewrite, []int{3}},
+		{"no such entry", "discord.com", CustomListAllow, kids, nil},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "custom.yaml")
+		if err := os.WriteFile(path, []byte(customFileWithScopes), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := disableInCustomFile(path, tt.domain, tt.list, tt.options); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var bl CustomBlocklist
+		if err := yaml.Unmarshal(data, &bl); err != nil {
+			t.Fatal(err)
+		}
+		want := make(map[int]bool)
+		for _, i := range tt.wantDisabled {
+			want[i] = true
+		}
+		for i, entry := range bl.Domains {
+			if entry.Enabled == want[i] {
+				t.Errorf("%s: item %d (%s, action %q) enabled = %v", tt.name, i, entry.Domain, entry.Action, entry.Enabled)
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package filter
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ─── Encrypted DNS Bypass ─────────────────────────────────────────────────────
+//
+// A browser with DNS over HTTPS, iCloud Private Relay or an app talking to a
+// public DoH/DoT resolver resolves names without asking us. Firefox and Apple
+// devices look up canary names first and stay on the network's resolver when
+// the answer is NXDOMAIN, so the canaries always get one. The host names of
+// public resolvers are blocked so other clients cannot bootstrap them, and
+// the addresses of those resolvers are exported for the firewall, which is
+// the only thing that stops clients with hard-coded IPs.
+
+const (
+	EncryptedDNSCanary   = "encrypted-dns:canary"
+	EncryptedDNSResolver = "encrypted-dns:resolver"
+)
+
+// encryptedDNSCanaries are answered with NXDOMAIN to turn encrypted DNS off
+var encryptedDNSCanaries = map[string]string{
+	"use-application-dns.net": "Firefox DNS over HTTPS",
+	"mask.icloud.com":         "iCloud Private Relay",
+	"mask-h2.icloud.com":      "iCloud Private Relay",
+}
+
+// encryptedDNSResolvers are the host names of public encrypted DNS
+// resolvers. Clients that reach them resolve names around the filter.
+var encryptedDNSResolvers = []string{
+	// Google, Cloudflare, Quad9
+	"dns.google", "dns.google.com", "dns64.dns.google",
+	"cloudflare-dns.com", "mozilla.cloudflare-dns.com", "chrome.cloudflare-dns.com",
+	"one.one.one.one", "1dot1dot1dot1.cloudflare-dns.com",
+	"security.cloudflare-dns.com", "family.cloudflare-dns.com",
+	"dns.quad9.net", "dns9.quad9.net", "dns10.quad9.net", "dns11.quad9.net",
+	// OpenDNS, AdGuard, NextDNS, CleanBrowsing, Control D
+	"doh.opendns.com", "doh.familyshield.opendns.com", "dns.umbrella.com",
+	"dns.adguard.com", "dns-family.adguard.com", "dns-unfiltered.adguard.com",
+	"dns.adguard-dns.com", "family.adguard-dns.com", "unfiltered.adguard-dns.com",
+	"dns.nextdns.io", "chromium.dns.nextdns.io", "firefox.dns.nextdns.io",
+	"doh.cleanbrowsing.org", "dns.controld.com", "freedns.controld.com",
+	// Privacy resolvers
+	"doh.mullvad.net", "dns.mullvad.net", "adblock.dns.mullvad.net",
+	"dns0.eu", "zero.dns0.eu", "kids.dns0.eu", "open.dns0.eu",
+	"doh.dns.sb", "dot.sb", "doh.libredns.gr", "doh.applied-privacy.net",
+	"dns.digitale-gesellschaft.ch", "dns.switch.ch", "odvr.nic.cz",
+	"doh.ffmuc.net", "ordns.he.net", "dns.njal.la", "doh.xfinity.com",
+	// Resolvers common in Asia
+	"dns.alidns.com", "doh.pub", "dot.pub", "doh.360.cn", "dns.twnic.tw",
+}
+
+// encryptedDNSIPs are the anycast addresses of the public resolvers in
+// encryptedDNSResolvers
+var encryptedDNSIPs = []string{
+	// Google
+	"8.8.8.8", "8.8.4.4", "2001:4860:4860::8888", "2001:4860:4860::8844",
+	// Cloudflare
+	"1.1.1.1", "1.0.0.1", "1.1.1.2", "1.0.0.2", "1.1.1.3", "1.0.0.3",
+	"2606:4700:4700::1111", "2606:4700:4700::1001",
+	"2606:4700:4700::1112", "2606:4700:4700::1002",
+	"2606:4700:4700::1113", "2606:4700:4700::1003",
+	// Quad9
+	"9.9.9.9", "149.112.112.112", "9.9.9.10", "149.112.112.10",
+	"9.9.9.11", "149.112.112.11", "2620:fe::fe", "2620:fe::9",
+	// OpenDNS
+	"208.67.222.222", "208.67.220.220", "208.67.222.123", "208.67.220.123",
+	"2620:119:35::35", "2620:119:53::53",
+	// AdGuard
+	"94.140.14.14", "94.140.15.15", "94.140.14.15", "94.140.15.16",
+	"94.140.14.140", "94.140.14.141", "2a10:50c0::ad1:ff", "2a10:50c0::ad2:ff",
+	// NextDNS, CleanBrowsing, Control D
+	"45.90.28.0", "45.90.30.0", "185.228.168.9", "185.228.169.9",
+	"185.228.168.168", "185.228.169.168", "76.76.2.0", "76.76.10.0",
+	// Mullvad, dns0.eu, DNS.SB
+	"194.242.2.2", "194.242.2.3", "193.110.81.0", "185.253.5.0",
+	"185.222.222.222", "45.11.45.11",
+	// AliDNS, DNSPod, TWNIC
+	"223.5.5.5", "223.6.6.6", "1.12.12.12", "120.53.53.53", "101.101.101.101",
+}
+
+// loadEncryptedDNS builds the set of resolver host names
+func (e *Engine) loadEncryptedDNS() error {
+	cfg := e.cfg.Filtering.EncryptedDNS
+	for _, ip := range cfg.IPs {
+		if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+			return fmt.Errorf("encrypted_dns: invalid address %q", ip)
+		}
+	}
+
+	resolvers := make(map[string]bool)
+	for _, list := range [][]string{encryptedDNSResolvers, cfg.Resolvers} {
+		for _, domain := range list {
+			if domain = normalizeDomain(domain); domain != "" {
+				resolvers[domain] = true
+			}
+		}
+	}
+
+	e.mu.Lock()
+	e.encryptedDNSResolvers = resolvers
+	e.mu.Unlock()
+	return nil
+}
+
+// matchDNSCanary returns domain if it is an encrypted DNS canary, or ""
+func (e *Engine) matchDNSCanary(domain string) string {
+	if !e.cfg.Filtering.EncryptedDNS.Enabled {
+		return ""
+	}
+	if _, ok := encryptedDNSCanaries[domain]; ok {
+		return domain
+	}
+	return ""
+}
+
+// matchEncryptedDNS returns the resolver host name covering domain, or ""
+func (e *Engine) matchEncryptedDNS(domain string) string {
+	if !e.cfg.Filtering.EncryptedDNS.Enabled {
+		return ""
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for name := domain; ; {
+		if e.encryptedDNSResolvers[name] {
+			return name
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return ""
+		}
+		name = name[i+1:]
+	}
+}
+
+// dnsCanaryStage answers canaries with NXDOMAIN, whatever the block action.
+// It runs before the whitelist so whitelisting icloud.com does not turn
+// Private Relay back on.
+func (e *Engine) dnsCanaryStage(q *Query) Result {
+	if e.matchDNSCanary(q.Domain) == "" {
+		return Continue
+	}
+	return Result{
+		Verdict: VerdictRewrite,
+		Reason:  EncryptedDNSCanary,
+		Rewrite: canaryPolicy(q.Domain),
+	}
+}
+
+// CheckDNSCanary returns the NXDOMAIN answer for a canary domain, or nil.
+// The DNS server checks it before rewrites and policy zones, so those cannot
+// turn encrypted DNS back on either.
+func (e *Engine) CheckDNSCanary(domain string) *RPZPolicy {
+	domain = normalizeDomain(domain)
+	if !e.stageNameEnabled("dns-canary") || e.matchDNSCanary(domain) == "" {
+		return nil
+	}
+	return canaryPolicy(domain)
+}
+
+func canaryPolicy(domain string) *RPZPolicy {
+	return &RPZPolicy{Trigger: domain, Action: RPZActionNXDOMAIN, reason: EncryptedDNSCanary}
+}
+
+func (e *Engine) explainDNSCanary(x *explainer, q *Query) {
+	rule := e.matchDNSCanary(q.Domain)
+	if rule == "" {
+		x.miss("dns-canary", "")
+		return
+	}
+	x.hit(TraceStep{
+		Stage:  "dns-canary",
+		Effect: EffectRewrite,
+		Rule:   rule,
+		Detail: "NXDOMAIN turns off " + encryptedDNSCanaries[rule],
+	}, EncryptedDNSCanary)
+}
+
+// encryptedDNSStage blocks public resolvers
+func (e *Engine) encryptedDNSStage(q *Query) Result {
+	if e.matchEncryptedDNS(q.Domain) != "" {
+		return Result{Verdict: VerdictBlock, Reason: EncryptedDNSResolver}
+	}
+	return Continue
+}
+
+func (e *Engine) explainEncryptedDNS(x *explainer, q *Query) {
+	if rule := e.matchEncryptedDNS(q.Domain); rule != "" {
+		x.hit(TraceStep{Stage: "encrypted-dns", Effect: EffectBlock, Rule: rule, Detail: "public DoH/DoT resolver"}, EncryptedDNSResolver)
+	} else {
+		x.miss("encrypted-dns", "")
+	}
+}
+
+// GetEncryptedDNSIPs returns the addresses of public encrypted DNS resolvers,
+// IPv4 first, for a firewall to drop
+func (e *Engine) GetEncryptedDNSIPs() []string {
+	seen := make(map[string]bool)
+	var ips []string
+	for _, list := range [][]string{encryptedDNSIPs, e.cfg.Filtering.EncryptedDNS.IPs} {
+		for _, ip := range list {
+			if ip = strings.TrimSpace(ip); ip != "" && !seen[ip] {
+				seen[ip] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	sort.SliceStable(ips, func(i, j int) bool {
+		return !strings.Contains(ips[i], ":") && strings.Contains(ips[j], ":")
+	})
+	return ips
+}
@@ -0,0 +1,110 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+)
+
+func encryptedDNSConfig(enc config.EncryptedDNSConfig) *config.Config {
+	cfg := &config.Config{}
+	cfg.Filtering.EncryptedDNS = enc
+	return cfg
+}
+
+func TestEncryptedDNSStages(t *testing.T) {
+	e := newTestEngine(t, encryptedDNSConfig(config.EncryptedDNSConfig{
+		Enabled:   true,
+		Resolvers: []string{"DoH.Example.NET."},
+	}))
+
+	tests := []struct {
+		domain   string
+		canary   Verdict
+		resolver Verdict
+	}{
+		{"use-application-dns.net", VerdictRewrite, VerdictContinue},
+		{"mask.icloud.com", VerdictRewrite, VerdictContinue},
+		{"www.icloud.com", VerdictContinue, VerdictContinue},
+		{"dns.google", VerdictContinue, VerdictBlock},
+		{"eu.dns.nextdns.io", VerdictContinue, VerdictBlock},
+		{"doh.example.net", VerdictContinue, VerdictBlock},
+		{"google.com", VerdictContinue, VerdictContinue},
+	}
+	for _, tt := range tests {
+		q := &Query{Domain: tt.domain}
+		if got := e.dnsCanaryStage(q); got.Verdict != tt.canary {
+			t.Errorf("dnsCanaryStage(%s) = %v, want %v", tt.domain, got.Verdict, tt.canary)
+		} else if got.Verdict == VerdictRewrite && got.Rewrite.Action != RPZActionNXDOMAIN {
+			t.Errorf("dnsCanaryStage(%s) answers %s, want NXDOMAIN", tt.domain, got.Rewrite.Action)
+		}
+		if got := e.encryptedDNSStage(q); got.Verdict != tt.resolver {
+			t.Errorf("encryptedDNSStage(%s) = %v, want %v", tt.domain, got.Verdict, tt.resolver)
+		}
+		if got := e.CheckDNSCanary(tt.domain + "."); (got != nil) != (tt.canary == VerdictRewrite) {
+			t.Errorf("CheckDNSCanary(%s) = %+v", tt.domain, got)
+		}
+	}
+
+	off := false
+	e.cfg.Filtering.Stages = map[string]config.StageConfig{"dns-canary": {Enabled: &off}}
+	if e.CheckDNSCanary("use-application-dns.net") != nil {
+		t.Errorf("CheckDNSCanary answers while the dns-canary stage is off")
+	}
+	e.cfg.Filtering.Stages = nil
+
+	e.cfg.Filtering.EncryptedDNS.Enabled = false
+	for _, domain := range []string{"use-application-dns.net", "dns.google"} {
+		q := &Query{Domain: domain}
+		if e.dnsCanaryStage(q).Verdict != VerdictContinue || e.encryptedDNSStage(q).Verdict != VerdictContinue {
+			t.Errorf("%s is acted on while encrypted_dns is disabled", domain)
+		}
+	}
+
+	if PriorityDNSCanary >= PriorityWalledGarden || PriorityDNSCanary >= PriorityWhitelist {
+		t.Errorf("canaries run after the walled garden or the whitelist")
+	}
+}
+
+func TestGetEncryptedDNSIPs(t *testing.T) {
+	e := newTestEngine(t, encryptedDNSConfig(config.EncryptedDNSConfig{
+		IPs: []string{"2001:db8::53", "8.8.8.8", "203.0.113.0/24", "8.8.8.8"},
+	}))
+
+	ips := e.GetEncryptedDNSIPs()
+	seen := make(map[string]bool)
+	v6 := false
+	for _, ip := range ips {
+		if seen[ip] {
+			t.Errorf("%s is listed twice", ip)
+		}
+		seen[ip] = true
+		if strings.Contains(ip, ":") {
+			v6 = true
+		} else if v6 {
+			t.Errorf("IPv4 address %s comes after an IPv6 address", ip)
+		}
+	}
+	for _, ip := range []string{"2001:db8::53", "203.0.113.0/24", "1.1.1.1"} {
+		if !seen[ip] {
+			t.Errorf("%s is missing", ip)
+		}
+	}
+
+	tests := []struct {
+		ips     []string
+		wantErr bool
+	}{
+		{[]string{"192.0.2.1", "2001:db8::/32"}, false},
+		{[]string{"192.0.2.300"}, true},
+		{[]string{"doh.example.net"}, true},
+	}
+	for _, tt := range tests {
+		e := &Engine{cfg: &config.Config{}}
+		e.cfg.Filtering.EncryptedDNS.IPs = tt.ips
+		if err := e.loadEncryptedDNS(); (err != nil) != tt.wantErr {
+			t.Errorf("loadEncryptedDNS(%v) = %v, want error %v", tt.ips, err, tt.wantErr)
+		}
+	}
+}
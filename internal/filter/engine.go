@@ -1,20 +1,21 @@
 package filter
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
-
-	"gopkg.in/yaml.v3"
+	"unicode/utf8"
 
 	"github.com/RDXFGXY1/dns-filter-app/internal/config"
 	"github.com/RDXFGXY1/dns-filter-app/internal/database"
 	"github.com/RDXFGXY1/dns-filter-app/pkg/logger"
+	"golang.org/x/net/idna"
 
 	// ✨ NEW IMPORTS - Add these 5 features
 	"github.com/RDXFGXY1/dns-filter-app/internal/aiblock"
@@ -24,12 +25,22 @@ import (
 	"github.com/RDXFGXY1/dns-filter-app/internal/keywords"
 )
 
-// CustomBlocklistEntry represents a single entry in a custom YAML blocklist
+// CustomBlocklistEntry represents a single entry in a custom YAML blocklist.
+// Only domain is required; without the optional fields the entry blocks the
+// domain and its subdomains for every client at all times.
 type CustomBlocklistEntry struct {
 	Domain   string `yaml:"domain"`
 	Category string `yaml:"category"`
 	Note     string `yaml:"note"`
 	Enabled  bool   `yaml:"enabled"`
+
+	Action   string         `yaml:"action,omitempty"`   // block (default), allow or rewrite
+	Rewrite  string         `yaml:"rewrite,omitempty"`  // IP address or host name to answer with
+	Match    string         `yaml:"match,omitempty"`    // subtree (default), exact or wildcard
+	Expires  string         `yaml:"expires,omitempty"`  // YYYY-MM-DD or RFC 3339, ignored afterwards
+	Schedule *EntrySchedule `yaml:"schedule,omitempty"` // only applies inside this window
+	Clients  []string       `yaml:"clients,omitempty"`  // only applies to these IPs or CIDRs
+	Groups   []string       `yaml:"groups,omitempty"`   // ... or to these client groups
 }
 
 // CustomBlocklist is the top-level structure of custom*.yaml files
@@ -43,11 +54,80 @@ type Engine struct {
 	cfg            *config.Config
 	db             *database.DB
 	log            *logger.Logger
-	blockedDomains map[string]bool
+	blockedDomains map[string][]string // domain -> sources that list it
 	customBlocked  map[string]bool
 	whitelist      map[string]bool
+	scopedRules    map[string][]*customRule // custom entries limited by client, time or match
+	scopedKeys     map[string]bool
+	clientGroups   map[string][]*net.IPNet
+	overrides      map[string][]*Override // temporary allows and blocks by domain
+	pauses         []*Pause
+	scheduleRules  []*scheduleRule
+
+	// Time budgets by name in config order, the usage of each by client,
+	// and when and how usage is counted
+	budgetRules map[string]*budgetRule
+	budgetOrder []string
+	budgetUsage map[string]map[string]*budgetUsage
+	budgetReset int // minutes after midnight
+	budgetZone  *time.Location
+	budgetGap   time.Duration
+	budgetDirty bool
+
+	// What the shadow stages would have blocked since the last flush
+	shadowCounts map[string]*shadowCount
+
+	// The filter pipeline, in the order it runs
+	stages []Stage
+
+	// Side effects of queries waiting to be written
+	events *eventBus
+
+	// Protected brands by the skeleton of their name
+	homographBrands map[string]string
+
+	// Host names of public encrypted DNS resolvers
+	encryptedDNSResolvers map[string]bool
+
+	// Query stream windows and blocks of the tunneling detector
+	tunnel *tunnelState
+
+	// When each registrable domain was first seen
+	newDomains *newDomainState
+
+	// Walled garden: its clients, allowed domains by origin, learned
+	// candidates and the last allowed site each client requested
+	gardenNetworks []*net.IPNet
+	gardenAllowed  map[string]string
+	gardenLearned  map[string]*database.GardenDomain
+	gardenVisits   map[string]gardenVisit
+	gardenDirty    bool
 	mu             sync.RWMutex
 	httpClient     *http.Client
+	dial           dialFunc // outbound connections that are not HTTP (AXFR)
+
+	// Regex and glob rules, compiled into one matcher per action
+	blockPatterns  *patternMatcher
+	allowPatterns  *patternMatcher
+	customPatterns []*PatternRule
+	customHandlers []func(domains []string)
+	customMu       sync.Mutex // serialises reads of the custom YAML files
+	patternRules   []*PatternRule
+	patternHits    map[string]*uint64
+
+	// Outcome of the last fetch of each blocklist source, and the domains it
+	// produced so unchanged sources are merged without a new download
+	sourceStatus  map[string]*SourceStatus
+	sourceDomains map[string][]string
+	sourceHits    map[string]*uint64
+
+	// Sources from the config followed by those added through the API
+	sources    []config.BlocklistSource
+	apiSources map[string]bool
+	updateMu   sync.Mutex
+
+	// Response Policy Zones by source name
+	rpzZones map[string]*rpzZone
 
 	// ✨ NEW FEATURES - Add these fields
 	categoryMgr     *categories.CategoryManager
@@ -61,27 +141,18 @@ type Engine struct {
 }
 
 func New(cfg *config.Config, db *database.DB) (*Engine, error) {
-	log := logger.Get()
-
-	// Create HTTP client for downloading blocklists
-	httpClient := &http.Client{
-		Timeout: 60 * time.Second,
+	engine, err := newEngine(cfg, db)
+	if err != nil {
+		return nil, err
 	}
+	log := engine.log
 
-	engine := &Engine{
-		cfg:            cfg,
-		db:             db,
-		log:            log,
-		blockedDomains: make(map[string]bool),
-		customBlocked:  make(map[string]bool),
-		whitelist:      make(map[string]bool),
-		httpClient:     httpClient,
-		currentUserID:  "default_user", // Default user, can be changed per device
-	}
+	go engine.flushHitsLoop()
+	go engine.eventLoop()
+	go engine.expireLoop()
 
-	// Load whitelist from config
-	for _, domain := range cfg.Whitelist.Domains {
-		engine.whitelist[normalizeDomain(domain)] = true
+	if err := engine.loadSources(); err != nil {
+		log.Warnf("Failed to load blocklist sources: %v", err)
 	}
 
 	// Load blocklists from database
@@ -93,6 +164,9 @@ func New(cfg *config.Config, db *database.DB) (*Engine, error) {
 	if len(engine.blockedDomains) == 0 {
 		log.Info("No blocklists found in database, fetching default lists...")
 		engine.UpdateBlocklists()
+	} else {
+		// Policy zones are not stored in the database
+		go engine.updateRPZZones()
 	}
 
 	// ✨ INITIALIZE NEW FEATURES
@@ -104,6 +178,89 @@ func New(cfg *config.Config, db *database.DB) (*Engine, error) {
 	return engine, nil
 }
 
+// newEngine builds an engine with the rules from the config and the database,
+// without starting its loops or loading the blocklists
+func newEngine(cfg *config.Config, db *database.DB) (*Engine, error) {
+	log := logger.Get()
+
+	// Create HTTP client for downloading blocklists, with its own resolver
+	// and the configured proxy
+	httpClient, dial, err := newOutbound(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := &Engine{
+		cfg:            cfg,
+		db:             db,
+		log:            log,
+		blockedDomains: make(map[string][]string),
+		customBlocked:  make(map[string]bool),
+		whitelist:      make(map[string]bool),
+		scopedRules:    make(map[string][]*customRule),
+		overrides:      make(map[string][]*Override),
+		shadowCounts:   make(map[string]*shadowCount),
+		events:         newEventBus(),
+		tunnel:         newTunnelState(),
+		newDomains:     newNewDomainState(),
+		patternHits:    make(map[string]*uint64),
+		sourceStatus:   make(map[string]*SourceStatus),
+		sourceDomains:  make(map[string][]string),
+		sourceHits:     make(map[string]*uint64),
+		sources:        append([]config.BlocklistSource(nil), cfg.Blocklists.Sources...),
+		apiSources:     make(map[string]bool),
+		rpzZones:       make(map[string]*rpzZone),
+		httpClient:     httpClient,
+		dial:           dial,
+		currentUserID:  "default_user", // Default user, can be changed per device
+	}
+
+	engine.clientGroups, err = loadClientGroups(cfg.Clients.Groups)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client groups: %w", err)
+	}
+
+	// Load custom blocks and the whitelist from the config, custom YAML files
+	// and the API (patterns are compiled with the other rules)
+	if err := engine.syncCustomEntries(); err != nil {
+		log.Warnf("Failed to load custom entries: %v", err)
+	}
+
+	if err := engine.rebuildPatterns(); err != nil {
+		log.Warnf("Failed to load pattern rules: %v", err)
+	}
+
+	if err := engine.loadOverrides(); err != nil {
+		log.Warnf("Failed to load temporary overrides: %v", err)
+	}
+	if err := engine.loadPauses(); err != nil {
+		log.Warnf("Failed to load pauses: %v", err)
+	}
+	if err := engine.loadWalledGarden(); err != nil {
+		return nil, fmt.Errorf("invalid walled garden: %w", err)
+	}
+	if err := engine.loadSchedule(); err != nil {
+		return nil, fmt.Errorf("invalid schedule: %w", err)
+	}
+	if err := engine.loadBudgets(); err != nil {
+		return nil, fmt.Errorf("invalid time budgets: %w", err)
+	}
+	if err := engine.loadHomograph(); err != nil {
+		return nil, err
+	}
+	if err := engine.loadNewDomains(); err != nil {
+		return nil, err
+	}
+	if err := engine.loadEncryptedDNS(); err != nil {
+		return nil, err
+	}
+
+	engine.registerBuiltinStages()
+	engine.warnUnknownStages()
+
+	return engine, nil
+}
+
 // ✨ NEW METHOD - Initialize all 5 new features
 func (e *Engine) initializeNewFeatures() error {
 	// Get SQL database from your database wrapper
@@ -143,6 +300,9 @@ func (e *Engine) initializeNewFeatures() error {
 	if err != nil {
 		return fmt.Errorf("failed to init AI blocker: %w", err)
 	}
+	e.aiBlocker.SetPredictionSink(func(result aiblock.PredictionResult) {
+		e.publish(sideEvent{kind: eventPrediction, prediction: result})
+	})
 	e.log.Info("✓ AI blocker ready (pattern recognition)")
 
 	// 5. Initialize Block Page Server
@@ -152,6 +312,7 @@ func (e *Engine) initializeNewFeatures() error {
 		e.log.Warnf("Failed to initialize block page server: %v", err)
 		return fmt.Errorf("failed to init block page server: %w", err)
 	}
+	e.blockPageServer.SetBudgetLookup(e.blockPageBudget)
 	e.blockPageServer.Start()
 	e.log.Info("✓ Block page server started on :80")
 
@@ -159,114 +320,31 @@ func (e *Engine) initializeNewFeatures() error {
 	return nil
 }
 
-// ✨ UPDATED METHOD - Now returns reason for blocking
-func (e *Engine) ShouldBlock(domain string, clientIP string) (bool, string) {
+// ObserveQuery feeds the first-seen store and the tunneling detector. It is
+// called for every query, answered from the cache or not.
+func (e *Engine) ObserveQuery(domain, clientIP string, qtype uint16) {
 	domain = normalizeDomain(domain)
+	e.observeFirstSeen(domain)
+	e.observeTunnel(domain, clientIP, qtype)
+}
 
-	// Never block empty domains
-	if domain == "" {
-		return false, ""
-	}
-
-	// Check whitelist first (highest priority)
-	if e.isWhitelisted(domain) {
-		return false, "whitelisted"
-	}
-
-	// Check schedule
-	if e.cfg.Filtering.Schedule.Enabled && !e.isInAllowedTime() {
-		if len(e.cfg.Filtering.Schedule.Rules) > 0 && e.cfg.Filtering.Schedule.Rules[0].StrictMode {
-			e.trackBlockAttempt(domain, true, "schedule")
-			return true, "schedule"
-		}
-	}
-
-	// ✨ NEW - Check Categories (fast, high priority)
-	if e.categoryMgr != nil {
-		if blocked, category := e.categoryMgr.IsBlocked(domain); blocked {
-			e.trackBlockAttempt(domain, true, "category:"+category)
-			return true, "category:" + category
-		}
-	}
-
-	// ✨ NEW - Check Keywords (fast, catches patterns)
-	if e.keywordMgr != nil {
-		if blocked, keywords, listID := e.keywordMgr.CheckDomain(domain); blocked {
-			reason := fmt.Sprintf("keyword:%s", listID)
-			if len(keywords) > 0 {
-				reason = fmt.Sprintf("keyword:%s:%s", listID, keywords[0])
-			}
-			e.trackBlockAttempt(domain, true, reason)
-			return true, reason
-		}
-	}
-
-	e.mu.RLock()
-	customBlocked := e.customBlocked[domain]
-	directBlocked := e.blockedDomains[domain]
-	e.mu.RUnlock()
-
-	// Check custom blocklist
-	if customBlocked {
-		e.trackBlockAttempt(domain, true, "custom")
-		return true, "custom"
-	}
-
-	// Direct match in main blocklist
-	if directBlocked {
-		e.trackBlockAttempt(domain, true, "blocklist")
-		return true, "blocklist"
-	}
-
-	// Check subdomains (e.g., ads.example.com -> example.com)
-	parts := strings.Split(domain, ".")
-	for i := 1; i < len(parts); i++ {
-		parent := strings.Join(parts[i:], ".")
-
-		e.mu.RLock()
-		parentBlocked := e.blockedDomains[parent] || e.customBlocked[parent]
-		e.mu.RUnlock()
-
-		if parentBlocked {
-			e.trackBlockAttempt(domain, true, "blocklist:subdomain")
-			return true, "blocklist:subdomain"
-		}
-	}
-
-	// ✨ NEW - Check AI as last resort (slower but catches new threats)
-	if e.aiBlocker != nil {
-		result := e.aiBlocker.Predict(domain)
-		if result.Blocked {
-			reason := fmt.Sprintf("ai:%.0f%%", result.Confidence)
-			e.trackBlockAttempt(domain, true, reason)
-			return true, reason
-		}
-	}
-
-	// Not blocked - track as allowed
-	e.trackBlockAttempt(domain, false, "")
-	return false, ""
+// ✨ UPDATED METHOD - Now returns reason for blocking
+func (e *Engine) ShouldBlock(domain string, clientIP string) (bool, string) {
+	r := e.Decide(domain, clientIP)
+	return r.Verdict == VerdictBlock, r.Reason
 }
 
-// ✨ NEW METHOD - Track block attempts for gamification
+// ✨ NEW METHOD - Track block attempts for gamification. Points and AI
+// training are written later in batches, see events.go.
 func (e *Engine) trackBlockAttempt(domain string, blocked bool, reason string) {
 	if e.gamificationMgr != nil {
-		// Award points for successfully resisting blocked sites
-		e.gamificationMgr.OnBlockAttempt(e.currentUserID, domain, blocked)
-
-		// If AI caught something new, teach it
-		if blocked && e.aiBlocker != nil && strings.HasPrefix(reason, "ai:") {
-			// AI already predicted it, no need to re-teach
-		} else if blocked && e.aiBlocker != nil {
-			// Other system caught it, teach AI for future
-			category := "suspicious"
-			if strings.Contains(reason, "adult") {
-				category = "adult"
-			} else if strings.Contains(reason, "gambling") {
-				category = "gambling"
-			}
-			e.aiBlocker.LearnFromBlock(domain, category)
-		}
+		e.publish(sideEvent{
+			kind:    eventAttempt,
+			userID:  e.currentUserID,
+			domain:  domain,
+			blocked: blocked,
+			reason:  reason,
+		})
 	}
 }
 
@@ -332,208 +410,174 @@ func (e *Engine) GetAIStats() map[string]interface{} {
 }
 
 func (e *Engine) isWhitelisted(domain string) bool {
+	return e.whitelisted(domain, true)
+}
+
+// whitelisted reports whether domain is whitelisted, counting a hit for the
+// allow pattern that matched it only when count is set
+func (e *Engine) whitelisted(domain string, count bool) bool {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
 	if e.whitelist[domain] {
 		return true
 	}
-
-	for whitelistedDomain := range e.whitelist {
-		if strings.HasPrefix(whitelistedDomain, "*.") {
-			pattern := strings.TrimPrefix(whitelistedDomain, "*.")
-			if strings.HasSuffix(domain, pattern) {
-				return true
-			}
-		}
+	if !count {
+		return e.allowPatterns.find(domain) != nil
 	}
-
-	return false
+	return e.allowPatterns.Match(domain) != nil
 }
 
-func (e *Engine) isInAllowedTime() bool {
-	if len(e.cfg.Filtering.Schedule.Rules) == 0 {
-		return true
-	}
-
-	now := time.Now()
-	currentDay := strings.ToLower(now.Weekday().String())
-	currentTime := now.Format("15:04")
+// UpdateBlocklists fetches every enabled source and rebuilds the blocklist
+func (e *Engine) UpdateBlocklists() error {
+	return e.updateBlocklists("update", true, func(config.BlocklistSource) bool { return true })
+}
 
-	for _, rule := range e.cfg.Filtering.Schedule.Rules {
-		dayMatch := false
-		for _, day := range rule.Days {
-			if strings.ToLower(day) == currentDay {
-				dayMatch = true
-				break
-			}
-		}
-		if !dayMatch {
-			continue
-		}
-		if currentTime >= rule.StartTime && currentTime <= rule.EndTime {
-			return false
-		}
-	}
+// ForceUpdateBlocklists fetches every enabled source and applies the result
+// even if it exceeds max_change_percent
+func (e *Engine) ForceUpdateBlocklists() error {
+	return e.updateBlocklists("forced update", false, func(config.BlocklistSource) bool { return true })
+}
 
-	return true
+// RebuildBlocklist merges the enabled sources again without downloading the
+// ones whose content is already in memory
+func (e *Engine) RebuildBlocklist() error {
+	return e.updateBlocklists("source change", false, func(config.BlocklistSource) bool { return false })
 }
 
-func (e *Engine) UpdateBlocklists() error {
+// updateBlocklists fetches the sources selected by refetch, and any enabled
+// source that has never been loaded, then merges all enabled sources. With
+// guard set the result is checked against max_change_percent.
+func (e *Engine) updateBlocklists(cause string, guard bool, refetch func(config.BlocklistSource) bool) error {
+	e.updateMu.Lock()
+	defer e.updateMu.Unlock()
+
 	e.log.Info("Updating blocklists...")
 
-	newBlocked := make(map[string]bool)
+	newBlocked := make(map[string][]string)
+	replaced := make(map[string][]string) // previous content of sources that changed
 	totalDomains := 0
 
-	for _, source := range e.cfg.Blocklists.Sources {
+	for _, source := range e.getSources() {
 		if !source.Enabled {
 			continue
 		}
 
-		e.log.Infof("Fetching blocklist: %s", source.Name)
+		e.mu.RLock()
+		_, cached := e.sourceDomains[source.Name]
+		_, zoneLoaded := e.rpzZones[source.Name]
+		e.mu.RUnlock()
 
-		domains, err := e.fetchBlocklist(source.URL)
-		if err != nil {
-			e.log.Errorf("Failed to fetch %s: %v", source.Name, err)
+		if isRPZSource(source) {
+			if refetch(source) || !zoneLoaded {
+				e.log.Infof("Loading response policy zone: %s", source.Name)
+				e.updateRPZSource(source)
+			}
 			continue
 		}
 
-		for _, domain := range domains {
-			newBlocked[domain] = true
+		fetched := refetch(source) || !cached
+		var fetch *sourceFetch
+		var err error
+		if fetched {
+			e.log.Infof("Fetching blocklist: %s", source.Name)
+			fetch, err = e.fetchBlocklist(source)
+			e.recordSourceStatus(source, fetch, err)
 		}
 
-		totalDomains += len(domains)
-		e.log.Infof("Loaded %d domains from %s", len(domains), source.Name)
-	}
+		e.mu.Lock()
+		switch {
+		case !fetched:
+			// Merged from memory without a download
+		case err != nil:
+			e.log.Errorf("Failed to fetch %s: %v (keeping previous content)", source.Name, err)
+		case fetch.result == nil:
+			e.log.Infof("%s is unchanged", source.Name)
+		default:
+			replaced[source.Name] = e.sourceDomains[source.Name]
+			e.sourceDomains[source.Name] = fetch.result.Domains
+			e.log.Infof("Loaded %d domains from %s (format: %s)", len(fetch.result.Domains), source.Name, fetch.result.Format)
+			if fetch.result.ParseErrors > 0 {
+				e.log.Warnf("%s: %d lines could not be parsed", source.Name, fetch.result.ParseErrors)
+			}
+		}
+		domains := e.sourceDomains[source.Name]
+		e.mu.Unlock()
 
-	// Load and merge custom YAML blocklists
-	customDomains, customCount := e.loadCustomYAMLBlocklists()
-	for domain := range customDomains {
-		newBlocked[domain] = true
-	}
-	if customCount > 0 {
-		e.log.Infof("Loaded %d domains from custom blocklists", customCount)
+		for _, domain := range domains {
+			newBlocked[domain] = append(newBlocked[domain], source.Name)
+		}
+		totalDomains += len(domains)
 	}
 
-	e.mu.Lock()
-	e.blockedDomains = newBlocked
-	e.mu.Unlock()
-
-	if err := e.db.SaveBlocklist(newBlocked); err != nil {
-		e.log.Errorf("Failed to save blocklist to database: %v", err)
+	// Custom YAML blocklists are kept in the custom store, outside the merged list
+	custom, err := e.syncCustomFiles()
+	if err != nil {
+		e.log.Warnf("Failed to load custom blocklists: %v", err)
+	} else if custom.Count > 0 {
+		e.log.Infof("Loaded %d domains from custom blocklists", custom.Count)
 	}
 
-	e.log.Infof("Blocklist update complete: %d total domains blocked", totalDomains+customCount)
-	return nil
+	e.log.Infof("Merged %d domains from all sources", totalDomains)
+	return e.applyBlocklist(newBlocked, cause, guard, replaced)
 }
 
-// loadCustomYAMLBlocklists reads all custom*.yaml files and returns blocked domains
-func (e *Engine) loadCustomYAMLBlocklists() (map[string]bool, int) {
-	result := make(map[string]bool)
-	count := 0
-
-	files, err := filepath.Glob(e.cfg.Blocklists.CustomPath)
-	if err != nil || len(files) == 0 {
-		return result, 0
-	}
-
-	for _, file := range files {
-		data, err := os.ReadFile(file)
-		if err != nil {
-			e.log.Warnf("Failed to read custom blocklist %s: %v", file, err)
-			continue
-		}
-
-		var bl CustomBlocklist
-		if err := yaml.Unmarshal(data, &bl); err != nil {
-			e.log.Warnf("Failed to parse custom blocklist %s: %v", file, err)
-			continue
-		}
-
-		for _, entry := range bl.Domains {
-			if !entry.Enabled {
-				continue
-			}
-			domain := normalizeDomain(entry.Domain)
-			if domain != "" {
-				result[domain] = true
-				count++
-			}
-		}
-		e.log.Infof("Loaded custom blocklist: %s (%d enabled domains)", file, count)
+// ReloadCustomBlocklists reloads only custom YAML blocklists without fetching
+// remote sources. Errors in single files are reported in the result.
+func (e *Engine) ReloadCustomBlocklists() (*CustomReload, error) {
+	reload, err := e.syncCustomFiles()
+	if err != nil {
+		return reload, err
 	}
 
-	return result, count
+	e.log.Infof("Reloaded %d custom blocklist domains (%d changed)", reload.Count, len(reload.Changed))
+	return reload, nil
 }
 
-// ReloadCustomBlocklists reloads only custom YAML blocklists without fetching remote sources
-func (e *Engine) ReloadCustomBlocklists() (int, error) {
-	customDomains, count := e.loadCustomYAMLBlocklists()
-
-	e.mu.Lock()
-	for domain := range customDomains {
-		e.customBlocked[domain] = true
-	}
-	e.mu.Unlock()
+// errNotModified is returned when the server has nothing newer than the
+// validators that were sent
+var errNotModified = errors.New("not modified")
 
-	e.log.Infof("Reloaded %d custom blocklist domains", count)
-	return count, nil
+// openBlocklist returns the raw contents of a remote or file:// list
+func (e *Engine) openBlocklist(url string) (io.ReadCloser, error) {
+	body, _, err := e.openBlocklistIfModified(url, "", "")
+	return body, err
 }
 
-func (e *Engine) fetchBlocklist(url string) ([]string, error) {
+// openBlocklistIfModified sends If-None-Match and If-Modified-Since for remote
+// lists and returns the response headers along with the body
+func (e *Engine) openBlocklistIfModified(url, etag, lastModified string) (io.ReadCloser, http.Header, error) {
 	if strings.HasPrefix(url, "file://") {
-		return e.fetchLocalBlocklist(strings.TrimPrefix(url, "file://"))
+		f, err := os.Open(strings.TrimPrefix(url, "file://"))
+		return f, nil, err
 	}
 
-	resp, err := e.httpClient.Get(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
 	}
-
-	var domains []string
-	scanner := bufio.NewScanner(resp.Body)
-	buf := make([]byte, 1024*1024)
-	scanner.Buffer(buf, len(buf))
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
-			continue
-		}
-		domain := parseDomainFromLine(line)
-		if domain != "" {
-			domains = append(domains, normalizeDomain(domain))
-		}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
 	}
 
-	return domains, scanner.Err()
-}
-
-func (e *Engine) fetchLocalBlocklist(path string) ([]string, error) {
-	f, err := os.Open(path)
+	resp, err := e.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer f.Close()
 
-	var domains []string
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		domain := parseDomainFromLine(line)
-		if domain != "" {
-			domains = append(domains, normalizeDomain(domain))
-		}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, resp.Header, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		return nil, nil, errNotModified
+	default:
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("HTTP %d", resp.StatusCode)
 	}
-	return domains, scanner.Err()
 }
 
 func (e *Engine) loadBlocklists() error {
@@ -542,29 +586,82 @@ func (e *Engine) loadBlocklists() error {
 		return err
 	}
 
+	// Custom entries used to be merged into the blocklist; they now have their
+	// own store, so drop them from the rows loaded here
+	if upserts, removed := stripCustomSource(domains); len(upserts) > 0 || len(removed) > 0 {
+		if err := e.db.UpdateBlocklist(upserts, removed); err != nil {
+			e.log.Warnf("Failed to remove custom entries from the blocklist: %v", err)
+		}
+	}
+
+	// Rebuild the per-source lists so the next update can skip unchanged sources
+	bySource := make(map[string][]string)
+	for domain, sources := range domains {
+		for _, name := range sources {
+			if name != customSourceName {
+				bySource[name] = append(bySource[name], domain)
+			}
+		}
+	}
+
 	e.mu.Lock()
 	e.blockedDomains = domains
+	e.sourceDomains = bySource
+	e.ensureSourceCounters(domains)
 	e.mu.Unlock()
 
 	return nil
 }
 
+// stripCustomSource removes the custom source from a blocklist, dropping the
+// domains listed by nothing else. It returns the rows to update and remove.
+func stripCustomSource(domains map[string][]string) (map[string][]string, []string) {
+	upserts := make(map[string][]string)
+	var removed []string
+	for domain, sources := range domains {
+		kept := sources[:0:0]
+		for _, name := range sources {
+			if name != customSourceName {
+				kept = append(kept, name)
+			}
+		}
+		switch {
+		case len(kept) == len(sources):
+		case len(kept) == 0:
+			delete(domains, domain)
+			removed = append(removed, domain)
+		default:
+			domains[domain] = kept
+			upserts[domain] = kept
+		}
+	}
+	return upserts, removed
+}
+
 // ─── Whitelist Methods ────────────────────────────────────────────────────────
 
-func (e *Engine) AddToWhitelist(domain string) {
-	domain = normalizeDomain(domain)
-	e.mu.Lock()
-	e.whitelist[domain] = true
-	e.mu.Unlock()
-	e.db.AddToWhitelist(domain)
+// AddToWhitelist allows a domain, or a "/regex/" or glob pattern, which is
+// stored as an allow rule
+func (e *Engine) AddToWhitelist(domain string) error {
+	if IsPattern(domain) {
+		rule, err := ParsePatternRule(domain, PatternActionAllow)
+		if err != nil {
+			return err
+		}
+		_, err = e.AddPatternRule(rule.Pattern, rule.Kind, PatternActionAllow, "")
+		return err
+	}
+
+	return e.addCustomEntry(normalizeDomain(domain), CustomListAllow)
 }
 
-func (e *Engine) RemoveFromWhitelist(domain string) {
-	domain = normalizeDomain(domain)
-	e.mu.Lock()
-	delete(e.whitelist, domain)
-	e.mu.Unlock()
-	e.db.RemoveFromWhitelist(domain)
+// RemoveFromWhitelist removes a domain or an allow pattern added through the
+// API. Domains from custom files are disabled in their file.
+func (e *Engine) RemoveFromWhitelist(domain string) error {
+	if IsPattern(domain) {
+		return e.removeInlinePattern(domain, PatternActionAllow)
+	}
+	return e.removeCustomEntry(normalizeDomain(domain), CustomListAllow)
 }
 
 func (e *Engine) GetWhitelist() []string {
@@ -575,25 +672,61 @@ func (e *Engine) GetWhitelist() []string {
 	for domain := range e.whitelist {
 		list = append(list, domain)
 	}
+	for _, rule := range e.patternRules {
+		if rule.Action == PatternActionAllow && rule.Enabled {
+			list = append(list, inlinePattern(rule))
+		}
+	}
 	return list
 }
 
 // ─── Custom Blocklist Methods ─────────────────────────────────────────────────
 
-func (e *Engine) AddToCustomBlocklist(domain string) {
+// AddToCustomBlocklist blocks a domain, or a "/regex/" or glob pattern, which
+// is stored as a block rule
+func (e *Engine) AddToCustomBlocklist(domain string) error {
+	if IsPattern(domain) {
+		rule, err := ParsePatternRule(domain, PatternActionBlock)
+		if err != nil {
+			return err
+		}
+		_, err = e.AddPatternRule(rule.Pattern, rule.Kind, PatternActionBlock, "")
+		return err
+	}
+
 	domain = normalizeDomain(domain)
-	e.mu.Lock()
-	e.customBlocked[domain] = true
-	e.mu.Unlock()
+	if err := e.addCustomEntry(domain, CustomListBlock); err != nil {
+		return err
+	}
 	e.log.Infof("Added %s to custom blocklist", domain)
+	return nil
 }
 
-func (e *Engine) RemoveFromCustomBlocklist(domain string) {
+// RemoveFromCustomBlocklist removes a domain or a block pattern added through
+// the API. Domains from custom files are disabled in their file.
+func (e *Engine) RemoveFromCustomBlocklist(domain string) error {
+	if IsPattern(domain) {
+		return e.removeInlinePattern(domain, PatternActionBlock)
+	}
+
 	domain = normalizeDomain(domain)
-	e.mu.Lock()
-	delete(e.customBlocked, domain)
-	e.mu.Unlock()
+	if err := e.removeCustomEntry(domain, CustomListBlock); err != nil {
+		return err
+	}
 	e.log.Infof("Removed %s from custom blocklist", domain)
+	return nil
+}
+
+// removeInlinePattern removes the API rules written as pattern
+func (e *Engine) removeInlinePattern(pattern, action string) error {
+	for _, rule := range e.GetPatternRules() {
+		if rule.ID > 0 && rule.Action == action && inlinePattern(&rule) == pattern {
+			if err := e.RemovePatternRule(rule.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 func (e *Engine) GetCustomBlocklist() []string {
@@ -626,13 +759,23 @@ func (e *Engine) GetBlockedCount() int {
 	return total
 }
 
+// StartAutoUpdate refreshes each source when its update interval (or the
+// default interval, if it has none) has passed since it was last checked
 func (e *Engine) StartAutoUpdate(interval time.Duration) {
-	ticker := time.NewTicker(interval)
+	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
 	for range ticker.C {
+		due := e.dueSources(interval)
+		if len(due) == 0 {
+			continue
+		}
+
 		e.log.Info("Starting scheduled blocklist update...")
-		if err := e.UpdateBlocklists(); err != nil {
+		err := e.updateBlocklists("scheduled update", true, func(source config.BlocklistSource) bool {
+			return due[source.Name]
+		})
+		if err != nil {
 			e.log.Errorf("Auto-update failed: %v", err)
 		}
 	}
@@ -640,6 +783,7 @@ func (e *Engine) StartAutoUpdate(interval time.Duration) {
 
 // ✨ NEW METHOD - Cleanup on shutdown
 func (e *Engine) Close() error {
+	e.closeEvents()
 	if e.blockPageServer != nil {
 		return e.blockPageServer.Stop()
 	}
@@ -648,39 +792,25 @@ func (e *Engine) Close() error {
 
 // ─── Helpers ──────────────────────────────────────────────────────────────────
 
+// normalizeDomain lowercases a name and maps Unicode names to their punycode
+// form with UTS #46, so both spellings of a name match the same entries.
+// Names IDNA rejects are kept as they are.
 func normalizeDomain(domain string) string {
 	domain = strings.ToLower(strings.TrimSpace(domain))
 	domain = strings.TrimSuffix(domain, ".")
-	return domain
-}
-
-func parseDomainFromLine(line string) string {
-	// Hosts file format: 0.0.0.0 example.com or 127.0.0.1 example.com
-	if strings.HasPrefix(line, "0.0.0.0") || strings.HasPrefix(line, "127.0.0.1") {
-		fields := strings.Fields(line)
-		if len(fields) >= 2 {
-			d := fields[1]
-			if d == "localhost" || d == "0.0.0.0" || d == "127.0.0.1" {
-				return ""
-			}
-			return d
+	if !isASCII(domain) {
+		if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+			domain = ascii
 		}
 	}
+	return domain
+}
 
-	// AdBlock format: ||example.com^
-	if strings.HasPrefix(line, "||") {
-		domain := strings.TrimPrefix(line, "||")
-		domain = strings.TrimSuffix(domain, "^")
-		if idx := strings.IndexAny(domain, "/^?"); idx != -1 {
-			domain = domain[:idx]
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
 		}
-		return domain
 	}
-
-	// Plain domain
-	if !strings.Contains(line, " ") && strings.Contains(line, ".") {
-		return line
-	}
-
-	return ""
+	return true
 }
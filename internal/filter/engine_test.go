@@ -0,0 +1,26 @@
+package filter
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+	"github.com/RDXFGXY1/dns-filter-app/internal/database"
+)
+
+// newTestEngine builds an engine for cfg through the same loaders as New, on
+// a database in a temporary directory
+func newTestEngine(t *testing.T, cfg *config.Config) *Engine {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	e, err := newEngine(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
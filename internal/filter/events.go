@@ -0,0 +1,216 @@
+package filter
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/aiblock"
+)
+
+// ─── Side Effects ─────────────────────────────────────────────────────────────
+//
+// Points, streaks, AI training and the AI prediction log are side effects of a
+// query that the answer does not depend on. Queries only put them on a bounded
+// queue; a single writer drains it and writes each batch with a few statements
+// instead of several per query, so disk I/O never delays an answer. When the
+// queue is full new events are dropped and counted rather than waited for.
+
+const (
+	eventQueueSize     = 8192
+	eventBatchSize     = 1024
+	eventFlushInterval = 2 * time.Second
+)
+
+type eventKind int
+
+const (
+	eventAttempt eventKind = iota
+	eventPrediction
+)
+
+// sideEvent is one side effect of a query
+type sideEvent struct {
+	kind       eventKind
+	userID     string
+	domain     string
+	blocked    bool
+	reason     string
+	prediction aiblock.PredictionResult
+}
+
+// eventBus queues side effects for the writer
+type eventBus struct {
+	queue   chan sideEvent
+	stop    chan struct{}
+	stopped chan struct{}
+
+	published uint64
+	dropped   uint64
+	written   uint64
+	batches   uint64
+	maxDepth  int64
+	lastBatch int64 // nanoseconds the last batch took to write
+}
+
+// EventStats shows how far the side effect writer keeps up with queries
+type EventStats struct {
+	Queued      int     `json:"queued"`
+	Capacity    int     `json:"capacity"`
+	MaxQueued   int64   `json:"max_queued"`
+	Published   uint64  `json:"published"`
+	Dropped     uint64  `json:"dropped"` // the queue was full
+	Written     uint64  `json:"written"`
+	Batches     uint64  `json:"batches"`
+	LastBatchMs float64 `json:"last_batch_ms"`
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		queue:   make(chan sideEvent, eventQueueSize),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// publish queues an event without ever blocking the caller
+func (e *Engine) publish(ev sideEvent) {
+	bus := e.events
+	select {
+	case bus.queue <- ev:
+		atomic.AddUint64(&bus.published, 1)
+		depth := int64(len(bus.queue))
+		for {
+			top := atomic.LoadInt64(&bus.maxDepth)
+			if depth <= top || atomic.CompareAndSwapInt64(&bus.maxDepth, top, depth) {
+				break
+			}
+		}
+	default:
+		atomic.AddUint64(&bus.dropped, 1)
+	}
+}
+
+// eventLoop writes queued events in batches, when a batch is full or every
+// eventFlushInterval, until the engine is closed
+func (e *Engine) eventLoop() {
+	bus := e.events
+	defer close(bus.stopped)
+
+	ticker := time.NewTicker(eventFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]sideEvent, 0, eventBatchSize)
+	for {
+		select {
+		case ev := <-bus.queue:
+			batch = append(batch, ev)
+			if len(batch) >= eventBatchSize {
+				e.writeEvents(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			e.writeEvents(batch)
+			batch = batch[:0]
+		case <-bus.stop:
+			for {
+				select {
+				case ev := <-bus.queue:
+					batch = append(batch, ev)
+				default:
+					e.writeEvents(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeEvents applies a batch: attempts are summed per user, and blocked
+// domains that the AI did not catch itself are taught to it
+func (e *Engine) writeEvents(batch []sideEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	start := time.Now()
+
+	type attempts struct{ blocked, allowed int }
+	perUser := make(map[string]*attempts)
+	var learn []struct{ Domain, Category string }
+	var predictions []aiblock.PredictionResult
+	learned := make(map[string]bool)
+
+	for _, ev := range batch {
+		switch ev.kind {
+		case eventPrediction:
+			predictions = append(predictions, ev.prediction)
+		case eventAttempt:
+			a, ok := perUser[ev.userID]
+			if !ok {
+				a = &attempts{}
+				perUser[ev.userID] = a
+			}
+			if !ev.blocked {
+				a.allowed++
+				continue
+			}
+			a.blocked++
+			if strings.HasPrefix(ev.reason, "ai:") || learned[ev.domain] {
+				// AI already predicted it, no need to re-teach
+				continue
+			}
+			learned[ev.domain] = true
+			learn = append(learn, struct{ Domain, Category string }{ev.domain, learnCategory(ev.reason)})
+		}
+	}
+
+	if e.gamificationMgr != nil {
+		for userID, a := range perUser {
+			e.gamificationMgr.RecordAttempts(userID, a.blocked, a.allowed)
+		}
+	}
+	if e.aiBlocker != nil {
+		if err := e.aiBlocker.LearnFromBlocks(learn); err != nil {
+			e.log.Warnf("Failed to teach the AI blocked domains: %v", err)
+		}
+		if err := e.aiBlocker.LogPredictions(predictions); err != nil {
+			e.log.Warnf("Failed to save AI predictions: %v", err)
+		}
+	}
+
+	atomic.AddUint64(&e.events.written, uint64(len(batch)))
+	atomic.AddUint64(&e.events.batches, 1)
+	atomic.StoreInt64(&e.events.lastBatch, int64(time.Since(start)))
+}
+
+// learnCategory is the AI category taught for a block reason
+func learnCategory(reason string) string {
+	switch {
+	case strings.Contains(reason, "adult"):
+		return "adult"
+	case strings.Contains(reason, "gambling"):
+		return "gambling"
+	}
+	return "suspicious"
+}
+
+// closeEvents writes what is still queued and stops the writer
+func (e *Engine) closeEvents() {
+	close(e.events.stop)
+	<-e.events.stopped
+}
+
+// GetEventStats returns the state of the side effect queue
+func (e *Engine) GetEventStats() EventStats {
+	bus := e.events
+	return EventStats{
+		Queued:      len(bus.queue),
+		Capacity:    cap(bus.queue),
+		MaxQueued:   atomic.LoadInt64(&bus.maxDepth),
+		Published:   atomic.LoadUint64(&bus.published),
+		Dropped:     atomic.LoadUint64(&bus.dropped),
+		Written:     atomic.LoadUint64(&bus.written),
+		Batches:     atomic.LoadUint64(&bus.batches),
+		LastBatchMs: float64(atomic.LoadInt64(&bus.lastBatch)) / float64(time.Millisecond),
+	}
+}
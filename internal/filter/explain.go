@@ -0,0 +1,453 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ─── Explain ──────────────────────────────────────────────────────────────────
+//
+// Explain answers "why was this blocked": it runs every stage of the DNS
+// server and the filter pipeline for a domain and client, in the order they
+// run for a query, and records what each one matched. Unlike a query it does not stop
+// at the first stage that decides, and it has no side effects: no hits are
+// counted, nothing is learned and no budget is charged. Stages added with
+// RegisterStage are the exception: they are run as for a query.
+
+const (
+	EffectAllow    = "allow"
+	EffectBlock    = "block"
+	EffectRewrite  = "rewrite"
+	EffectPause    = "pause"
+	EffectPassthru = "passthru"
+)
+
+// TraceStep is what one stage found for the domain
+type TraceStep struct {
+	Stage    string `json:"stage"`
+	Matched  bool   `json:"matched"`
+	Effect   string `json:"effect,omitempty"`   // what the stage does when it matches
+	Rule     string `json:"rule,omitempty"`     // the entry, rule or pattern that matched
+	Source   string `json:"source,omitempty"`   // where that rule comes from
+	Detail   string `json:"detail,omitempty"`   // anything else worth knowing
+	Decisive bool   `json:"decisive,omitempty"` // this stage decided the query
+}
+
+// Decision is the outcome of a query for a domain and client with the trace
+// of every stage
+type Decision struct {
+	Domain    string      `json:"domain"`
+	Client    string      `json:"client,omitempty"`
+	Blocked   bool        `json:"blocked"`
+	Effect    string      `json:"effect"`
+	Reason    string      `json:"reason,omitempty"`
+	Cacheable bool        `json:"cacheable"`
+	Trace     []TraceStep `json:"trace"`
+}
+
+// explainer collects the steps of a trace and remembers the first stage that
+// decides
+type explainer struct {
+	d       *Decision
+	decided bool
+}
+
+func (x *explainer) miss(stage, detail string) {
+	x.d.Trace = append(x.d.Trace, TraceStep{Stage: stage, Detail: detail})
+}
+
+// hit records a match. Stages with a decisive effect decide the query when
+// no earlier stage did.
+func (x *explainer) hit(step TraceStep, reason string) {
+	step.Matched = true
+	if !x.decided && step.Effect != EffectPassthru {
+		x.decided = true
+		step.Decisive = true
+		x.d.Effect = step.Effect
+		x.d.Blocked = step.Effect == EffectBlock
+		x.d.Reason = reason
+	}
+	x.d.Trace = append(x.d.Trace, step)
+}
+
+// Explain evaluates every stage for domain and clientIP
+func (e *Engine) Explain(domain, clientIP string) (*Decision, error) {
+	domain = normalizeDomain(domain)
+	if domain == "" {
+		return nil, fmt.Errorf("domain is required")
+	}
+
+	d := &Decision{Domain: domain, Client: clientIP, Effect: EffectAllow}
+	d.Cacheable = !e.cfg.Filtering.Enabled || e.IsCacheable(domain, clientIP)
+	x := &explainer{d: d}
+
+	if !e.cfg.Filtering.Enabled {
+		x.hit(TraceStep{Stage: "filtering", Effect: EffectAllow, Detail: "filtering is disabled"}, "")
+	}
+
+	if p := e.PauseFor(clientIP); p != nil {
+		x.hit(TraceStep{
+			Stage:  "pause",
+			Effect: EffectPause,
+			Rule:   p.describe(),
+			Source: p.PausedBy,
+			Detail: "until " + p.Until.Format(time.RFC3339),
+		}, "paused")
+	} else {
+		x.miss("pause", "")
+	}
+
+	if e.OverrideFor(domain, clientIP) != nil {
+		for _, stage := range []string{"rewrite", "safesearch", "rpz"} {
+			x.miss(stage, "skipped, an override applies")
+		}
+	} else if e.CheckDNSCanary(domain) != nil {
+		for _, stage := range []string{"rewrite", "safesearch", "rpz"} {
+			x.miss(stage, "skipped, a DNS canary applies")
+		}
+	} else {
+		e.explainRewrites(x, domain, clientIP)
+		e.explainRPZ(x, domain)
+	}
+
+	q := &Query{Domain: domain, ClientIP: clientIP}
+	e.mu.RLock()
+	stages := append([]Stage(nil), e.stages...)
+	e.mu.RUnlock()
+	for _, stage := range stages {
+		if !e.stageEnabled(stage) {
+			x.miss(stage.Name(), "turned off in the config")
+			continue
+		}
+		if b, ok := stage.(*builtinStage); ok {
+			b.explain(x, q)
+			continue
+		}
+		e.explainStage(x, stage, q)
+	}
+
+	if shadow := e.shadowMatches(domain); len(shadow) > 0 {
+		x.d.Trace = append(x.d.Trace, TraceStep{Stage: "shadow", Matched: true, Detail: "would block: " + strings.Join(shadow, ", ")})
+	} else {
+		x.miss("shadow", "")
+	}
+
+	return d, nil
+}
+
+func (e *Engine) explainOverride(x *explainer, q *Query) {
+	o := e.matchOverride(q.Domain, q.ClientIP)
+	if o == nil {
+		x.miss("override", "")
+		return
+	}
+	effect := EffectBlock
+	if o.Action == OverrideAllow {
+		effect = EffectAllow
+	}
+	x.hit(TraceStep{
+		Stage:  "override",
+		Effect: effect,
+		Rule:   fmt.Sprintf("#%d %s %s", o.ID, o.Action, o.Domain),
+		Source: o.Client,
+		Detail: "until " + o.ExpiresAt.Format(time.RFC3339),
+	}, "override")
+}
+
+func (e *Engine) explainWalledGarden(x *explainer, q *Query) {
+	if !e.inWalledGarden(q.ClientIP) {
+		x.miss("walled-garden", "client is not in the walled garden")
+		return
+	}
+	if site := e.gardenSite(q.Domain); site != "" {
+		x.hit(TraceStep{Stage: "walled-garden", Effect: EffectAllow, Rule: site}, "walled-garden:"+site)
+	} else {
+		x.hit(TraceStep{Stage: "walled-garden", Effect: EffectBlock, Detail: "not on the allowlist"}, "walled-garden")
+	}
+}
+
+func (e *Engine) explainSchedule(x *explainer, q *Query) {
+	if reason := e.checkSchedule(q.Domain, q.ClientIP); reason != "" {
+		x.hit(TraceStep{Stage: "schedule", Effect: EffectBlock, Rule: strings.TrimPrefix(reason, "schedule:")}, reason)
+		return
+	}
+	var active []string
+	for _, rule := range e.activeSchedules(q.ClientIP, time.Now()) {
+		active = append(active, rule.Name+" ("+rule.Action+")")
+	}
+	detail := ""
+	if len(active) > 0 {
+		detail = "active: " + strings.Join(active, ", ")
+	}
+	x.miss("schedule", detail)
+}
+
+func (e *Engine) explainPattern(x *explainer, q *Query) {
+	e.mu.RLock()
+	blockPatterns := e.blockPatterns
+	e.mu.RUnlock()
+	if rule := blockPatterns.find(q.Domain); rule != nil {
+		x.hit(TraceStep{Stage: "pattern", Effect: EffectBlock, Rule: rule.Pattern, Source: patternSource(rule)}, "pattern:"+rule.Pattern)
+	} else {
+		x.miss("pattern", "")
+	}
+}
+
+func (e *Engine) explainAI(x *explainer, q *Query) {
+	if e.aiBlocker == nil {
+		return
+	}
+	if e.cfg.Filtering.Shadow.AI {
+		x.miss("ai", "runs in shadow")
+		return
+	}
+	result := e.aiBlocker.Evaluate(q.Domain)
+	detail := fmt.Sprintf("%s, %.0f%% confidence", result.Category, result.Confidence)
+	if result.Blocked {
+		x.hit(TraceStep{Stage: "ai", Effect: EffectBlock, Rule: result.Category, Detail: detail}, fmt.Sprintf("ai:%.0f%%", result.Confidence))
+	} else {
+		x.miss("ai", detail)
+	}
+}
+
+func (e *Engine) explainBudget(x *explainer, q *Query) {
+	b := e.budgetFor(q.Domain, q.ClientIP)
+	if b == nil {
+		x.miss("budget", "")
+		return
+	}
+	step := TraceStep{
+		Stage:  "budget",
+		Effect: EffectBlock,
+		Rule:   b.Name,
+		Detail: fmt.Sprintf("%d of %d minutes used", b.Used, b.Limit),
+	}
+	if b.Exhausted {
+		x.hit(step, "budget:"+b.Name)
+	} else {
+		step.Effect = ""
+		x.d.Trace = append(x.d.Trace, step)
+	}
+}
+
+// explainStage traces a stage added with RegisterStage. It cannot be traced
+// without side effects, so it is run as for a query.
+func (e *Engine) explainStage(x *explainer, s Stage, q *Query) {
+	r := s.Check(q)
+	step := TraceStep{Stage: s.Name(), Source: "stage"}
+	switch r.Verdict {
+	case VerdictAllow:
+		step.Effect = EffectAllow
+	case VerdictBlock:
+		step.Effect = EffectBlock
+	case VerdictRewrite:
+		if r.Rewrite == nil {
+			x.miss(s.Name(), "rewrite without an answer")
+			return
+		}
+		step.Effect = EffectRewrite
+		if r.Reason == "" {
+			r.Reason = r.Rewrite.Reason()
+		}
+	default:
+		x.miss(s.Name(), "")
+		return
+	}
+	x.hit(step, r.Reason)
+}
+
+// explainRewrites covers the rewrites the DNS server answers before filtering
+func (e *Engine) explainRewrites(x *explainer, domain, clientIP string) {
+	if rule := e.matchScopedRule(domain, clientIP, CustomListRewrite); rule != nil {
+		x.hit(TraceStep{
+			Stage:  "rewrite",
+			Effect: EffectRewrite,
+			Rule:   rule.domain,
+			Source: e.entrySource(rule.domain, CustomListRewrite),
+			Detail: "answers with " + rule.scope.Rewrite,
+		}, rule.rewrite.Reason())
+	} else {
+		x.miss("rewrite", "")
+	}
+
+	if policy := e.checkSafeSearch(domain, clientIP); policy != nil {
+		x.hit(TraceStep{Stage: "safesearch", Effect: EffectRewrite, Rule: policy.Trigger}, policy.Reason())
+	} else {
+		x.miss("safesearch", "")
+	}
+}
+
+func (e *Engine) explainRPZ(x *explainer, domain string) {
+	policy := e.CheckRPZ(domain)
+	if policy == nil {
+		x.miss("rpz", "")
+		return
+	}
+
+	step := TraceStep{Stage: "rpz", Rule: policy.Trigger, Source: policy.Zone, Detail: policy.Action}
+	switch policy.Action {
+	case RPZActionPassthru:
+		step.Effect = EffectPassthru
+	case RPZActionLocalData:
+		step.Effect = EffectRewrite
+	default:
+		step.Effect = EffectBlock
+	}
+	x.hit(step, policy.Reason())
+}
+
+func (e *Engine) explainWhitelist(x *explainer, q *Query) {
+	domain, clientIP := q.Domain, q.ClientIP
+	found := false
+
+	e.mu.RLock()
+	listed := e.whitelist[domain]
+	rule := e.allowPatterns.find(domain)
+	e.mu.RUnlock()
+
+	if listed {
+		found = true
+		x.hit(TraceStep{Stage: "whitelist", Effect: EffectAllow, Rule: domain, Source: e.entrySource(domain, CustomListAllow)}, "whitelisted")
+	}
+	if rule != nil {
+		found = true
+		x.hit(TraceStep{Stage: "whitelist", Effect: EffectAllow, Rule: rule.Pattern, Source: patternSource(rule)}, "whitelisted")
+	}
+	if scoped := e.matchScopedRule(domain, clientIP, CustomListAllow); scoped != nil {
+		found = true
+		x.hit(TraceStep{
+			Stage:  "whitelist",
+			Effect: EffectAllow,
+			Rule:   scoped.domain,
+			Source: e.entrySource(scoped.domain, CustomListAllow),
+			Detail: "scoped entry (" + scoped.scope.Match + ")",
+		}, "whitelisted")
+	}
+	if !found {
+		x.miss("whitelist", "")
+	}
+}
+
+func (e *Engine) explainCategory(x *explainer, q *Query) {
+	domain := q.Domain
+	if e.categoryMgr == nil {
+		return
+	}
+	blocked, category := e.categoryMgr.IsBlocked(domain)
+	if blocked && e.shadowCategory(category) {
+		x.miss("category", "listed in "+category+", which runs in shadow")
+		return
+	}
+	if blocked {
+		x.hit(TraceStep{Stage: "category", Effect: EffectBlock, Rule: category}, "category:"+category)
+		return
+	}
+	detail := ""
+	if category := e.categoryMgr.Lookup(domain); category != "" {
+		detail = "listed in " + category + ", which is not blocked"
+	}
+	x.miss("category", detail)
+}
+
+func (e *Engine) explainKeywords(x *explainer, q *Query) {
+	domain := q.Domain
+	if e.keywordMgr == nil {
+		return
+	}
+	listID, keywords := e.keywordMgr.FindKeywords(domain, e.shadowKeywordLists())
+	if listID == "" {
+		x.miss("keyword", "")
+		return
+	}
+	reason := "keyword:" + listID + ":" + keywords[0]
+	x.hit(TraceStep{Stage: "keyword", Effect: EffectBlock, Rule: strings.Join(keywords, ", "), Source: listID}, reason)
+}
+
+// explainCustom walks the custom blocklist from the name to its parents
+func (e *Engine) explainCustom(x *explainer, q *Query) {
+	if scoped := e.matchScopedRule(q.Domain, q.ClientIP, CustomListBlock); scoped != nil {
+		e.mu.RLock()
+		exact := e.customBlocked[q.Domain]
+		e.mu.RUnlock()
+		if !exact {
+			x.hit(TraceStep{
+				Stage:  "custom",
+				Effect: EffectBlock,
+				Rule:   scoped.domain,
+				Source: e.entrySource(scoped.domain, CustomListBlock),
+				Detail: "scoped entry (" + scoped.scope.Match + ")",
+			}, "custom")
+			return
+		}
+	}
+
+	for name := q.Domain; ; {
+		e.mu.RLock()
+		custom := e.customBlocked[name]
+		e.mu.RUnlock()
+
+		if custom {
+			x.hit(TraceStep{Stage: "custom", Effect: EffectBlock, Rule: name, Source: e.entrySource(name, CustomListBlock)}, "custom")
+			return
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	x.miss("custom", "")
+}
+
+// explainBlocklist walks the blocklist sources from the name to its parents
+func (e *Engine) explainBlocklist(x *explainer, q *Query) {
+	for name := q.Domain; ; {
+		e.mu.RLock()
+		sources, listed := e.blockedDomains[name]
+		e.mu.RUnlock()
+		if listed {
+			sources, listed = e.liveSources(sources)
+		}
+
+		if listed {
+			x.hit(TraceStep{Stage: "blocklist", Effect: EffectBlock, Rule: name, Source: strings.Join(sources, ", ")}, blocklistReason(sources))
+			return
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	x.miss("blocklist", "")
+}
+
+// entrySource describes where a custom entry of list for domain comes from
+func (e *Engine) entrySource(domain, list string) string {
+	entries, err := e.GetCustomEntries(list)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if entry.Domain != domain || !entry.Enabled {
+			continue
+		}
+		if entry.Origin == EntryOriginFile {
+			return "file:" + entry.File
+		}
+		return entry.Origin
+	}
+	return ""
+}
+
+// patternSource describes where a pattern rule comes from
+func patternSource(rule *PatternRule) string {
+	switch {
+	case rule.ID > 0:
+		return fmt.Sprintf("rule #%d", rule.ID)
+	case rule.Source != "":
+		return "file:" + rule.Source
+	}
+	return EntryOriginConfig
+}
@@ -0,0 +1,492 @@
+package filter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ─── Blocklist Formats ────────────────────────────────────────────────────────
+//
+// Every source format is a ListParser registered under a name that can be
+// used as the `format` of a blocklist source. With no format (or "auto") the
+// dominant format is detected from the start of the file, and lines it cannot
+// read are retried with the other line-based parsers, so mixed lists still
+// load the way they always did.
+
+const (
+	FormatAuto    = "auto"
+	FormatHosts   = "hosts"
+	FormatAdblock = "adblock"
+	FormatDomains = "domains"
+	FormatDnsmasq = "dnsmasq"
+	FormatUnbound = "unbound"
+	FormatRPZ     = "rpz"
+
+	detectSampleLines = 200
+	maxErrorSamples   = 5
+)
+
+// ListParser turns one line of a blocklist into domains. A nil slice with a
+// nil error means the line carries nothing to block (an exception rule, a
+// directive, a localhost entry).
+type ListParser interface {
+	ParseLine(line string) ([]string, error)
+}
+
+var (
+	parsersMu   sync.RWMutex
+	listParsers = map[string]func() ListParser{
+		FormatHosts:   func() ListParser { return hostsParser{} },
+		FormatAdblock: func() ListParser { return adblockParser{} },
+		FormatDomains: func() ListParser { return domainsParser{} },
+		FormatDnsmasq: func() ListParser { return dnsmasqParser{} },
+		FormatUnbound: func() ListParser { return unboundParser{} },
+		FormatRPZ:     func() ListParser { return &rpzLineParser{} },
+	}
+)
+
+// RegisterListParser adds or replaces the parser used for a format name.
+// The factory is called once per fetch so parsers may keep state.
+func RegisterListParser(format string, factory func() ListParser) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	listParsers[format] = factory
+}
+
+// ListFormats returns the names of all registered formats
+func ListFormats() []string {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+
+	names := make([]string, 0, len(listParsers))
+	for name := range listParsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newListParser(format string) (ListParser, bool) {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+
+	factory, ok := listParsers[format]
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// parseResult is what a source produced on its last parse
+type parseResult struct {
+	Domains      []string
+	Format       string
+	Rules        int // policy rules, for sources that are not plain domain lists
+	Lines        int
+	ParseErrors  int
+	ErrorSamples []string
+}
+
+func (r *parseResult) addError(lineNo int, line string, err error) {
+	r.ParseErrors++
+	if len(r.ErrorSamples) < maxErrorSamples {
+		r.ErrorSamples = append(r.ErrorSamples, fmt.Sprintf("line %d: %v: %q", lineNo, err, truncate(line, 80)))
+	}
+}
+
+// parseBlocklist reads a whole list in the given format ("" or "auto" to
+// detect it)
+func parseBlocklist(r io.Reader, format string) (*parseResult, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 1024*1024)
+	scanner.Buffer(buf, len(buf))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	auto := format == "" || format == FormatAuto
+	if auto {
+		format = detectFormat(lines)
+	}
+
+	parser, ok := newListParser(format)
+	if !ok {
+		return nil, fmt.Errorf("unknown blocklist format %q", format)
+	}
+
+	// Fallbacks for mixed lists; RPZ is stateful and never mixed
+	var fallbacks []ListParser
+	if auto && format != FormatRPZ {
+		for _, name := range []string{FormatHosts, FormatAdblock, FormatDnsmasq, FormatUnbound, FormatDomains} {
+			if name != format {
+				p, _ := newListParser(name)
+				fallbacks = append(fallbacks, p)
+			}
+		}
+	}
+
+	result := &parseResult{Format: format}
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if isCommentLine(line) {
+			continue
+		}
+		result.Lines++
+
+		domains, err := parser.ParseLine(line)
+		for _, fb := range fallbacks {
+			if err == nil {
+				break
+			}
+			domains, err = fb.ParseLine(line)
+		}
+		if err != nil {
+			result.addError(i+1, line, err)
+			continue
+		}
+
+		for _, d := range domains {
+			if d = normalizeDomain(d); d != "" {
+				result.Domains = append(result.Domains, d)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// detectFormat picks the format that reads the most of the first lines
+func detectFormat(lines []string) string {
+	var sample []string
+	for _, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if strings.HasPrefix(line, "$ORIGIN") || strings.HasPrefix(line, "$TTL") {
+			return FormatRPZ
+		}
+		if isCommentLine(line) {
+			continue
+		}
+		sample = append(sample, line)
+		if len(sample) >= detectSampleLines {
+			break
+		}
+	}
+
+	best, bestScore := FormatDomains, 0
+	for _, name := range []string{FormatHosts, FormatAdblock, FormatDnsmasq, FormatUnbound, FormatRPZ, FormatDomains} {
+		parser, _ := newListParser(name)
+		score := 0
+		for _, line := range sample {
+			if domains, err := parser.ParseLine(line); err == nil && len(domains) > 0 {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = name, score
+		}
+	}
+	return best
+}
+
+func isCommentLine(line string) bool {
+	return line == "" ||
+		strings.HasPrefix(line, "#") ||
+		strings.HasPrefix(line, "!") ||
+		strings.HasPrefix(line, ";") ||
+		strings.HasPrefix(line, "[")
+}
+
+// stripInlineComment removes a trailing "# ..." comment
+func stripInlineComment(line string) string {
+	if idx := strings.Index(line, "#"); idx != -1 {
+		line = line[:idx]
+	}
+	return strings.TrimSpace(line)
+}
+
+// ─── Parsers ──────────────────────────────────────────────────────────────────
+
+// hostsParser reads "0.0.0.0 a.com b.com # comment" with any IPv4/IPv6 address
+type hostsParser struct{}
+
+var hostsIgnored = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+func (hostsParser) ParseLine(line string) ([]string, error) {
+	fields := strings.Fields(stripInlineComment(line))
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("not a hosts entry")
+	}
+	if net.ParseIP(fields[0]) == nil {
+		return nil, fmt.Errorf("invalid address %q", fields[0])
+	}
+
+	var domains []string
+	for _, host := range fields[1:] {
+		host, ok := cleanDomain(host)
+		if hostsIgnored[host] {
+			continue
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid hostname %q", host)
+		}
+		domains = append(domains, host)
+	}
+	return domains, nil
+}
+
+// adblockParser reads "||example.com^" network rules. Exceptions and
+// cosmetic rules do not apply to DNS and are ignored.
+type adblockParser struct{}
+
+func (adblockParser) ParseLine(line string) ([]string, error) {
+	if strings.HasPrefix(line, "@@") || strings.Contains(line, "##") || strings.Contains(line, "#@#") {
+		return nil, nil
+	}
+	if !strings.HasPrefix(line, "||") {
+		return nil, fmt.Errorf("not an adblock network rule")
+	}
+
+	domain := strings.TrimPrefix(line, "||")
+	if idx := strings.IndexAny(domain, "^/$?|"); idx != -1 {
+		domain = domain[:idx]
+	}
+	if strings.Contains(domain, "*") {
+		return nil, nil
+	}
+	domain, ok := cleanDomain(domain)
+	if !ok {
+		return nil, fmt.Errorf("invalid domain %q", domain)
+	}
+	return []string{domain}, nil
+}
+
+// domainsParser reads one bare domain per line
+type domainsParser struct{}
+
+func (domainsParser) ParseLine(line string) ([]string, error) {
+	line = stripInlineComment(line)
+	if strings.ContainsAny(line, " \t") {
+		return nil, fmt.Errorf("not a domain")
+	}
+	domain, ok := cleanDomain(line)
+	if !ok {
+		return nil, fmt.Errorf("not a domain")
+	}
+	return []string{domain}, nil
+}
+
+// dnsmasqParser reads address=/a.com/b.com/0.0.0.0, local=/a.com/ and
+// server=/a.com/ directives. Directives that send the domains to a real
+// address or upstream server do not block them and are skipped.
+type dnsmasqParser struct{}
+
+// dnsmasqBlockTargets are the targets of address= and server= that leave a
+// domain unresolved
+var dnsmasqBlockTargets = map[string]bool{
+	"":        true,
+	"0.0.0.0": true,
+	"::":      true,
+	"#":       true,
+}
+
+func (dnsmasqParser) ParseLine(line string) ([]string, error) {
+	line = stripInlineComment(line)
+
+	var rest string
+	switch {
+	case strings.HasPrefix(line, "address=/"):
+		rest = strings.TrimPrefix(line, "address=/")
+	case strings.HasPrefix(line, "local=/"):
+		rest = strings.TrimPrefix(line, "local=/")
+	case strings.HasPrefix(line, "server=/"):
+		rest = strings.TrimPrefix(line, "server=/")
+	default:
+		return nil, fmt.Errorf("not a dnsmasq directive")
+	}
+
+	parts := strings.Split(rest, "/")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("unterminated dnsmasq domain list")
+	}
+	if !dnsmasqBlockTargets[strings.TrimSpace(parts[len(parts)-1])] {
+		return nil, nil
+	}
+
+	var domains []string
+	for _, d := range parts[:len(parts)-1] {
+		if d == "" || d == "#" {
+			continue
+		}
+		d, ok := cleanDomain(d)
+		if !ok {
+			return nil, fmt.Errorf("invalid domain %q", d)
+		}
+		domains = append(domains, d)
+	}
+	return domains, nil
+}
+
+// unboundParser reads local-zone: "a.com" always_nxdomain and
+// local-data: "a.com A 0.0.0.0" lines
+type unboundParser struct{}
+
+func (unboundParser) ParseLine(line string) ([]string, error) {
+	line = stripInlineComment(line)
+
+	var value string
+	switch {
+	case strings.HasPrefix(line, "local-zone:"):
+		value = strings.TrimSpace(strings.TrimPrefix(line, "local-zone:"))
+	case strings.HasPrefix(line, "local-data:"):
+		value = strings.TrimSpace(strings.TrimPrefix(line, "local-data:"))
+	case line == "server:":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("not an unbound directive")
+	}
+
+	value = strings.Trim(value, `"'`)
+	fields := strings.Fields(strings.ReplaceAll(value, `"`, " "))
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing zone name")
+	}
+
+	if len(fields) > 1 && (fields[1] == "transparent" || fields[1] == "typetransparent" || fields[1] == "nodefault") {
+		return nil, nil
+	}
+
+	domain, ok := cleanDomain(fields[0])
+	if !ok {
+		return nil, fmt.Errorf("invalid domain %q", domain)
+	}
+	return []string{domain}, nil
+}
+
+// rpzLineParser reads the trigger names of a Response Policy Zone file,
+// stripping $ORIGIN from absolute names. Passthru entries are skipped.
+type rpzLineParser struct {
+	origin string
+}
+
+func (p *rpzLineParser) ParseLine(line string) ([]string, error) {
+	line = strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	if fields[0] == "$ORIGIN" && len(fields) > 1 {
+		p.origin = strings.ToLower(strings.TrimSuffix(fields[1], "."))
+		return nil, nil
+	}
+	if strings.HasPrefix(fields[0], "$") || line == ")" || strings.HasPrefix(fields[0], "@") {
+		return nil, nil
+	}
+	for _, f := range fields {
+		switch strings.ToUpper(f) {
+		case "SOA", "NS":
+			return nil, nil
+		}
+	}
+
+	// owner [ttl] [class] type rdata
+	var rtype, rdata string
+	for i := 1; i < len(fields); i++ {
+		switch strings.ToUpper(fields[i]) {
+		case "IN":
+			continue
+		case "CNAME", "A", "AAAA", "TXT":
+			rtype = strings.ToUpper(fields[i])
+			if i+1 < len(fields) {
+				rdata = fields[i+1]
+			}
+		}
+		if rtype != "" {
+			break
+		}
+	}
+	if rtype == "" {
+		return nil, fmt.Errorf("not an RPZ record")
+	}
+	if rtype == "CNAME" && strings.EqualFold(rdata, "rpz-passthru.") {
+		return nil, nil
+	}
+
+	owner := strings.ToLower(fields[0])
+	if strings.HasSuffix(owner, ".") {
+		owner = strings.TrimSuffix(owner, ".")
+		if p.origin != "" {
+			owner = strings.TrimSuffix(strings.TrimSuffix(owner, p.origin), ".")
+		}
+	}
+	owner = strings.TrimPrefix(owner, "*.")
+
+	// IP and NSDNAME triggers are not domain names
+	if strings.HasSuffix(owner, ".rpz-ip") || strings.HasSuffix(owner, ".rpz-nsdname") ||
+		strings.HasSuffix(owner, ".rpz-client-ip") || strings.HasSuffix(owner, ".rpz-nsip") {
+		return nil, nil
+	}
+	owner, ok := cleanDomain(owner)
+	if !ok {
+		return nil, fmt.Errorf("invalid trigger %q", owner)
+	}
+	return []string{owner}, nil
+}
+
+// ─── Validation ───────────────────────────────────────────────────────────────
+
+// cleanDomain normalizes a list entry, converting an internationalized name
+// to punycode, and reports whether the result is a valid domain
+func cleanDomain(domain string) (string, bool) {
+	domain = normalizeDomain(domain)
+	return domain, isValidDomain(domain)
+}
+
+// isValidDomain accepts names of at least two labels made of letters,
+// digits, hyphens and underscores
+func isValidDomain(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	if len(domain) == 0 || len(domain) > 253 || !strings.Contains(domain, ".") {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		for _, ch := range label {
+			if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '-' || ch == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
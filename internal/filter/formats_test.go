@@ -0,0 +1,150 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		format, line string
+		want         []string
+		wantErr      bool
+	}{
+		{FormatHosts, "0.0.0.0 ads.example.com tracker.example.com # ads", []string{"ads.example.com", "tracker.example.com"}, false},
+		{FormatHosts, ":: Ads.Example.COM", []string{"ads.example.com"}, false},
+		{FormatHosts, "127.0.0.1 localhost", nil, false},
+		{FormatHosts, "0.0.0.0 bücher.example", []string{"xn--bcher-kva.example"}, false},
+		{FormatHosts, "not-an-ip ads.example.com", nil, true},
+		{FormatHosts, "0.0.0.0 bad..name.com", nil, true},
+		{FormatAdblock, "||ads.example.com^", []string{"ads.example.com"}, false},
+		{FormatAdblock, "||ads.example.com^$third-party", []string{"ads.example.com"}, false},
+		{FormatAdblock, "||пример.рф^", []string{"xn--e1afmkfd.xn--p1ai"}, false},
+		{FormatAdblock, "@@||ok.example.com^", nil, false},
+		{FormatAdblock, "example.com##.banner", nil, false},
+		{FormatAdblock, "||ads*.example.com^", nil, false},
+		{FormatAdblock, "/banner/", nil, true},
+		{FormatDomains, "ads.example.com # comment", []string{"ads.example.com"}, false},
+		{FormatDomains, "münchen.de", []string{"xn--mnchen-3ya.de"}, false},
+		{FormatDomains, "localhost", nil, true},
+		{FormatDomains, "two words.com", nil, true},
+		{FormatDnsmasq, "address=/a.example.com/b.example.com/0.0.0.0", []string{"a.example.com", "b.example.com"}, false},
+		{FormatDnsmasq, "local=/c.example.com/", []string{"c.example.com"}, false},
+		{FormatDnsmasq, "address=/a.example.com", nil, true},
+		{FormatDnsmasq, "address=/d.example.com/::", []string{"d.example.com"}, false},
+		{FormatDnsmasq, "server=/e.example.com/", []string{"e.example.com"}, false},
+		{FormatDnsmasq, "server=/f.example.com/#", []string{"f.example.com"}, false},
+		{FormatDnsmasq, "address=/router.lan/192.168.1.1", nil, false},
+		{FormatDnsmasq, "server=/corp.example.com/10.0.0.53", nil, false},
+		{FormatUnbound, `local-zone: "ads.example.com." always_nxdomain`, []string{"ads.example.com"}, false},
+		{FormatUnbound, `local-data: "ads.example.com A 0.0.0.0"`, []string{"ads.example.com"}, false},
+		{FormatUnbound, `local-zone: "ok.example.com" transparent`, nil, false},
+		{FormatUnbound, "server:", nil, false},
+	}
+
+	for _, tt := range tests {
+		parser, ok := newListParser(tt.format)
+		if !ok {
+			t.Fatalf("no parser for %s", tt.format)
+		}
+		got, err := parser.ParseLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %q: err = %v, want error %v", tt.format, tt.line, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %q = %v, want %v", tt.format, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestRPZLineParser(t *testing.T) {
+	p := &rpzLineParser{}
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"$ORIGIN rpz.example.", nil},
+		{"@ SOA ns.example. admin.example. 1 3600 600 86400 60", nil},
+		{"bad.com CNAME .", []string{"bad.com"}},
+		{"*.bad.com CNAME .", []string{"bad.com"}},
+		{"ok.com CNAME rpz-passthru.", nil},
+		{"evil.org.rpz.example. 300 IN CNAME .", []string{"evil.org"}},
+		{"32.1.2.0.192.rpz-ip CNAME .", nil},
+		{"redirect.com A 10.0.0.1", []string{"redirect.com"}},
+	}
+	for _, tt := range tests {
+		got, err := p.ParseLine(tt.line)
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseBlocklist(t *testing.T) {
+	tests := []struct {
+		name, list, format string
+		wantFormat         string
+		wantDomains        []string
+		wantErrors         int
+	}{
+		{
+			name:        "hosts detected",
+			list:        "# header\n0.0.0.0 a.example.com\n0.0.0.0 b.example.com\n",
+			wantFormat:  FormatHosts,
+			wantDomains: []string{"a.example.com", "b.example.com"},
+		},
+		{
+			name:        "mixed list falls back per line",
+			list:        "||a.example.com^\n||b.example.com^\n0.0.0.0 c.example.com\nd.example.com\n",
+			wantFormat:  FormatAdblock,
+			wantDomains: []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com"},
+		},
+		{
+			name:        "internationalized names are not errors",
+			list:        "bücher.example\nmünchen.de\n",
+			wantFormat:  FormatDomains,
+			wantDomains: []string{"xn--bcher-kva.example", "xn--mnchen-3ya.de"},
+		},
+		{
+			name:        "explicit format does not fall back",
+			list:        "a.example.com\n0.0.0.0 b.example.com\n",
+			format:      FormatDomains,
+			wantFormat:  FormatDomains,
+			wantDomains: []string{"a.example.com"},
+			wantErrors:  1,
+		},
+		{
+			name:        "rpz detected from origin",
+			list:        "$ORIGIN rpz.example.\nbad.com CNAME .\n",
+			wantFormat:  FormatRPZ,
+			wantDomains: []string{"bad.com"},
+		},
+	}
+
+	for _, tt := range tests {
+		result, err := parseBlocklist(strings.NewReader(tt.list), tt.format)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if result.Format != tt.wantFormat {
+			t.Errorf("%s: format = %s, want %s", tt.name, result.Format, tt.wantFormat)
+		}
+		if !reflect.DeepEqual(result.Domains, tt.wantDomains) {
+			t.Errorf("%s: domains = %v, want %v", tt.name, result.Domains, tt.wantDomains)
+		}
+		if result.ParseErrors != tt.wantErrors {
+			t.Errorf("%s: %d parse errors, want %d (%v)", tt.name, result.ParseErrors, tt.wantErrors, result.ErrorSamples)
+		}
+	}
+
+	if _, err := parseBlocklist(strings.NewReader("a.com\n"), "nope"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
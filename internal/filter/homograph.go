@@ -0,0 +1,149 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ─── Homograph Detection ──────────────────────────────────────────────────────
+//
+// A homograph is a name spelled with characters that look like those of a
+// well-known one, such as pаypal.com with a Cyrillic а. Every name is reduced
+// to a skeleton in the spirit of UTS #39: accents are dropped and each
+// confusable character is replaced by the ASCII letters it looks like. A name
+// whose skeleton is that of a protected brand, or of a subdomain of it, but
+// which is not the brand itself, is flagged or blocked as homograph:<brand>.
+
+const (
+	HomographBlock = "block"
+	HomographFlag  = "flag"
+)
+
+// confusables maps characters to the ASCII letters they are mistaken for
+var confusables = map[rune]string{
+	// Cyrillic
+	'а': "a", 'в': "b", 'е': "e", 'ё': "e", 'о': "o", 'р': "p", 'с': "c",
+	'у': "y", 'х': "x", 'і': "i", 'ї': "i", 'ј': "j", 'ѕ': "s", 'һ': "h",
+	'ԁ': "d", 'ԛ': "q", 'ԝ': "w", 'ӏ': "l", 'к': "k", 'м': "m", 'н': "h",
+	'т': "t", 'п': "n", 'г': "r", 'ү': "y",
+	// Greek
+	'α': "a", 'β': "b", 'ε': "e", 'η': "n", 'ι': "i", 'κ': "k", 'ν': "v",
+	'ο': "o", 'ρ': "p", 'τ': "t", 'υ': "u", 'χ': "x", 'ω': "w", 'ϲ': "c",
+	'ϳ': "j",
+	// Armenian
+	'օ': "o", 'ս': "u", 'հ': "h", 'ո': "n", 'զ': "q", 'ց': "g",
+	// Latin lookalikes
+	'ı': "i", 'ȷ': "j", 'ɑ': "a", 'ɡ': "g", 'ɩ': "i", 'ℓ': "l", 'ƅ': "b", 'ɵ': "o",
+	'ø': "o", 'đ': "d", 'ħ': "h", 'ł': "l", 'ß': "ss", 'æ': "ae", 'œ': "oe",
+	// Digits
+	'0': "o", '1': "l",
+}
+
+// asciiConfusables are letter groups read as another letter
+var asciiConfusables = strings.NewReplacer("rn", "m", "vv", "w")
+
+// skeleton reduces a name to the letters it appears to be made of
+func skeleton(domain string) string {
+	if unicodeName, err := idna.ToUnicode(domain); err == nil {
+		domain = unicodeName
+	}
+
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(domain)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if s, ok := confusables[r]; ok {
+			b.WriteString(s)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return asciiConfusables.Replace(b.String())
+}
+
+// loadHomograph computes the skeletons of the protected brands
+func (e *Engine) loadHomograph() error {
+	cfg := e.cfg.Filtering.Homograph
+	switch cfg.Action {
+	case "", HomographBlock, HomographFlag:
+	default:
+		return fmt.Errorf("homograph: unknown action %q (expected block or flag)", cfg.Action)
+	}
+
+	brands := make(map[string]string)
+	for _, brand := range cfg.Brands {
+		if brand = normalizeDomain(brand); brand != "" {
+			brands[skeleton(brand)] = brand
+		}
+	}
+
+	e.mu.Lock()
+	e.homographBrands = brands
+	e.mu.Unlock()
+	return nil
+}
+
+// matchHomograph returns the brand domain imitates, or ""
+func (e *Engine) matchHomograph(domain string) string {
+	if !e.cfg.Filtering.Homograph.Enabled {
+		return ""
+	}
+
+	e.mu.RLock()
+	brands := e.homographBrands
+	e.mu.RUnlock()
+	if len(brands) == 0 {
+		return ""
+	}
+
+	skel := skeleton(domain)
+	for name := skel; ; {
+		if brand, ok := brands[name]; ok {
+			// The brand itself and its real subdomains are not homographs
+			if domain == brand || strings.HasSuffix(domain, "."+brand) {
+				return ""
+			}
+			return brand
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return ""
+		}
+		name = name[i+1:]
+	}
+}
+
+// homographStage blocks, or only logs, names that imitate a protected brand
+func (e *Engine) homographStage(q *Query) Result {
+	brand := e.matchHomograph(q.Domain)
+	if brand == "" {
+		return Continue
+	}
+	if e.cfg.Filtering.Homograph.Action == HomographFlag {
+		if q.DryRun {
+			return Continue
+		}
+		e.log.Warnf("🎭 HOMOGRAPH: %s from %s looks like %s", q.Domain, q.ClientIP, brand)
+		return Continue
+	}
+	return Result{Verdict: VerdictBlock, Reason: "homograph:" + brand}
+}
+
+func (e *Engine) explainHomograph(x *explainer, q *Query) {
+	brand := e.matchHomograph(q.Domain)
+	if brand == "" {
+		x.miss("homograph", "")
+		return
+	}
+	step := TraceStep{Stage: "homograph", Effect: EffectBlock, Rule: brand, Detail: "skeleton " + skeleton(q.Domain)}
+	if e.cfg.Filtering.Homograph.Action == HomographFlag {
+		step.Effect = EffectPassthru
+		step.Detail += ", only flagged"
+	}
+	x.hit(step, "homograph:"+brand)
+}
@@ -0,0 +1,78 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+)
+
+func homographConfig(action string, brands ...string) *config.Config {
+	cfg := &config.Config{}
+	cfg.Filtering.Homograph = config.HomographConfig{Enabled: true, Action: action, Brands: brands}
+	return cfg
+}
+
+func TestSkeleton(t *testing.T) {
+	tests := []struct{ domain, want string }{
+		{"paypal.com", "paypal.com"},
+		{"pаypal.com", "paypal.com"}, // Cyrillic а
+		{"xn--pypal-4ve.com", "paypal.com"},
+		{"paypa1.com", "paypal.com"},
+		{"rnicrosoft.com", "microsoft.com"},
+		{"gοοgle.com", "google.com"}, // Greek ο
+		{"café.com", "cafe.com"},
+	}
+	for _, tt := range tests {
+		if got := skeleton(tt.domain); got != tt.want {
+			t.Errorf("skeleton(%s) = %s, want %s", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestMatchHomograph(t *testing.T) {
+	e := newTestEngine(t, homographConfig(HomographBlock, "PayPal.com", "microsoft.com"))
+
+	tests := []struct {
+		name, domain, want string
+	}{
+		{"cyrillic lookalike", "pаypal.com", "paypal.com"},
+		{"digit for a letter", "paypa1.com", "paypal.com"},
+		{"rn for m", "rnicrosoft.com", "microsoft.com"},
+		{"lookalike subdomain", "login.paypa1.com", "paypal.com"},
+		{"lookalike of a subdomain", "www.paypa1.com", "paypal.com"},
+		{"the brand itself", "paypal.com", ""},
+		{"a real subdomain", "www.paypal.com", ""},
+		{"unrelated", "example.com", ""},
+	}
+	for _, tt := range tests {
+		if got := e.matchHomograph(normalizeDomain(tt.domain)); got != tt.want {
+			t.Errorf("%s: matchHomograph(%s) = %q, want %q", tt.name, tt.domain, got, tt.want)
+		}
+	}
+
+	e.cfg.Filtering.Homograph.Enabled = false
+	if got := e.matchHomograph("paypa1.com"); got != "" {
+		t.Errorf("matchHomograph = %q while disabled", got)
+	}
+}
+
+func TestHomographStage(t *testing.T) {
+	tests := []struct {
+		action string
+		want   Verdict
+	}{
+		{"", VerdictBlock},
+		{HomographBlock, VerdictBlock},
+		{HomographFlag, VerdictContinue},
+	}
+	for _, tt := range tests {
+		e := newTestEngine(t, homographConfig(tt.action, "paypal.com"))
+		got := e.homographStage(&Query{Domain: "paypa1.com", ClientIP: "10.0.0.5"})
+		if got.Verdict != tt.want {
+			t.Errorf("action %q: verdict %v, want %v", tt.action, got.Verdict, tt.want)
+		}
+		if got.Verdict == VerdictBlock && got.Reason != "homograph:paypal.com" {
+			t.Errorf("action %q: reason %q", tt.action, got.Reason)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package filter
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// ─── Newly Seen Domains ───────────────────────────────────────────────────────
+//
+// Phishing and malware sites are almost always brand new. The engine records
+// when each registrable domain was first queried on the network, keeps the
+// times in memory as Unix seconds and stores new ones in the database once a
+// minute. Domains first seen less than some hours ago, or listed by a local
+// feed of newly registered domains, can be blocked or only logged. Nothing is
+// acted on until the store has been learning for a while, or every domain
+// would look new on a fresh install.
+
+const (
+	NewDomainBlock = "block"
+	NewDomainWarn  = "warn"
+
+	NewDomainMatchEither = "either"
+	NewDomainMatchBoth   = "both"
+
+	maxFirstSeen      = 2000000 // registrable domains remembered
+	newDomainListSize = 200
+)
+
+// newDomainState is the first-seen store, under its own lock because every
+// query updates it
+type newDomainState struct {
+	mu      sync.Mutex
+	seen    map[string]int64 // registrable domain -> first seen, Unix seconds
+	pending map[string]int64 // first seen since the last flush
+	since   time.Time        // when recording started
+	warned  map[string]int64 // warned about, Unix seconds; pruned after the age window
+
+	feed     map[string]bool
+	feedTime time.Time // modification time of the loaded feed
+}
+
+func newNewDomainState() *newDomainState {
+	return &newDomainState{
+		seen:    make(map[string]int64),
+		pending: make(map[string]int64),
+		warned:  make(map[string]int64),
+	}
+}
+
+// NewDomain is a domain first seen recently
+type NewDomain struct {
+	Domain    string    `json:"domain"`
+	FirstSeen time.Time `json:"first_seen"`
+	Listed    bool      `json:"listed"` // in the newly registered domains feed
+}
+
+// NewDomainsStatus describes the first-seen store
+type NewDomainsStatus struct {
+	Enabled       bool        `json:"enabled"`
+	Tracked       int         `json:"tracked"`
+	FeedDomains   int         `json:"feed_domains"`
+	TrackingSince time.Time   `json:"tracking_since"`
+	ActiveFrom    time.Time   `json:"active_from"` // the end of the learning period
+	Recent        []NewDomain `json:"recent"`
+}
+
+// registrableDomain returns the part of a name its owner registered, or the
+// name itself when it has no public suffix
+func registrableDomain(domain string) string {
+	if parent, err := publicsuffix.EffectiveTLDPlusOne(domain); err == nil {
+		return parent
+	}
+	return domain
+}
+
+func (e *Engine) newDomainHours() (age, learn time.Duration) {
+	cfg := e.cfg.Filtering.NewDomains
+	hours := cfg.Hours
+	if hours <= 0 {
+		hours = 24
+	}
+	learnHours := cfg.LearnHours
+	if learnHours <= 0 {
+		learnHours = hours
+	}
+	return time.Duration(hours) * time.Hour, time.Duration(learnHours) * time.Hour
+}
+
+// loadNewDomains reads the first-seen store and the feed
+func (e *Engine) loadNewDomains() error {
+	cfg := e.cfg.Filtering.NewDomains
+	switch cfg.Action {
+	case "", NewDomainBlock, NewDomainWarn:
+	default:
+		return fmt.Errorf("new_domains: unknown action %q (expected block or warn)", cfg.Action)
+	}
+	switch cfg.Match {
+	case "", NewDomainMatchEither, NewDomainMatchBoth:
+	default:
+		return fmt.Errorf("new_domains: unknown match %q (expected either or both)", cfg.Match)
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+
+	seen, err := e.db.LoadFirstSeen()
+	if err != nil {
+		return err
+	}
+	since := time.Now()
+	for _, at := range seen {
+		if t := time.Unix(at, 0); t.Before(since) {
+			since = t
+		}
+	}
+
+	n := e.newDomains
+	n.mu.Lock()
+	n.seen = seen
+	n.since = since
+	n.mu.Unlock()
+
+	e.log.Infof("✓ First-seen store: %d domains, recording since %s", len(seen), since.Format("2006-01-02 15:04"))
+	e.reloadNewDomainFeed()
+	return nil
+}
+
+// reloadNewDomainFeed reads the feed file again when it changed
+func (e *Engine) reloadNewDomainFeed() {
+	path := e.cfg.Filtering.NewDomains.Feed
+	if !e.cfg.Filtering.NewDomains.Enabled || path == "" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		e.log.Warnf("Newly registered domains feed: %v", err)
+		return
+	}
+
+	n := e.newDomains
+	n.mu.Lock()
+	unchanged := info.ModTime().Equal(n.feedTime)
+	n.mu.Unlock()
+	if unchanged {
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		e.log.Warnf("Newly registered domains feed: %v", err)
+		return
+	}
+	defer f.Close()
+
+	result, err := parseBlocklist(f, "auto")
+	if err != nil {
+		e.log.Warnf("Newly registered domains feed: %v", err)
+		return
+	}
+	feed := make(map[string]bool, len(result.Domains))
+	for _, domain := range result.Domains {
+		feed[domain] = true
+	}
+
+	n.mu.Lock()
+	n.feed = feed
+	n.feedTime = info.ModTime()
+	n.mu.Unlock()
+
+	e.log.Infof("Loaded %d newly registered domains from %s", len(feed), path)
+}
+
+// observeFirstSeen records the first query for the registrable domain of
+// domain
+func (e *Engine) observeFirstSeen(domain string) {
+	if !e.cfg.Filtering.NewDomains.Enabled || domain == "" {
+		return
+	}
+	name := registrableDomain(domain)
+
+	n := e.newDomains
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if _, ok := n.seen[name]; ok || len(n.seen) >= maxFirstSeen {
+		return
+	}
+	now := time.Now().Unix()
+	n.seen[name] = now
+	n.pending[name] = now
+}
+
+// flushFirstSeen stores the domains first seen since the last flush and
+// forgets the warnings older than the age window
+func (e *Engine) flushFirstSeen() {
+	age, _ := e.newDomainHours()
+	cutoff := time.Now().Add(-age).Unix()
+
+	n := e.newDomains
+	n.mu.Lock()
+	pending := n.pending
+	n.pending = make(map[string]int64)
+	for name, at := range n.warned {
+		if at < cutoff {
+			delete(n.warned, name)
+		}
+	}
+	n.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	if err := e.db.AddFirstSeen(pending); err != nil {
+		e.log.Warnf("Failed to save first-seen domains: %v", err)
+	}
+}
+
+// checkNewDomain returns the reason domain counts as new, or ""
+func (e *Engine) checkNewDomain(domain string) (reason string, firstSeen time.Time) {
+	cfg := e.cfg.Filtering.NewDomains
+	if !cfg.Enabled {
+		return "", time.Time{}
+	}
+	age, learn := e.newDomainHours()
+	name := registrableDomain(domain)
+	now := time.Now()
+
+	n := e.newDomains
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	recent := false
+	if at, ok := n.seen[name]; ok {
+		firstSeen = time.Unix(at, 0)
+		recent = now.Sub(n.since) >= learn && now.Sub(firstSeen) < age
+	}
+	listed := false
+	for candidate := domain; ; {
+		if n.feed[candidate] {
+			listed = true
+			break
+		}
+		i := strings.Index(candidate, ".")
+		if i < 0 {
+			break
+		}
+		candidate = candidate[i+1:]
+	}
+
+	switch {
+	case cfg.Match == NewDomainMatchBoth && !(recent && listed):
+		return "", firstSeen
+	case listed:
+		return "new-domain:registered", firstSeen
+	case recent:
+		return "new-domain:seen", firstSeen
+	}
+	return "", firstSeen
+}
+
+// newDomainStage blocks, or only logs, domains that are new
+func (e *Engine) newDomainStage(q *Query) Result {
+	reason, firstSeen := e.checkNewDomain(q.Domain)
+	if reason == "" {
+		return Continue
+	}
+	if e.cfg.Filtering.NewDomains.Action != NewDomainWarn {
+		return Result{Verdict: VerdictBlock, Reason: reason}
+	}
+	if q.DryRun {
+		return Continue
+	}
+
+	name := registrableDomain(q.Domain)
+	n := e.newDomains
+	n.mu.Lock()
+	_, warned := n.warned[name]
+	if !warned {
+		n.warned[name] = time.Now().Unix()
+	}
+	n.mu.Unlock()
+	if !warned {
+		e.log.Warnf("🆕 NEW DOMAIN: %s from %s, first seen %s (%s)", q.Domain, q.ClientIP, firstSeen.Format("2006-01-02 15:04"), reason)
+	}
+	return Continue
+}
+
+func (e *Engine) explainNewDomain(x *explainer, q *Query) {
+	reason, firstSeen := e.checkNewDomain(q.Domain)
+	detail := ""
+	if !firstSeen.IsZero() {
+		detail = "first seen " + firstSeen.Format(time.RFC3339)
+	}
+	if reason == "" {
+		x.miss("new-domain", detail)
+		return
+	}
+	step := TraceStep{Stage: "new-domain", Effect: EffectBlock, Rule: registrableDomain(q.Domain), Detail: detail}
+	if reason == "new-domain:registered" {
+		step.Source = e.cfg.Filtering.NewDomains.Feed
+	}
+	if e.cfg.Filtering.NewDomains.Action == NewDomainWarn {
+		step.Effect = EffectPassthru
+	}
+	x.hit(step, reason)
+}
+
+// GetNewDomains describes the store and lists the domains first seen in the
+// last hours, newest first
+func (e *Engine) GetNewDomains(hours int) NewDomainsStatus {
+	age, learn := e.newDomainHours()
+	if hours > 0 {
+		age = time.Duration(hours) * time.Hour
+	}
+	cutoff := time.Now().Add(-age).Unix()
+
+	n := e.newDomains
+	n.mu.Lock()
+	status := NewDomainsStatus{
+		Enabled:     e.cfg.Filtering.NewDomains.Enabled,
+		Tracked:     len(n.seen),
+		FeedDomains: len(n.feed),
+	}
+	if !n.since.IsZero() {
+		status.TrackingSince = n.since
+		status.ActiveFrom = n.since.Add(learn)
+	}
+	for name, at := range n.seen {
+		if at >= cutoff {
+			status.Recent = append(status.Recent, NewDomain{Domain: name, FirstSeen: time.Unix(at, 0), Listed: n.feed[name]})
+		}
+	}
+	n.mu.Unlock()
+
+	sort.Slice(status.Recent, func(i, j int) bool { return status.Recent[i].FirstSeen.After(status.Recent[j].FirstSeen) })
+	if len(status.Recent) > newDomainListSize {
+		status.Recent = status.Recent[:newDomainListSize]
+	}
+	return status
+}
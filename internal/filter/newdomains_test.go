@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+)
+
+func newDomainsConfig(nd config.NewDomainsConfig) *config.Config {
+	cfg := &config.Config{}
+	cfg.Filtering.NewDomains = nd
+	return cfg
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := []struct{ domain, want string }{
+		{"www.example.com", "example.com"},
+		{"a.b.example.co.uk", "example.co.uk"},
+		{"example.com", "example.com"},
+		{"localhost", "localhost"},
+	}
+	for _, tt := range tests {
+		if got := registrableDomain(tt.domain); got != tt.want {
+			t.Errorf("registrableDomain(%s) = %s, want %s", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestCheckNewDomain(t *testing.T) {
+	tests := []struct {
+		name      string
+		match     string
+		learning  time.Duration // how long the store has been recording
+		firstSeen time.Duration // how long ago the domain was first seen, 0 for never
+		domain    string
+		want      string
+	}{
+		{"seen recently", "", 48 * time.Hour, time.Hour, "www.fresh.com", "new-domain:seen"},
+		{"seen long ago", "", 48 * time.Hour, 30 * time.Hour, "www.fresh.com", ""},
+		{"still learning", "", time.Hour, time.Hour, "www.fresh.com", ""},
+		{"never seen", "", 48 * time.Hour, 0, "www.fresh.com", ""},
+		{"listed", "", 48 * time.Hour, 0, "phish.example", "new-domain:registered"},
+		{"listed parent", "", 48 * time.Hour, 0, "login.phish.example", "new-domain:registered"},
+		{"listed while learning", "", time.Hour, 0, "phish.example", "new-domain:registered"},
+		{"both, only seen", NewDomainMatchBoth, 48 * time.Hour, time.Hour, "www.fresh.com", ""},
+		{"both, only listed", NewDomainMatchBoth, 48 * time.Hour, 30 * time.Hour, "phish.example", ""},
+		{"both, seen and listed", NewDomainMatchBoth, 48 * time.Hour, time.Hour, "phish.example", "new-domain:registered"},
+	}
+	for _, tt := range tests {
+		e := newTestEngine(t, newDomainsConfig(config.NewDomainsConfig{Enabled: true, Hours: 24, Match: tt.match}))
+		now := time.Now()
+		n := e.newDomains
+		n.since = now.Add(-tt.learning)
+		n.feed = map[string]bool{"phish.example": true}
+		if tt.firstSeen > 0 {
+			n.seen[registrableDomain(tt.domain)] = now.Add(-tt.firstSeen).Unix()
+		}
+
+		if got, _ := e.checkNewDomain(tt.domain); got != tt.want {
+			t.Errorf("%s: checkNewDomain(%s) = %q, want %q", tt.name, tt.domain, got, tt.want)
+		}
+	}
+
+	e := newTestEngine(t, newDomainsConfig(config.NewDomainsConfig{Hours: 24}))
+	e.newDomains.seen["fresh.com"] = time.Now().Unix()
+	if got, _ := e.checkNewDomain("fresh.com"); got != "" {
+		t.Errorf("checkNewDomain = %q while disabled", got)
+	}
+}
+
+func TestNewDomainStage(t *testing.T) {
+	tests := []struct {
+		action string
+		want   Verdict
+	}{
+		{"", VerdictBlock},
+		{NewDomainBlock, VerdictBlock},
+		{NewDomainWarn, VerdictContinue},
+	}
+	for _, tt := range tests {
+		e := newTestEngine(t, newDomainsConfig(config.NewDomainsConfig{Enabled: true, Action: tt.action}))
+		e.newDomains.since = time.Now().Add(-48 * time.Hour)
+		e.newDomains.seen["fresh.com"] = time.Now().Unix()
+
+		if got := e.newDomainStage(&Query{Domain: "www.fresh.com"}); got.Verdict != tt.want {
+			t.Errorf("action %q: verdict %v, want %v", tt.action, got.Verdict, tt.want)
+		}
+	}
+}
+
+func TestNewDomainWarningsArePruned(t *testing.T) {
+	e := newTestEngine(t, newDomainsConfig(config.NewDomainsConfig{Enabled: true, Action: NewDomainWarn, Hours: 24}))
+	n := e.newDomains
+	n.since = time.Now().Add(-48 * time.Hour)
+	n.seen["fresh.com"] = time.Now().Unix()
+
+	e.newDomainStage(&Query{Domain: "www.fresh.com"})
+	e.newDomainStage(&Query{Domain: "mail.fresh.com"})
+	if len(n.warned) != 1 {
+		t.Fatalf("warned = %v, want one entry", n.warned)
+	}
+
+	n.warned["old.com"] = time.Now().Add(-25 * time.Hour).Unix()
+	e.flushFirstSeen()
+	if _, ok := n.warned["old.com"]; ok {
+		t.Errorf("a warning older than the age window was kept")
+	}
+	if _, ok := n.warned["fresh.com"]; !ok {
+		t.Errorf("a recent warning was pruned")
+	}
+}
@@ -0,0 +1,116 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+)
+
+// ─── Outbound Connections ─────────────────────────────────────────────────────
+//
+// Blocklists, checksum files, signatures and zone transfers are fetched with
+// their own resolver, so downloads never depend on the system resolver (which
+// may point back at this server), and optionally through a proxy.
+
+const defaultBootstrapDNS = "8.8.8.8:53"
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// newOutbound builds the HTTP client and the dialer used for all fetches
+func newOutbound(cfg *config.Config) (*http.Client, dialFunc, error) {
+	resolver := bootstrapResolver(bootstrapServers(cfg))
+	direct := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Resolver:  resolver,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = direct.DialContext
+	dial := direct.DialContext
+
+	out := cfg.Outbound
+	if out.Proxy != "" {
+		u, err := url.Parse(out.Proxy)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid proxy %q: %v", out.Proxy, err)
+		}
+
+		switch u.Scheme {
+		case "http", "https":
+			proxyCfg := httpproxy.Config{
+				HTTPProxy:  out.Proxy,
+				HTTPSProxy: out.Proxy,
+				NoProxy:    strings.Join(out.NoProxy, ","),
+			}
+			proxyFor := proxyCfg.ProxyFunc()
+			transport.Proxy = func(req *http.Request) (*url.URL, error) {
+				return proxyFor(req.URL)
+			}
+		case "socks5", "socks5h":
+			socks, err := proxy.FromURL(u, direct)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid proxy %q: %v", out.Proxy, err)
+			}
+			perHost := proxy.NewPerHost(socks, direct)
+			perHost.AddFromString(strings.Join(out.NoProxy, ","))
+
+			transport.Proxy = nil
+			transport.DialContext = perHost.DialContext
+			dial = perHost.DialContext
+		default:
+			return nil, nil, fmt.Errorf("unsupported proxy scheme %q (use http, https or socks5)", u.Scheme)
+		}
+	}
+
+	client := &http.Client{
+		Timeout:   60 * time.Second,
+		Transport: transport,
+	}
+	return client, dial, nil
+}
+
+// bootstrapServers returns the resolvers for outbound fetches: bootstrap_dns,
+// else the upstream servers, else Google DNS
+func bootstrapServers(cfg *config.Config) []string {
+	servers := cfg.Outbound.BootstrapDNS
+	if len(servers) == 0 {
+		servers = cfg.Server.UpstreamDNS
+	}
+	if len(servers) == 0 {
+		return []string{defaultBootstrapDNS}
+	}
+
+	addrs := make([]string, 0, len(servers))
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		addrs = append(addrs, server)
+	}
+	return addrs
+}
+
+// bootstrapResolver rotates through servers, so a retry after a timeout goes
+// to the next one
+func bootstrapResolver(servers []string) *net.Resolver {
+	var next uint32
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			server := servers[(atomic.AddUint32(&next, 1)-1)%uint32(len(servers))]
+			d := net.Dialer{Timeout: 10 * time.Second}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
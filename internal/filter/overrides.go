@@ -0,0 +1,302 @@
+package filter
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/database"
+)
+
+// ─── Temporary Overrides ──────────────────────────────────────────────────────
+//
+// An override allows or blocks a domain and its subdomains until it expires,
+// for every client or only for one IP or CIDR. Overrides win over every other
+// rule, are stored in the database so they survive restarts, and are dropped
+// by a background loop once they expire.
+
+const (
+	OverrideAllow = "allow"
+	OverrideBlock = "block"
+
+	expiryCheck = 10 * time.Second // how often expired overrides and pauses are removed
+)
+
+// Override is a temporary allow or block
+type Override struct {
+	ID        int64     `json:"id"`
+	Domain    string    `json:"domain"`
+	Action    string    `json:"action"`
+	Client    string    `json:"client,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+
+	network *net.IPNet
+}
+
+func (o *Override) appliesTo(ip net.IP, now time.Time) bool {
+	if !now.Before(o.ExpiresAt) {
+		return false
+	}
+	return o.network == nil || (ip != nil && o.network.Contains(ip))
+}
+
+// beats reports whether o takes precedence over other for the same name
+func (o *Override) beats(other *Override) bool {
+	if (o.network != nil) != (other.network != nil) {
+		return o.network != nil
+	}
+	return o.ID > other.ID
+}
+
+func newOverride(rec database.Override) (*Override, error) {
+	o := &Override{
+		ID:        rec.ID,
+		Domain:    rec.Domain,
+		Action:    rec.Action,
+		Client:    rec.Client,
+		Note:      rec.Note,
+		ExpiresAt: rec.ExpiresAt,
+		CreatedAt: rec.CreatedAt,
+	}
+	if o.Client != "" {
+		network, err := parseClientNetwork(o.Client)
+		if err != nil {
+			return nil, err
+		}
+		o.network = network
+	}
+	return o, nil
+}
+
+// loadOverrides reads the stored overrides, dropping those that expired
+// while the server was down
+func (e *Engine) loadOverrides() error {
+	records, err := e.db.LoadOverrides()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	overrides := make(map[string][]*Override)
+	for _, rec := range records {
+		o, err := newOverride(rec)
+		if err != nil || !now.Before(o.ExpiresAt) {
+			if err := e.db.DeleteOverride(rec.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		overrides[o.Domain] = append(overrides[o.Domain], o)
+	}
+
+	e.mu.Lock()
+	e.overrides = overrides
+	e.mu.Unlock()
+	return nil
+}
+
+// AddOverride allows or blocks domain for duration, for every client or only
+// for client (an IP or CIDR)
+func (e *Engine) AddOverride(domain, action, client string, duration time.Duration, note string) (*Override, error) {
+	domain = normalizeDomain(domain)
+	if domain == "" {
+		return nil, fmt.Errorf("domain is required")
+	}
+	if action != OverrideAllow && action != OverrideBlock {
+		return nil, fmt.Errorf("unknown action %q (expected allow or block)", action)
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+
+	rec := database.Override{
+		Domain:    domain,
+		Action:    action,
+		Client:    strings.TrimSpace(client),
+		Note:      note,
+		ExpiresAt: time.Now().Add(duration),
+		CreatedAt: time.Now(),
+	}
+	o, err := newOverride(rec)
+	if err != nil {
+		return nil, err
+	}
+	if o.ID, err = e.db.AddOverride(rec); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.overrides[domain] = append(e.overrides[domain], o)
+	e.mu.Unlock()
+
+	e.log.Infof("Temporary %s of %s until %s", action, domain, o.ExpiresAt.Format("15:04:05"))
+	e.notifyCustomChange([]string{domain})
+	return o, nil
+}
+
+// RemoveOverride revokes an override before it expires
+func (e *Engine) RemoveOverride(id int64) error {
+	if err := e.db.DeleteOverride(id); err != nil {
+		return err
+	}
+
+	domain := ""
+	e.mu.Lock()
+	for name, list := range e.overrides {
+		for i, o := range list {
+			if o.ID == id {
+				domain = name
+				e.overrides[name] = append(list[:i:i], list[i+1:]...)
+				break
+			}
+		}
+		if domain != "" {
+			if len(e.overrides[name]) == 0 {
+				delete(e.overrides, name)
+			}
+			break
+		}
+	}
+	e.mu.Unlock()
+
+	if domain == "" {
+		return fmt.Errorf("override %d not found", id)
+	}
+	e.notifyCustomChange([]string{domain})
+	return nil
+}
+
+// GetOverrides lists the active overrides, soonest to expire first
+func (e *Engine) GetOverrides() []Override {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	now := time.Now()
+	var list []Override
+	for _, overrides := range e.overrides {
+		for _, o := range overrides {
+			if now.Before(o.ExpiresAt) {
+				list = append(list, *o)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ExpiresAt.Before(list[j].ExpiresAt) })
+	return list
+}
+
+// OverrideFor returns the override that applies to domain for clientIP, or
+// nil. The DNS server skips rewrites and policy zones for such names, so the
+// override stage decides them.
+func (e *Engine) OverrideFor(domain, clientIP string) *Override {
+	if !e.cfg.Filtering.Enabled || !e.stageNameEnabled("override") {
+		return nil
+	}
+	return e.matchOverride(normalizeDomain(domain), clientIP)
+}
+
+// matchOverride returns the active override that applies to clientIP,
+// checking domain before its parents
+func (e *Engine) matchOverride(domain, clientIP string) *Override {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.overrides) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	ip := net.ParseIP(clientIP)
+	for name := domain; ; {
+		var match *Override
+		for _, o := range e.overrides[name] {
+			if !o.appliesTo(ip, now) {
+				continue
+			}
+			if match == nil || o.beats(match) {
+				match = o
+			}
+		}
+		if match != nil {
+			return match
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return nil
+		}
+		name = name[i+1:]
+	}
+}
+
+// hasOverride reports whether domain or a parent has an override
+func (e *Engine) hasOverride(domain string) bool {
+	if len(e.overrides) == 0 {
+		return false
+	}
+	for name := domain; ; {
+		if len(e.overrides[name]) > 0 {
+			return true
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return false
+		}
+		name = name[i+1:]
+	}
+}
+
+// expireLoop removes expired overrides, telling the change handlers which
+// names they covered, ended pauses and expired tunnel blocks, and reloads
+// the new-domain feed when the file changed
+func (e *Engine) expireLoop() {
+	ticker := time.NewTicker(expiryCheck)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		e.expireOverrides()
+		e.expirePauses()
+		e.expireTunnels()
+		e.reloadNewDomainFeed()
+	}
+}
+
+func (e *Engine) expireOverrides() {
+	now := time.Now()
+	var expired []*Override
+
+	e.mu.Lock()
+	for name, list := range e.overrides {
+		kept := list[:0:0]
+		for _, o := range list {
+			if now.Before(o.ExpiresAt) {
+				kept = append(kept, o)
+			} else {
+				expired = append(expired, o)
+			}
+		}
+		if len(kept) == 0 {
+			delete(e.overrides, name)
+		} else {
+			e.overrides[name] = kept
+		}
+	}
+	e.mu.Unlock()
+
+	if len(expired) == 0 {
+		return
+	}
+
+	var domains []string
+	for _, o := range expired {
+		if err := e.db.DeleteOverride(o.ID); err != nil {
+			e.log.Warnf("Failed to delete expired override %d: %v", o.ID, err)
+		}
+		e.log.Infof("Temporary %s of %s expired", o.Action, o.Domain)
+		domains = append(domains, o.Domain)
+	}
+	sort.Strings(domains)
+	e.notifyCustomChange(dedupSorted(domains))
+}
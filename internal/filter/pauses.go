@@ -0,0 +1,244 @@
+package filter
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/database"
+)
+
+// ─── Pauses ───────────────────────────────────────────────────────────────────
+//
+// A pause turns blocking off for a while, for everyone, for a client group or
+// for one client. Queries are still checked so what would have been blocked is
+// logged. Pauses are stored in the database and end on their own.
+
+const (
+	PauseGlobal = "global"
+	PauseGroup  = "group"
+	PauseClient = "client"
+)
+
+// Pause is an active pause of filtering
+type Pause struct {
+	ID       int64     `json:"id"`
+	Scope    string    `json:"scope"`
+	Target   string    `json:"target,omitempty"`
+	PausedBy string    `json:"paused_by,omitempty"`
+	Note     string    `json:"note,omitempty"`
+	Started  time.Time `json:"started"`
+	Until    time.Time `json:"until"`
+
+	// Seconds until filtering resumes, filled in when listed
+	Remaining int `json:"remaining_seconds"`
+
+	network *net.IPNet
+}
+
+func newPause(rec database.Pause) (*Pause, error) {
+	p := &Pause{
+		ID:       rec.ID,
+		Scope:    rec.Scope,
+		Target:   rec.Target,
+		PausedBy: rec.PausedBy,
+		Note:     rec.Note,
+		Started:  rec.Started,
+		Until:    rec.Until,
+	}
+	switch p.Scope {
+	case PauseGlobal:
+		p.Target = ""
+	case PauseGroup:
+		if p.Target == "" {
+			return nil, fmt.Errorf("group is required")
+		}
+	case PauseClient:
+		network, err := parseClientNetwork(p.Target)
+		if err != nil {
+			return nil, err
+		}
+		p.network = network
+	default:
+		return nil, fmt.Errorf("unknown scope %q (expected global, group or client)", p.Scope)
+	}
+	return p, nil
+}
+
+// loadPauses reads the stored pauses, dropping those that ended while the
+// server was down
+func (e *Engine) loadPauses() error {
+	records, err := e.db.LoadPauses()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var pauses []*Pause
+	for _, rec := range records {
+		p, err := newPause(rec)
+		if err != nil || !now.Before(p.Until) {
+			if err := e.db.DeletePause(rec.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		pauses = append(pauses, p)
+	}
+
+	e.mu.Lock()
+	e.pauses = pauses
+	e.mu.Unlock()
+	return nil
+}
+
+// PauseFiltering stops blocking for duration. target names the group or the
+// client IP or CIDR for those scopes; pausedBy is recorded with the pause.
+func (e *Engine) PauseFiltering(scope, target string, duration time.Duration, pausedBy, note string) (*Pause, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+
+	now := time.Now()
+	rec := database.Pause{
+		Scope:    scope,
+		Target:   target,
+		PausedBy: pausedBy,
+		Note:     note,
+		Started:  now,
+		Until:    now.Add(duration),
+	}
+	p, err := newPause(rec)
+	if err != nil {
+		return nil, err
+	}
+	if p.Scope == PauseGroup {
+		e.mu.RLock()
+		_, ok := e.clientGroups[p.Target]
+		e.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown client group %q", p.Target)
+		}
+	}
+
+	rec.Target = p.Target
+	if p.ID, err = e.db.AddPause(rec); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.pauses = append(e.pauses, p)
+	e.mu.Unlock()
+
+	e.log.Warnf("Filtering paused (%s) until %s by %s", p.describe(), p.Until.Format("15:04:05"), pausedBy)
+	return p, nil
+}
+
+// ResumeFiltering ends a pause early
+func (e *Engine) ResumeFiltering(id int64) error {
+	var resumed *Pause
+
+	e.mu.Lock()
+	for i, p := range e.pauses {
+		if p.ID == id {
+			resumed = p
+			e.pauses = append(e.pauses[:i:i], e.pauses[i+1:]...)
+			break
+		}
+	}
+	e.mu.Unlock()
+
+	if resumed == nil {
+		return fmt.Errorf("pause %d not found", id)
+	}
+	if err := e.db.DeletePause(id); err != nil {
+		return err
+	}
+	e.log.Infof("Filtering resumed (%s)", resumed.describe())
+	return nil
+}
+
+// GetPauses lists the active pauses with the time left on each
+func (e *Engine) GetPauses() []Pause {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	now := time.Now()
+	list := make([]Pause, 0, len(e.pauses))
+	for _, p := range e.pauses {
+		if now.Before(p.Until) {
+			pause := *p
+			pause.Remaining = int(p.Until.Sub(now).Round(time.Second).Seconds())
+			list = append(list, pause)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Until.Before(list[j].Until) })
+	return list
+}
+
+// PauseFor returns the active pause that covers clientIP, or nil when
+// filtering applies to it
+func (e *Engine) PauseFor(clientIP string) *Pause {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.pauses) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	ip := net.ParseIP(clientIP)
+	for _, p := range e.pauses {
+		if !now.Before(p.Until) {
+			continue
+		}
+		switch p.Scope {
+		case PauseGlobal:
+			return p
+		case PauseClient:
+			if ip != nil && p.network.Contains(ip) {
+				return p
+			}
+		case PauseGroup:
+			for _, network := range e.clientGroups[p.Target] {
+				if ip != nil && network.Contains(ip) {
+					return p
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// expirePauses removes the pauses that have ended
+func (e *Engine) expirePauses() {
+	now := time.Now()
+	var ended []*Pause
+
+	e.mu.Lock()
+	kept := e.pauses[:0:0]
+	for _, p := range e.pauses {
+		if now.Before(p.Until) {
+			kept = append(kept, p)
+		} else {
+			ended = append(ended, p)
+		}
+	}
+	e.pauses = kept
+	e.mu.Unlock()
+
+	for _, p := range ended {
+		if err := e.db.DeletePause(p.ID); err != nil {
+			e.log.Warnf("Failed to delete ended pause %d: %v", p.ID, err)
+		}
+		e.log.Infof("Filtering resumed (%s)", p.describe())
+	}
+}
+
+func (p *Pause) describe() string {
+	if p.Scope == PauseGlobal {
+		return "everyone"
+	}
+	return p.Scope + " " + p.Target
+}
@@ -0,0 +1,476 @@
+package filter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+)
+
+// ─── Response Policy Zones ────────────────────────────────────────────────────
+//
+// A source with format "rpz" (or an axfr:// URL) is loaded as a Response
+// Policy Zone instead of a plain domain list, so the action of every rule is
+// kept. QNAME triggers are checked before the query is forwarded; IP triggers
+// (rpz-ip) are checked against the addresses in the upstream answer.
+
+const (
+	RPZActionNXDOMAIN  = "nxdomain"
+	RPZActionNODATA    = "nodata"
+	RPZActionPassthru  = "passthru"
+	RPZActionDrop      = "drop"
+	RPZActionLocalData = "local-data"
+)
+
+// RPZPolicy is the outcome of a matching RPZ rule
+type RPZPolicy struct {
+	Zone      string
+	Trigger   string
+	Action    string
+	LocalData []dns.RR
+
+	reason string // set for policies that do not come from a zone
+}
+
+// Reason is the block reason reported for the policy
+func (p *RPZPolicy) Reason() string {
+	if p.reason != "" {
+		return p.reason
+	}
+	return "rpz:" + p.Zone
+}
+
+// Answer builds the local-data records for qname and qtype. The owner of
+// every record is rewritten to qname; a CNAME is returned whatever the qtype.
+func (p *RPZPolicy) Answer(qname string, qtype uint16) []dns.RR {
+	var answer []dns.RR
+	for _, rr := range p.LocalData {
+		if rr.Header().Rrtype != qtype && rr.Header().Rrtype != dns.TypeCNAME {
+			continue
+		}
+		rr = dns.Copy(rr)
+		rr.Header().Name = qname
+		if cname, ok := rr.(*dns.CNAME); ok && strings.HasPrefix(cname.Target, "*.") {
+			// "*.example." keeps the query name in front of the target
+			cname.Target = qname + cname.Target[2:]
+		}
+		answer = append(answer, rr)
+	}
+	return answer
+}
+
+type rpzIPRule struct {
+	network *net.IPNet
+	policy  *RPZPolicy
+}
+
+// rpzZone holds the rules of one zone
+type rpzZone struct {
+	name      string
+	exact     map[string]*RPZPolicy
+	wildcards map[string]*RPZPolicy
+	ipRules   []rpzIPRule
+	skipped   int
+	serial    uint32
+}
+
+func (z *rpzZone) matchName(domain string) *RPZPolicy {
+	if p, ok := z.exact[domain]; ok {
+		return p
+	}
+	// "*.example.com" covers names below example.com, closest parent first
+	for parent := domain; ; {
+		idx := strings.Index(parent, ".")
+		if idx == -1 {
+			return nil
+		}
+		parent = parent[idx+1:]
+		if p, ok := z.wildcards[parent]; ok {
+			return p
+		}
+	}
+}
+
+func (z *rpzZone) matchIP(ip net.IP) *RPZPolicy {
+	var best *RPZPolicy
+	bestLen := -1
+	for _, rule := range z.ipRules {
+		if !rule.network.Contains(ip) {
+			continue
+		}
+		if ones, _ := rule.network.Mask.Size(); ones > bestLen {
+			best, bestLen = rule.policy, ones
+		}
+	}
+	return best
+}
+
+func (z *rpzZone) count() int {
+	return len(z.exact) + len(z.wildcards) + len(z.ipRules)
+}
+
+// newRPZZone builds a zone from its records. zone may be empty, in which
+// case the owner of the SOA record names the zone.
+func newRPZZone(zone string, records []dns.RR) *rpzZone {
+	origin := dns.Fqdn(strings.ToLower(zone))
+	if zone == "" {
+		origin = ""
+		for _, rr := range records {
+			if soa, ok := rr.(*dns.SOA); ok {
+				origin = strings.ToLower(soa.Hdr.Name)
+				break
+			}
+		}
+	}
+
+	z := &rpzZone{
+		name:      strings.TrimSuffix(origin, "."),
+		exact:     make(map[string]*RPZPolicy),
+		wildcards: make(map[string]*RPZPolicy),
+	}
+	if z.name == "" {
+		z.name = "."
+	}
+
+	localData := make(map[string]*RPZPolicy)
+	for _, rr := range records {
+		owner := strings.ToLower(rr.Header().Name)
+		switch rec := rr.(type) {
+		case *dns.SOA:
+			z.serial = rec.Serial
+			continue
+		case *dns.NS:
+			continue
+		}
+
+		if origin != "" && origin != "." {
+			if owner == origin {
+				continue
+			}
+			if !strings.HasSuffix(owner, "."+origin) {
+				z.skipped++
+				continue
+			}
+			owner = strings.TrimSuffix(owner, "."+origin)
+		} else {
+			owner = strings.TrimSuffix(owner, ".")
+		}
+
+		policy := rpzAction(rr)
+		policy.Zone = z.name
+		policy.Trigger = owner
+
+		// Several local-data records under one owner form a single answer
+		if policy.Action == RPZActionLocalData {
+			if existing, ok := localData[owner]; ok {
+				existing.LocalData = append(existing.LocalData, rr)
+				continue
+			}
+			localData[owner] = policy
+		}
+
+		if !z.addRule(owner, policy) {
+			z.skipped++
+		}
+	}
+	return z
+}
+
+// addRule files a policy under its trigger. Only QNAME and rpz-ip triggers
+// are supported; client IP and name server triggers are skipped.
+func (z *rpzZone) addRule(owner string, policy *RPZPolicy) bool {
+	switch {
+	case strings.HasSuffix(owner, ".rpz-ip"):
+		network, err := parseRPZIP(strings.TrimSuffix(owner, ".rpz-ip"))
+		if err != nil {
+			return false
+		}
+		z.ipRules = append(z.ipRules, rpzIPRule{network: network, policy: policy})
+		return true
+
+	case strings.HasSuffix(owner, ".rpz-client-ip"),
+		strings.HasSuffix(owner, ".rpz-nsip"),
+		strings.HasSuffix(owner, ".rpz-nsdname"):
+		return false
+
+	case strings.HasPrefix(owner, "*."):
+		z.wildcards[owner[2:]] = policy
+		return true
+
+	case owner != "" && !strings.HasPrefix(owner, "rpz-"):
+		z.exact[owner] = policy
+		return true
+	}
+	return false
+}
+
+// rpzAction reads the action encoded in the data of a record
+func rpzAction(rr dns.RR) *RPZPolicy {
+	if cname, ok := rr.(*dns.CNAME); ok {
+		switch strings.ToLower(cname.Target) {
+		case ".":
+			return &RPZPolicy{Action: RPZActionNXDOMAIN}
+		case "*.":
+			return &RPZPolicy{Action: RPZActionNODATA}
+		case "rpz-passthru.":
+			return &RPZPolicy{Action: RPZActionPassthru}
+		case "rpz-drop.":
+			return &RPZPolicy{Action: RPZActionDrop}
+		}
+	}
+	return &RPZPolicy{Action: RPZActionLocalData, LocalData: []dns.RR{rr}}
+}
+
+// parseRPZIP reads an rpz-ip trigger such as "24.0.2.0.192" (192.0.2.0/24) or
+// "48.zz.db8.2001" (2001:db8::/48)
+func parseRPZIP(trigger string) (*net.IPNet, error) {
+	labels := strings.Split(trigger, ".")
+	if len(labels) < 2 {
+		return nil, fmt.Errorf("invalid rpz-ip trigger %q", trigger)
+	}
+	prefix, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid prefix length in %q", trigger)
+	}
+
+	parts := labels[1:]
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+
+	var addr string
+	if len(parts) == 4 && prefix <= 32 {
+		addr = strings.Join(parts, ".")
+	} else {
+		// "zz" stands for the run of zero groups written "::"
+		for i, part := range parts {
+			if part == "zz" {
+				parts[i] = ""
+				if i == 0 || i == len(parts)-1 {
+					parts[i] = ":"
+				}
+			}
+		}
+		addr = strings.Join(parts, ":")
+	}
+
+	_, network, err := net.ParseCIDR(fmt.Sprintf("%s/%d", addr, prefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid rpz-ip trigger %q: %v", trigger, err)
+	}
+	return network, nil
+}
+
+// ─── Loading ──────────────────────────────────────────────────────────────────
+
+// isRPZSource reports whether a source is loaded as a policy zone
+func isRPZSource(source config.BlocklistSource) bool {
+	return source.Format == FormatRPZ || strings.HasPrefix(source.URL, "axfr://")
+}
+
+// fetchRPZZone loads a zone from a file, a URL or by zone transfer
+func (e *Engine) fetchRPZZone(source config.BlocklistSource) (*rpzZone, error) {
+	var records []dns.RR
+
+	if strings.HasPrefix(source.URL, "axfr://") {
+		rrs, err := e.transferZone(source.URL, source.Zone)
+		if err != nil {
+			return nil, err
+		}
+		records = rrs
+	} else {
+		body, err := e.openBlocklist(source.URL)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := e.verifyContent(source, data); err != nil {
+			return nil, &verifyError{err}
+		}
+
+		rrs, err := parseZoneFile(bytes.NewReader(data), source.Zone, source.URL)
+		if err != nil {
+			return nil, err
+		}
+		records = rrs
+	}
+
+	zone := newRPZZone(source.Zone, records)
+	if zone.name == "." {
+		zone.name = source.Name
+	}
+	return zone, nil
+}
+
+func parseZoneFile(r io.Reader, zone, file string) ([]dns.RR, error) {
+	origin := "."
+	if zone != "" {
+		origin = dns.Fqdn(zone)
+	}
+
+	var records []dns.RR
+	zp := dns.NewZoneParser(r, origin, file)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		records = append(records, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// transferZone requests the zone with AXFR from the primary in rawURL
+// (axfr://host[:port])
+func (e *Engine) transferZone(rawURL, zone string) ([]dns.RR, error) {
+	if zone == "" {
+		return nil, fmt.Errorf("zone transfer needs the zone name")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "53")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetAxfr(dns.Fqdn(zone))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	conn, err := e.dial(ctx, "tcp", addr)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	transfer := &dns.Transfer{Conn: &dns.Conn{Conn: conn}, ReadTimeout: 60 * time.Second}
+	envelopes, err := transfer.In(msg, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []dns.RR
+	for env := range envelopes {
+		if env.Error != nil {
+			return nil, env.Error
+		}
+		records = append(records, env.RR...)
+	}
+	return records, nil
+}
+
+// updateRPZSource refreshes one zone. On failure the previous copy is kept.
+func (e *Engine) updateRPZSource(source config.BlocklistSource) {
+	zone, err := e.fetchRPZZone(source)
+	if err != nil {
+		e.log.Errorf("Failed to load RPZ %s: %v", source.Name, err)
+		e.recordSourceStatus(source, nil, err)
+		return
+	}
+
+	e.mu.Lock()
+	e.rpzZones[source.Name] = zone
+	e.mu.Unlock()
+
+	e.recordSourceStatus(source, &sourceFetch{result: &parseResult{
+		Format:      FormatRPZ,
+		Lines:       zone.count() + zone.skipped,
+		Rules:       zone.count(),
+		ParseErrors: zone.skipped,
+	}}, nil)
+
+	e.log.Infof("Loaded RPZ %s: zone %s serial %d, %d rules (%d skipped)",
+		source.Name, zone.name, zone.serial, zone.count(), zone.skipped)
+}
+
+// updateRPZZones refreshes every enabled policy zone
+func (e *Engine) updateRPZZones() {
+	for _, source := range e.getSources() {
+		if source.Enabled && isRPZSource(source) {
+			e.updateRPZSource(source)
+		}
+	}
+}
+
+// ─── Lookups ──────────────────────────────────────────────────────────────────
+
+// rpzZonesInOrder returns the loaded zones in the order of the sources, which
+// is the order in which they take precedence. Shadow zones are returned only
+// when shadow is set, and then alone. Caller holds e.mu.
+func (e *Engine) rpzZonesInOrder(shadow bool) []*rpzZone {
+	var zones []*rpzZone
+	for _, source := range e.sources {
+		if !source.Enabled || source.Shadow != shadow {
+			continue
+		}
+		if zone, ok := e.rpzZones[source.Name]; ok {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// CheckRPZ returns the policy for a query name, or nil if no zone has a
+// rule for it. Whitelisted names are never rewritten.
+func (e *Engine) CheckRPZ(domain string) *RPZPolicy {
+	domain = normalizeDomain(domain)
+	if domain == "" || e.isWhitelisted(domain) {
+		return nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, zone := range e.rpzZonesInOrder(false) {
+		if p := zone.matchName(domain); p != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+// CheckRPZResponse returns the policy for the first address in an upstream
+// answer that hits an rpz-ip trigger
+func (e *Engine) CheckRPZResponse(domain string, answer []dns.RR) *RPZPolicy {
+	domain = normalizeDomain(domain)
+	if domain == "" || e.isWhitelisted(domain) {
+		return nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	zones := e.rpzZonesInOrder(false)
+	for _, rr := range answer {
+		var ip net.IP
+		switch rec := rr.(type) {
+		case *dns.A:
+			ip = rec.A
+		case *dns.AAAA:
+			ip = rec.AAAA
+		default:
+			continue
+		}
+		for _, zone := range zones {
+			if p := zone.matchIP(ip); p != nil {
+				return p
+			}
+		}
+	}
+	return nil
+}
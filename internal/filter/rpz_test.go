@@ -0,0 +1,133 @@
+package filter
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseRPZIP(t *testing.T) {
+	tests := []struct {
+		trigger string
+		want    string
+		wantErr bool
+	}{
+		{"32.4.3.2.1", "1.2.3.4/32", false},
+		{"24.0.2.0.192", "192.0.2.0/24", false},
+		{"48.zz.db8.2001", "2001:db8::/48", false},
+		{"128.1.zz.db8.2001", "2001:db8::1/128", false},
+		{"x.1.2.3.4", "", true},
+		{"32", "", true},
+		{"33.4.3.2.1", "", true},
+	}
+	for _, tt := range tests {
+		network, err := parseRPZIP(tt.trigger)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRPZIP(%q): err = %v, want error %v", tt.trigger, err, tt.wantErr)
+			continue
+		}
+		if err == nil && network.String() != tt.want {
+			t.Errorf("parseRPZIP(%q) = %s, want %s", tt.trigger, network, tt.want)
+		}
+	}
+}
+
+const testZone = `$TTL 300
+@ IN SOA ns.rpz.test. admin.rpz.test. 7 3600 600 86400 60
+@ IN NS ns.rpz.test.
+bad.com CNAME .
+*.bad.com CNAME .
+empty.com CNAME *.
+ok.bad.com CNAME rpz-passthru.
+drop.com CNAME rpz-drop.
+local.com A 10.0.0.1
+local.com A 10.0.0.2
+local.com AAAA 2001:db8::1
+rewrite.com CNAME safe.example.net.
+24.0.2.0.192.rpz-ip CNAME .
+32.5.2.0.192.rpz-ip CNAME rpz-passthru.
+32.1.1.1.10.rpz-client-ip CNAME .
+ns.evil.rpz-nsdname CNAME .
+outside.example. CNAME .
+`
+
+func TestRPZZone(t *testing.T) {
+	records, err := parseZoneFile(strings.NewReader(testZone), "rpz.test", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := newRPZZone("", records)
+	if z.name != "rpz.test" || z.serial != 7 {
+		t.Errorf("zone %q serial %d, want rpz.test 7", z.name, z.serial)
+	}
+	// client-ip, nsdname and the record outside the zone
+	if z.skipped != 3 {
+		t.Errorf("skipped %d records, want 3", z.skipped)
+	}
+
+	names := []struct {
+		domain, action string
+		answers        int
+	}{
+		{"bad.com", RPZActionNXDOMAIN, 0},
+		{"www.bad.com", RPZActionNXDOMAIN, 0},
+		{"ok.bad.com", RPZActionPassthru, 0},
+		{"a.ok.bad.com", RPZActionNXDOMAIN, 0},
+		{"empty.com", RPZActionNODATA, 0},
+		{"drop.com", RPZActionDrop, 0},
+		{"local.com", RPZActionLocalData, 2},
+		{"rewrite.com", RPZActionLocalData, 1},
+		{"good.com", "", 0},
+		{"www.empty.com", "", 0},
+	}
+	for _, tt := range names {
+		p := z.matchName(tt.domain)
+		if tt.action == "" {
+			if p != nil {
+				t.Errorf("%s matched %s", tt.domain, p.Action)
+			}
+			continue
+		}
+		if p == nil || p.Action != tt.action {
+			t.Errorf("%s: policy %+v, want %s", tt.domain, p, tt.action)
+			continue
+		}
+		if got := len(p.Answer(tt.domain+".", 1)); got != tt.answers {
+			t.Errorf("%s: %d A records, want %d", tt.domain, got, tt.answers)
+		}
+	}
+
+	ips := []struct {
+		ip, action string
+	}{
+		{"192.0.2.1", RPZActionNXDOMAIN},
+		{"192.0.2.5", RPZActionPassthru}, // the longest prefix wins
+		{"198.51.100.1", ""},
+	}
+	for _, tt := range ips {
+		p := z.matchIP(net.ParseIP(tt.ip))
+		got := ""
+		if p != nil {
+			got = p.Action
+		}
+		if got != tt.action {
+			t.Errorf("%s: action %q, want %q", tt.ip, got, tt.action)
+		}
+	}
+}
+
+func TestRPZAnswerRewrite(t *testing.T) {
+	records, err := parseZoneFile(strings.NewReader("$TTL 300\n*.wild.com CNAME *.sinkhole.net.\n"), "rpz.test", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := newRPZZone("rpz.test", records)
+	p := z.matchName("a.wild.com")
+	if p == nil {
+		t.Fatal("no match for a.wild.com")
+	}
+	answer := p.Answer("a.wild.com.", 1)
+	if len(answer) != 1 || !strings.Contains(answer[0].String(), "a.wild.com.sinkhole.net.") {
+		t.Errorf("answer = %v, want a CNAME to a.wild.com.sinkhole.net.", answer)
+	}
+}
@@ -0,0 +1,420 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ─── Pattern Rules ────────────────────────────────────────────────────────────
+//
+// Pattern rules match the full domain name with an RE2 regular expression or a
+// glob. All enabled rules of one action are compiled into a single alternation
+// so a lookup is one pass over the name, and RE2 guarantees that pass runs in
+// time linear in the length of the name no matter what the patterns look like.
+
+const (
+	PatternKindRegex = "regex"
+	PatternKindGlob  = "glob"
+
+	PatternActionBlock = "block"
+	PatternActionAllow = "allow"
+
+	maxPatternLength = 512
+)
+
+// catchAllProbes are unrelated names; a pattern matching all of them would
+// match every domain
+var catchAllProbes = []string{"a", "example.com", "www.wikipedia.org", "x1-9.q.co.uk", "localhost"}
+
+// PatternRule is a single regex or glob rule
+type PatternRule struct {
+	ID      int64  `json:"id"`
+	Pattern string `json:"pattern"`
+	Kind    string `json:"kind"`
+	Action  string `json:"action"`
+	Source  string `json:"source"`
+	Note    string `json:"note"`
+	Enabled bool   `json:"enabled"`
+	Hits    uint64 `json:"hits"`
+
+	expr string
+	hits *uint64
+}
+
+// key identifies a rule independently of where it was loaded from, so hit
+// counters survive a rebuild of the matcher
+func (r *PatternRule) key() string {
+	return r.Action + "|" + r.Kind + "|" + r.Pattern
+}
+
+// NewPatternRule validates pattern and returns a rule ready to be compiled
+func NewPatternRule(pattern, kind, action string) (*PatternRule, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, fmt.Errorf("pattern is empty")
+	}
+	if len(pattern) > maxPatternLength {
+		return nil, fmt.Errorf("pattern is longer than %d characters", maxPatternLength)
+	}
+
+	if action == "" {
+		action = PatternActionBlock
+	}
+	if action != PatternActionBlock && action != PatternActionAllow {
+		return nil, fmt.Errorf("unknown action %q (expected block or allow)", action)
+	}
+
+	var expr string
+	switch kind {
+	case PatternKindRegex:
+		// Queried names are lowercase
+		expr = "(?i)" + pattern
+	case PatternKindGlob:
+		expr = globToRegex(strings.ToLower(pattern))
+	default:
+		return nil, fmt.Errorf("unknown pattern kind %q (expected regex or glob)", kind)
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", kind, err)
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("pattern matches the empty name and would match every domain")
+	}
+	catchAll := true
+	for _, probe := range catchAllProbes {
+		catchAll = catchAll && re.MatchString(probe)
+	}
+	if catchAll {
+		return nil, fmt.Errorf("pattern would match every domain")
+	}
+
+	return &PatternRule{
+		Pattern: pattern,
+		Kind:    kind,
+		Action:  action,
+		Enabled: true,
+		expr:    expr,
+	}, nil
+}
+
+// ParsePatternRule reads the inline syntax used in YAML files and the
+// whitelist: "/expr/" is a regex, anything containing * or ? is a glob
+func ParsePatternRule(raw, action string) (*PatternRule, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) > 2 && strings.HasPrefix(raw, "/") && strings.HasSuffix(raw, "/") {
+		return NewPatternRule(raw[1:len(raw)-1], PatternKindRegex, action)
+	}
+	return NewPatternRule(raw, PatternKindGlob, action)
+}
+
+// IsPattern reports whether s uses the inline regex or glob syntax rather
+// than naming a single domain
+func IsPattern(s string) bool {
+	s = strings.TrimSpace(s)
+	if len(s) > 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		return true
+	}
+	return strings.ContainsAny(s, "*?")
+}
+
+// globToRegex converts a domain glob into an anchored regex. A leading "*."
+// also matches the bare domain so "*.example.com" keeps covering example.com,
+// as the whitelist always did.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	if strings.HasPrefix(glob, "*.") {
+		b.WriteString(`(?:.*\.)?`)
+		glob = glob[2:]
+	}
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// patternMatcher is the compiled form of a set of rules
+type patternMatcher struct {
+	re     *regexp.Regexp
+	rules  []*PatternRule
+	groups []int // submatch index of the group wrapping each rule
+}
+
+func compilePatterns(rules []*PatternRule) (*patternMatcher, error) {
+	m := &patternMatcher{}
+	if len(rules) == 0 {
+		return m, nil
+	}
+
+	parts := make([]string, 0, len(rules))
+	group := 1
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Pattern, err)
+		}
+		parts = append(parts, "("+rule.expr+")")
+		m.rules = append(m.rules, rule)
+		m.groups = append(m.groups, group)
+		group += 1 + re.NumSubexp()
+	}
+
+	re, err := regexp.Compile(strings.Join(parts, "|"))
+	if err != nil {
+		return nil, err
+	}
+	m.re = re
+	return m, nil
+}
+
+// Match returns the first rule matching domain and counts the hit
+func (m *patternMatcher) Match(domain string) *PatternRule {
+	rule := m.find(domain)
+	if rule != nil && rule.hits != nil {
+		atomic.AddUint64(rule.hits, 1)
+	}
+	return rule
+}
+
+// find returns the first rule matching domain without counting a hit
+func (m *patternMatcher) find(domain string) *PatternRule {
+	if m == nil || m.re == nil {
+		return nil
+	}
+
+	loc := m.re.FindStringSubmatchIndex(domain)
+	if loc == nil {
+		return nil
+	}
+
+	for i, g := range m.groups {
+		if loc[2*g] >= 0 {
+			return m.rules[i]
+		}
+	}
+	return nil
+}
+
+// ─── Engine Integration ───────────────────────────────────────────────────────
+
+// inlinePattern renders a rule in the syntax accepted by ParsePatternRule
+func inlinePattern(rule *PatternRule) string {
+	if rule.Kind == PatternKindRegex {
+		return "/" + rule.Pattern + "/"
+	}
+	return rule.Pattern
+}
+
+// setCustomPatterns replaces the rules loaded from custom YAML files
+func (e *Engine) setCustomPatterns(rules []*PatternRule) {
+	e.mu.Lock()
+	e.customPatterns = rules
+	e.mu.Unlock()
+
+	if err := e.rebuildPatterns(); err != nil {
+		e.log.Warnf("Failed to rebuild pattern rules: %v", err)
+	}
+}
+
+// rebuildPatterns recompiles the rules stored in the database, the whitelist
+// patterns from the config and the entries from custom YAML files
+func (e *Engine) rebuildPatterns() error {
+	records, err := e.db.LoadPatternRules()
+	if err != nil {
+		return err
+	}
+
+	var rules []*PatternRule
+	for _, rec := range records {
+		rule, err := NewPatternRule(rec.Pattern, rec.Kind, rec.Action)
+		if err != nil {
+			e.log.Warnf("Skipping invalid pattern rule %d (%s): %v", rec.ID, rec.Pattern, err)
+			continue
+		}
+		rule.ID = rec.ID
+		rule.Note = rec.Note
+		rule.Enabled = rec.Enabled
+		rule.Source = "api"
+		rule.Hits = uint64(rec.Hits)
+		rules = append(rules, rule)
+	}
+
+	for _, entry := range e.cfg.Whitelist.Domains {
+		if !IsPattern(entry) {
+			continue
+		}
+		rule, err := ParsePatternRule(entry, PatternActionAllow)
+		if err != nil {
+			e.log.Warnf("Invalid whitelist pattern %q: %v", entry, err)
+			continue
+		}
+		rule.Source = "config"
+		rules = append(rules, rule)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules = append(rules, e.customPatterns...)
+
+	var block, allow []*PatternRule
+	for _, rule := range rules {
+		counter, ok := e.patternHits[rule.key()]
+		if !ok {
+			counter = new(uint64)
+			*counter = rule.Hits
+			e.patternHits[rule.key()] = counter
+		}
+		rule.hits = counter
+
+		if !rule.Enabled {
+			continue
+		}
+		if rule.Action == PatternActionAllow {
+			allow = append(allow, rule)
+		} else {
+			block = append(block, rule)
+		}
+	}
+
+	blockMatcher, err := compilePatterns(block)
+	if err != nil {
+		return err
+	}
+	allowMatcher, err := compilePatterns(allow)
+	if err != nil {
+		return err
+	}
+
+	e.blockPatterns = blockMatcher
+	e.allowPatterns = allowMatcher
+	e.patternRules = rules
+	return nil
+}
+
+// GetPatternRules returns every known rule with its current hit count
+func (e *Engine) GetPatternRules() []PatternRule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	list := make([]PatternRule, 0, len(e.patternRules))
+	for _, rule := range e.patternRules {
+		r := *rule
+		if rule.hits != nil {
+			r.Hits = atomic.LoadUint64(rule.hits)
+		}
+		list = append(list, r)
+	}
+	return list
+}
+
+// GetPatternRule returns the stored rule with the given id
+func (e *Engine) GetPatternRule(id int64) (PatternRule, bool) {
+	for _, rule := range e.GetPatternRules() {
+		if rule.ID == id && rule.Source == "api" {
+			return rule, true
+		}
+	}
+	return PatternRule{}, false
+}
+
+// ValidatePattern checks a pattern without storing it
+func (e *Engine) ValidatePattern(pattern, kind, action string) error {
+	_, err := NewPatternRule(pattern, kind, action)
+	return err
+}
+
+// AddPatternRule validates and stores a new rule, then recompiles the matchers
+func (e *Engine) AddPatternRule(pattern, kind, action, note string) (*PatternRule, error) {
+	rule, err := NewPatternRule(pattern, kind, action)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, existing := range e.GetPatternRules() {
+		if existing.key() == rule.key() {
+			return nil, fmt.Errorf("rule already exists")
+		}
+	}
+
+	id, err := e.db.AddPatternRule(rule.Pattern, rule.Kind, rule.Action, note)
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = id
+	rule.Note = note
+	rule.Source = "api"
+
+	if err := e.rebuildPatterns(); err != nil {
+		return nil, err
+	}
+
+	e.log.Infof("Added %s %s rule: %s", rule.Action, rule.Kind, rule.Pattern)
+	return rule, nil
+}
+
+func (e *Engine) RemovePatternRule(id int64) error {
+	if _, ok := e.GetPatternRule(id); !ok {
+		return fmt.Errorf("rule %d not found", id)
+	}
+	if err := e.db.DeletePatternRule(id); err != nil {
+		return err
+	}
+	return e.rebuildPatterns()
+}
+
+// SetPatternRuleEnabled turns a stored rule on or off
+func (e *Engine) SetPatternRuleEnabled(id int64, enabled bool) error {
+	if _, ok := e.GetPatternRule(id); !ok {
+		return fmt.Errorf("rule %d not found", id)
+	}
+	if err := e.db.SetPatternRuleEnabled(id, enabled); err != nil {
+		return err
+	}
+	return e.rebuildPatterns()
+}
+
+// flushHitsLoop periodically persists the hit counters of stored rules and
+// blocklist sources, the domains learned by the walled garden and the other
+// counters kept in memory between flushes
+func (e *Engine) flushHitsLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		e.flushPatternHits()
+		e.flushSourceHits()
+		e.flushGardenLearned()
+		e.flushBudgetUsage()
+		e.flushShadowCounts()
+		e.flushFirstSeen()
+	}
+}
+
+func (e *Engine) flushPatternHits() {
+	hits := make(map[int64]uint64)
+	for _, rule := range e.GetPatternRules() {
+		if rule.ID > 0 {
+			hits[rule.ID] = rule.Hits
+		}
+	}
+	if len(hits) == 0 {
+		return
+	}
+	if err := e.db.SavePatternRuleHits(hits); err != nil {
+		e.log.Warnf("Failed to save pattern rule hits: %v", err)
+	}
+}
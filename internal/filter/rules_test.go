@@ -0,0 +1,75 @@
+package filter
+
+import "testing"
+
+func TestNewPatternRule(t *testing.T) {
+	tests := []struct {
+		pattern, kind string
+		wantErr       bool
+		match         []string
+		noMatch       []string
+	}{
+		{pattern: `^ad[0-9]+\.`, kind: PatternKindRegex, match: []string{"ad1.example.com"}, noMatch: []string{"bad1.example.com"}},
+		{pattern: `^Ad`, kind: PatternKindRegex, match: []string{"ads.example.com"}},
+		{pattern: "*.doubleclick.*", kind: PatternKindGlob, match: []string{"doubleclick.net", "ad.doubleclick.net"}, noMatch: []string{"doubleclick"}},
+		{pattern: "AD?.example.com", kind: PatternKindGlob, match: []string{"ad1.example.com"}, noMatch: []string{"ad.example.com"}},
+		{pattern: "*", kind: PatternKindGlob, wantErr: true},
+		{pattern: ".*", kind: PatternKindRegex, wantErr: true},
+		{pattern: ".", kind: PatternKindRegex, wantErr: true},
+		{pattern: `\w`, kind: PatternKindRegex, wantErr: true},
+		{pattern: "(", kind: PatternKindRegex, wantErr: true},
+		{pattern: "", kind: PatternKindRegex, wantErr: true},
+		{pattern: "example.com", kind: "exact", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		rule, err := NewPatternRule(tt.pattern, tt.kind, PatternActionBlock)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewPatternRule(%q, %s): err = %v, want error %v", tt.pattern, tt.kind, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		m, err := compilePatterns([]*PatternRule{rule})
+		if err != nil {
+			t.Fatalf("compilePatterns(%q): %v", tt.pattern, err)
+		}
+		for _, name := range tt.match {
+			if m.find(name) == nil {
+				t.Errorf("%q does not match %s", tt.pattern, name)
+			}
+		}
+		for _, name := range tt.noMatch {
+			if m.find(name) != nil {
+				t.Errorf("%q matches %s", tt.pattern, name)
+			}
+		}
+	}
+}
+
+func TestCompilePatternsGroups(t *testing.T) {
+	var rules []*PatternRule
+	for _, raw := range []string{`/^(a|b)(c)x/`, "*.second.com", `/^(third)$/`} {
+		rule, err := ParsePatternRule(raw, PatternActionBlock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	m, err := compilePatterns(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"acx.com":        `^(a|b)(c)x`,
+		"www.second.com": "*.second.com",
+		"third":          `^(third)$`,
+	}
+	for name, want := range tests {
+		if rule := m.find(name); rule == nil || rule.Pattern != want {
+			t.Errorf("find(%q) = %v, want %s", name, rule, want)
+		}
+	}
+}
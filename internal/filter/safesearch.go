@@ -0,0 +1,79 @@
+package filter
+
+import (
+	"strings"
+)
+
+// ─── SafeSearch ───────────────────────────────────────────────────────────────
+//
+// Search engines and YouTube enforce their safe modes for everyone who reaches
+// them through special host names. SafeSearch is enforced by answering for the
+// usual names with a CNAME to those hosts, always when safe_search or
+// youtube_restricted are set, or while a safe_search schedule rule applies.
+
+var safeSearchHosts = map[string]string{
+	"bing.com":           "strict.bing.com",
+	"www.bing.com":       "strict.bing.com",
+	"duckduckgo.com":     "safe.duckduckgo.com",
+	"www.duckduckgo.com": "safe.duckduckgo.com",
+}
+
+var youtubeHosts = map[string]bool{
+	"youtube.com":              true,
+	"www.youtube.com":          true,
+	"m.youtube.com":            true,
+	"youtubei.googleapis.com":  true,
+	"youtube.googleapis.com":   true,
+	"www.youtube-nocookie.com": true,
+}
+
+const (
+	googleSafeSearch = "forcesafesearch.google.com"
+	youtubeRestrict  = "restrict.youtube.com"
+)
+
+// safeSearchPolicies holds the compiled rewrite of each SafeSearch target
+var safeSearchPolicies = map[string]*RPZPolicy{}
+
+func init() {
+	for _, target := range []string{googleSafeSearch, youtubeRestrict, "strict.bing.com", "safe.duckduckgo.com"} {
+		policy, err := rewritePolicy(target)
+		if err != nil {
+			panic(err)
+		}
+		policy.reason = "safesearch:" + target
+		safeSearchPolicies[target] = policy
+	}
+}
+
+// safeSearchTarget returns the safe host for a search engine name, or ""
+func safeSearchTarget(domain string) string {
+	if target, ok := safeSearchHosts[domain]; ok {
+		return target
+	}
+	// google.com, www.google.de, google.co.uk and the other country sites
+	name := strings.TrimPrefix(domain, "www.")
+	if rest := strings.TrimPrefix(name, "google."); rest != name && rest != "" && strings.Count(rest, ".") <= 1 {
+		return googleSafeSearch
+	}
+	return ""
+}
+
+// checkSafeSearch returns the rewrite that enforces SafeSearch or restricted
+// YouTube on domain for clientIP, or nil
+func (e *Engine) checkSafeSearch(domain, clientIP string) *RPZPolicy {
+	search := safeSearchTarget(domain)
+	youtube := youtubeHosts[domain]
+	if search == "" && !youtube {
+		return nil
+	}
+
+	enforced := e.safeSearchFor(clientIP)
+	switch {
+	case search != "" && enforced:
+		return safeSearchPolicies[search]
+	case youtube && (enforced || e.cfg.Filtering.YoutubeRestrict):
+		return safeSearchPolicies[youtubeRestrict]
+	}
+	return nil
+}
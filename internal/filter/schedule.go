@@ -0,0 +1,346 @@
+package filter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+)
+
+// ─── Schedules ────────────────────────────────────────────────────────────────
+//
+// Schedule rules apply an action to some clients during a weekly time window
+// in their own timezone: block everything, block some categories, or enforce
+// SafeSearch. Windows may cross midnight, and holidays or per-rule exception
+// dates switch rules off for a day.
+
+const (
+	ScheduleBlockAll        = "block_all"
+	ScheduleBlockCategories = "block_categories"
+	ScheduleSafeSearch      = "safe_search"
+)
+
+// timeWindow is a daily range of minutes on some weekdays. A window whose
+// end is before its start runs overnight and belongs to the day it starts
+// on; one whose start and end are equal lasts the whole day.
+type timeWindow struct {
+	days  map[time.Weekday]bool // nil for every day
+	start int                   // minutes after midnight
+	end   int
+}
+
+func parseTimeWindow(days []string, start, end string) (timeWindow, error) {
+	var w timeWindow
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return w, err
+	}
+	if len(days) > 0 {
+		w.days = make(map[time.Weekday]bool)
+		for _, day := range days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return w, fmt.Errorf("unknown day %q", day)
+			}
+			w.days[weekday] = true
+		}
+	}
+	return w, nil
+}
+
+// startDay returns the date on which the window containing t started, and
+// false when t is outside the window
+func (w timeWindow) startDay(t time.Time) (time.Time, bool) {
+	minute := t.Hour()*60 + t.Minute()
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	switch {
+	case w.start == w.end:
+		return today, w.onDay(today.Weekday())
+	case w.start < w.end:
+		return today, minute >= w.start && minute < w.end && w.onDay(today.Weekday())
+	case minute >= w.start:
+		return today, w.onDay(today.Weekday())
+	case minute < w.end:
+		// The morning part of a window that started yesterday evening
+		yesterday := today.AddDate(0, 0, -1)
+		return yesterday, w.onDay(yesterday.Weekday())
+	}
+	return today, false
+}
+
+func (w timeWindow) contains(t time.Time) bool {
+	_, ok := w.startDay(t)
+	return ok
+}
+
+func (w timeWindow) onDay(day time.Weekday) bool {
+	return w.days == nil || w.days[day]
+}
+
+// scheduleRule is a compiled schedule rule
+type scheduleRule struct {
+	config.ScheduleRule
+	window     timeWindow
+	location   *time.Location
+	networks   []*net.IPNet
+	categories map[string]bool
+	except     map[string]bool
+}
+
+// ScheduleStatus tells whether a schedule rule applies at a given time
+type ScheduleStatus struct {
+	Name       string   `json:"name"`
+	Action     string   `json:"action"`
+	Categories []string `json:"categories,omitempty"`
+	Clients    []string `json:"clients,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+	Active     bool     `json:"active"`            // the time is inside the rule window
+	Applies    bool     `json:"applies"`           // ... and the rule covers the client asked about
+	Skipped    string   `json:"skipped,omitempty"` // holiday or exception date
+	LocalTime  string   `json:"local_time"`        // the time in the rule timezone
+}
+
+// loadSchedule compiles the schedule rules from the config
+func (e *Engine) loadSchedule() error {
+	var rules []*scheduleRule
+	for i, cfg := range e.cfg.Filtering.Schedule.Rules {
+		name := cfg.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		rule, err := e.compileScheduleRule(cfg)
+		if err != nil {
+			return fmt.Errorf("schedule %s: %v", name, err)
+		}
+		if rule == nil {
+			e.log.Warnf("Schedule %s has no action and is ignored", name)
+			continue
+		}
+		rule.Name = name
+		rules = append(rules, rule)
+	}
+
+	e.mu.Lock()
+	e.scheduleRules = rules
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *Engine) compileScheduleRule(cfg config.ScheduleRule) (*scheduleRule, error) {
+	rule := &scheduleRule{ScheduleRule: cfg, location: time.Local}
+
+	switch rule.Action {
+	case "":
+		// Before actions existed only strict rules did anything
+		if !rule.StrictMode {
+			return nil, nil
+		}
+		rule.Action = ScheduleBlockAll
+	case ScheduleBlockAll, ScheduleSafeSearch:
+	case ScheduleBlockCategories:
+		if len(rule.Categories) == 0 {
+			return nil, fmt.Errorf("action block_categories needs categories")
+		}
+		rule.categories = make(map[string]bool)
+		for _, category := range rule.Categories {
+			rule.categories[strings.ToLower(category)] = true
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q (expected block_all, block_categories or safe_search)", rule.Action)
+	}
+
+	var err error
+	if rule.window, err = parseTimeWindow(cfg.Days, cfg.StartTime, cfg.EndTime); err != nil {
+		return nil, err
+	}
+	if cfg.Timezone != "" {
+		if rule.location, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", cfg.Timezone)
+		}
+	}
+
+	for _, client := range cfg.Clients {
+		network, err := parseClientNetwork(client)
+		if err != nil {
+			return nil, err
+		}
+		rule.networks = append(rule.networks, network)
+	}
+	for _, group := range cfg.Groups {
+		if _, ok := e.clientGroups[group]; !ok {
+			return nil, fmt.Errorf("unknown client group %q", group)
+		}
+	}
+
+	rule.except = make(map[string]bool)
+	for _, date := range append(append([]string(nil), e.cfg.Filtering.Schedule.Holidays...), cfg.Except...) {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return nil, fmt.Errorf("invalid date %q (expected YYYY-MM-DD)", date)
+		}
+		rule.except[date] = true
+	}
+	return rule, nil
+}
+
+// activeAt reports whether the window of the rule contains t, and the date
+// that made it skip the window if one did
+func (r *scheduleRule) activeAt(t time.Time) (bool, string) {
+	day, ok := r.window.startDay(t.In(r.location))
+	if !ok {
+		return false, ""
+	}
+	if date := day.Format("2006-01-02"); r.except[date] {
+		return false, date
+	}
+	return true, ""
+}
+
+// appliesTo reports whether the rule covers ip
+func (r *scheduleRule) appliesTo(ip net.IP, groups map[string][]*net.IPNet) bool {
+	if len(r.networks) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range r.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	for _, group := range r.Groups {
+		for _, network := range groups[group] {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// activeSchedules returns the rules that apply to clientIP at t
+func (e *Engine) activeSchedules(clientIP string, t time.Time) []*scheduleRule {
+	if !e.cfg.Filtering.Schedule.Enabled {
+		return nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	ip := net.ParseIP(clientIP)
+	var active []*scheduleRule
+	for _, rule := range e.scheduleRules {
+		if ok, _ := rule.activeAt(t); ok && rule.appliesTo(ip, e.clientGroups) {
+			active = append(active, rule)
+		}
+	}
+	return active
+}
+
+// checkSchedule returns the block reason of the first active rule that blocks
+// domain for clientIP, or ""
+func (e *Engine) checkSchedule(domain, clientIP string) string {
+	rules := e.activeSchedules(clientIP, time.Now())
+	if len(rules) == 0 {
+		return ""
+	}
+
+	for _, rule := range rules {
+		switch rule.Action {
+		case ScheduleBlockAll:
+			return "schedule:" + rule.Name
+		case ScheduleBlockCategories:
+			// A domain can be in several categories, look for one of this rule
+			if category := e.domainCategory(domain, rule.categories); rule.categories[category] {
+				return "schedule:" + rule.Name + ":" + category
+			}
+		}
+	}
+	return ""
+}
+
+// domainCategory returns the category of domain from the category lists or
+// from the blocklist sources that list it, preferring one in wanted
+func (e *Engine) domainCategory(domain string, wanted map[string]bool) string {
+	found := ""
+	if e.categoryMgr != nil {
+		if found = e.categoryMgr.Lookup(domain); wanted[found] {
+			return found
+		}
+	}
+
+	categories := make(map[string]string)
+	for _, source := range e.getSources() {
+		categories[source.Name] = strings.ToLower(source.Category)
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for name := domain; ; {
+		for _, source := range e.blockedDomains[name] {
+			if category := categories[source]; wanted[category] {
+				return category
+			} else if found == "" {
+				found = category
+			}
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return found
+		}
+		name = name[i+1:]
+	}
+}
+
+// safeSearchFor reports whether SafeSearch is enforced for clientIP, either
+// always or by an active schedule rule
+func (e *Engine) safeSearchFor(clientIP string) bool {
+	if e.cfg.Filtering.SafeSearch {
+		return true
+	}
+	for _, rule := range e.activeSchedules(clientIP, time.Now()) {
+		if rule.Action == ScheduleSafeSearch {
+			return true
+		}
+	}
+	return false
+}
+
+// GetScheduleStatus shows which schedule rules apply to clientIP at t
+func (e *Engine) GetScheduleStatus(clientIP string, t time.Time) []ScheduleStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	ip := net.ParseIP(clientIP)
+	enabled := e.cfg.Filtering.Schedule.Enabled
+	list := make([]ScheduleStatus, 0, len(e.scheduleRules))
+	for _, rule := range e.scheduleRules {
+		active, skipped := rule.activeAt(t)
+		active = active && enabled
+		list = append(list, ScheduleStatus{
+			Name:       rule.Name,
+			Action:     rule.Action,
+			Categories: rule.Categories,
+			Clients:    rule.Clients,
+			Groups:     rule.Groups,
+			Active:     active,
+			Applies:    active && (clientIP == "" || rule.appliesTo(ip, e.clientGroups)),
+			Skipped:    skipped,
+			LocalTime:  t.In(rule.location).Format("Mon 15:04 MST"),
+		})
+	}
+	return list
+}
+
+// scheduleCovers reports whether an active schedule rule applies to clientIP
+func (e *Engine) scheduleCovers(clientIP string) bool {
+	return len(e.activeSchedules(clientIP, time.Now())) > 0
+}
@@ -0,0 +1,158 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+)
+
+// at returns a time in October 2026, when the 16th is a Friday
+func at(day, hour, minute int) time.Time {
+	return time.Date(2026, time.October, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseTimeWindow(t *testing.T) {
+	tests := []struct {
+		days       []string
+		start, end string
+		wantErr    bool
+	}{
+		{nil, "08:00", "17:00", false},
+		{[]string{"Monday", "friday"}, "22:00", "06:30", false},
+		{nil, "8am", "17:00", true},
+		{nil, "08:00", "24:00", true},
+		{[]string{"funday"}, "08:00", "17:00", true},
+	}
+	for _, tt := range tests {
+		if _, err := parseTimeWindow(tt.days, tt.start, tt.end); (err != nil) != tt.wantErr {
+			t.Errorf("parseTimeWindow(%v, %s, %s) = %v, want error %v", tt.days, tt.start, tt.end, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTimeWindowStartDay(t *testing.T) {
+	tests := []struct {
+		name       string
+		days       []string
+		start, end string
+		t          time.Time
+		want       bool
+		wantDay    int
+	}{
+		{"inside a day window", nil, "08:00", "17:00", at(16, 12, 0), true, 16},
+		{"at the start", nil, "08:00", "17:00", at(16, 8, 0), true, 16},
+		{"at the end", nil, "08:00", "17:00", at(16, 17, 0), false, 0},
+		{"wrong weekday", []string{"monday"}, "08:00", "17:00", at(16, 12, 0), false, 0},
+		{"right weekday", []string{"friday"}, "08:00", "17:00", at(16, 12, 0), true, 16},
+		{"overnight evening", []string{"friday"}, "22:00", "06:00", at(16, 23, 0), true, 16},
+		{"overnight morning after", []string{"friday"}, "22:00", "06:00", at(17, 5, 59), true, 16},
+		{"overnight morning of the day", []string{"friday"}, "22:00", "06:00", at(16, 5, 0), false, 0},
+		{"overnight gap", nil, "22:00", "06:00", at(16, 12, 0), false, 0},
+		{"whole day", []string{"saturday"}, "00:00", "00:00", at(17, 23, 59), true, 17},
+		{"whole day, other day", []string{"saturday"}, "00:00", "00:00", at(18, 0, 0), false, 0},
+	}
+	for _, tt := range tests {
+		w, err := parseTimeWindow(tt.days, tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		day, ok := w.startDay(tt.t)
+		if ok != tt.want {
+			t.Errorf("%s: contains = %v, want %v", tt.name, ok, tt.want)
+			continue
+		}
+		if ok && day.Day() != tt.wantDay {
+			t.Errorf("%s: window started on the %d, want the %d", tt.name, day.Day(), tt.wantDay)
+		}
+		if w.contains(tt.t) != ok {
+			t.Errorf("%s: contains and startDay disagree", tt.name)
+		}
+	}
+}
+
+func TestScheduleRuleActiveAt(t *testing.T) {
+	e := &Engine{cfg: &config.Config{}}
+	e.cfg.Filtering.Schedule.Holidays = []string{"2026-12-25"}
+
+	rule, err := e.compileScheduleRule(config.ScheduleRule{
+		Days:      []string{"friday"},
+		StartTime: "22:00",
+		EndTime:   "06:00",
+		Timezone:  "UTC",
+		Action:    ScheduleBlockAll,
+		Except:    []string{"2026-10-23"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plus2 := time.FixedZone("UTC+2", 2*60*60)
+	tests := []struct {
+		name    string
+		t       time.Time
+		want    bool
+		skipped string
+	}{
+		{"friday night", at(16, 23, 0), true, ""},
+		{"saturday morning", at(17, 3, 0), true, ""},
+		{"in the rule timezone", time.Date(2026, time.October, 17, 1, 0, 0, 0, plus2), true, ""},
+		{"outside in the rule timezone", time.Date(2026, time.October, 16, 23, 0, 0, 0, plus2), false, ""},
+		{"exception date", at(23, 23, 0), false, "2026-10-23"},
+		{"morning after the exception", at(24, 3, 0), false, "2026-10-23"},
+		{"holiday", time.Date(2026, time.December, 25, 23, 0, 0, 0, time.UTC), false, "2026-12-25"},
+	}
+	for _, tt := range tests {
+		active, skipped := rule.activeAt(tt.t)
+		if active != tt.want || skipped != tt.skipped {
+			t.Errorf("%s: activeAt = %v, %q, want %v, %q", tt.name, active, skipped, tt.want, tt.skipped)
+		}
+	}
+
+	bad := []config.ScheduleRule{
+		{StartTime: "08:00", EndTime: "09:00", Action: "block_some"},
+		{StartTime: "08:00", EndTime: "09:00", Action: ScheduleBlockCategories},
+		{StartTime: "08:00", EndTime: "09:00", Action: ScheduleBlockAll, Groups: []string{"nobody"}},
+		{StartTime: "08:00", EndTime: "09:00", Action: ScheduleBlockAll, Except: []string{"25/12/2026"}},
+	}
+	for _, cfg := range bad {
+		if _, err := e.compileScheduleRule(cfg); err == nil {
+			t.Errorf("compileScheduleRule(%+v) accepted an invalid rule", cfg)
+		}
+	}
+	if rule, err := e.compileScheduleRule(config.ScheduleRule{StartTime: "08:00", EndTime: "09:00"}); rule != nil || err != nil {
+		t.Errorf("a rule without an action is not ignored: %v, %v", rule, err)
+	}
+}
+
+func TestCheckScheduleCategories(t *testing.T) {
+	e := &Engine{
+		cfg: &config.Config{},
+		sources: []config.BlocklistSource{
+			{Name: "social-list", Category: "Social"},
+			{Name: "adult-list", Category: "Adult"},
+		},
+		blockedDomains: map[string][]string{"example.com": {"social-list", "adult-list"}},
+	}
+	e.cfg.Filtering.Schedule.Enabled = true
+	for _, cfg := range []config.ScheduleRule{
+		{Name: "games", StartTime: "00:00", EndTime: "00:00", Action: ScheduleBlockCategories, Categories: []string{"gaming"}},
+		{Name: "adult", StartTime: "00:00", EndTime: "00:00", Action: ScheduleBlockCategories, Categories: []string{"adult"}},
+	} {
+		rule, err := e.compileScheduleRule(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.scheduleRules = append(e.scheduleRules, rule)
+	}
+
+	tests := []struct{ domain, want string }{
+		{"www.example.com", "schedule:adult:adult"},
+		{"other.com", ""},
+	}
+	for _, tt := range tests {
+		if got := e.checkSchedule(tt.domain, "10.0.0.5"); got != tt.want {
+			t.Errorf("checkSchedule(%s) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+)
+
+// ─── Scoped Custom Entries ────────────────────────────────────────────────────
+//
+// Entries in custom YAML files can be limited to some clients or groups, to a
+// weekly schedule or until an expiry time, can cover only the exact name or
+// only its subdomains, and can allow or rewrite a name instead of blocking
+// it. Plain block entries keep using the fast set lookup; everything else is
+// kept as a scoped rule and checked against the client and the current time.
+
+const (
+	CustomListRewrite = "rewrite"
+
+	MatchSubtree  = "subtree"  // the name and all its subdomains (default)
+	MatchExact    = "exact"    // only the name itself
+	MatchWildcard = "wildcard" // only the subdomains of the name
+
+	rewriteTTL = 300
+)
+
+// EntrySchedule limits an entry to some days and hours. A window whose end is
+// before its start runs overnight and belongs to the day it starts on; one
+// whose start and end are equal lasts the whole day.
+type EntrySchedule struct {
+	Days  []string `yaml:"days" json:"days,omitempty"`   // monday..sunday, empty for every day
+	Start string   `yaml:"start" json:"start,omitempty"` // HH:MM
+	End   string   `yaml:"end" json:"end,omitempty"`     // HH:MM
+}
+
+// EntryScope is everything about an entry beyond its domain and list
+type EntryScope struct {
+	Match    string         `json:"match,omitempty"`
+	Rewrite  string         `json:"rewrite,omitempty"`
+	Expires  *time.Time     `json:"expires,omitempty"`
+	Schedule *EntrySchedule `json:"schedule,omitempty"`
+	Clients  []string       `json:"clients,omitempty"`
+	Groups   []string       `json:"groups,omitempty"`
+}
+
+// customRule is a compiled scoped entry
+type customRule struct {
+	domain   string
+	list     string
+	scope    EntryScope
+	networks []*net.IPNet
+	window   timeWindow
+	rewrite  *RPZPolicy
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday,
+	"wednesday": time.Wednesday, "thursday": time.Thursday, "friday": time.Friday,
+	"saturday": time.Saturday,
+}
+
+// scopeFromEntry validates the scope fields of a custom YAML entry and returns
+// the list the entry belongs to and its scope, or nil for a plain block
+func scopeFromEntry(entry CustomBlocklistEntry) (string, *EntryScope, error) {
+	list := CustomListBlock
+	switch strings.ToLower(entry.Action) {
+	case "", "block":
+	case "allow":
+		list = CustomListAllow
+	case "rewrite":
+		list = CustomListRewrite
+		if entry.Rewrite == "" {
+			return "", nil, fmt.Errorf("action rewrite needs a rewrite target")
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown action %q (expected block, allow or rewrite)", entry.Action)
+	}
+
+	scope := &EntryScope{
+		Match:    strings.ToLower(entry.Match),
+		Schedule: entry.Schedule,
+		Clients:  entry.Clients,
+		Groups:   entry.Groups,
+	}
+	switch scope.Match {
+	case "":
+		scope.Match = MatchSubtree
+	case MatchSubtree, MatchExact, MatchWildcard:
+	default:
+		return "", nil, fmt.Errorf("unknown match %q (expected subtree, exact or wildcard)", entry.Match)
+	}
+	if list == CustomListRewrite {
+		scope.Rewrite = strings.TrimSuffix(strings.TrimSpace(entry.Rewrite), ".")
+	}
+	if entry.Expires != "" {
+		expires, err := parseExpiry(entry.Expires)
+		if err != nil {
+			return "", nil, err
+		}
+		scope.Expires = &expires
+	}
+
+	// Validate by compiling once
+	if _, err := compileCustomRule("", list, *scope); err != nil {
+		return "", nil, err
+	}
+
+	plain := list == CustomListBlock && scope.Match == MatchSubtree && scope.Expires == nil &&
+		scope.Schedule == nil && len(scope.Clients) == 0 && len(scope.Groups) == 0
+	if plain {
+		return list, nil, nil
+	}
+	return list, scope, nil
+}
+
+// parseExpiry reads a date (valid until the end of that day, local time) or
+// an RFC 3339 time
+func parseExpiry(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expires %q (expected YYYY-MM-DD or RFC 3339)", value)
+}
+
+// encodeScope returns the stored form of a scope
+func encodeScope(scope *EntryScope) string {
+	if scope == nil {
+		return ""
+	}
+	data, _ := json.Marshal(scope)
+	return string(data)
+}
+
+func decodeScope(options string) (*EntryScope, error) {
+	if options == "" {
+		return nil, nil
+	}
+	var scope EntryScope
+	if err := json.Unmarshal([]byte(options), &scope); err != nil {
+		return nil, err
+	}
+	return &scope, nil
+}
+
+func compileCustomRule(domain, list string, scope EntryScope) (*customRule, error) {
+	rule := &customRule{domain: domain, list: list, scope: scope}
+
+	for _, client := range scope.Clients {
+		network, err := parseClientNetwork(client)
+		if err != nil {
+			return nil, err
+		}
+		rule.networks = append(rule.networks, network)
+	}
+
+	if s := scope.Schedule; s != nil {
+		var err error
+		if rule.window, err = parseTimeWindow(s.Days, s.Start, s.End); err != nil {
+			return nil, err
+		}
+	}
+
+	if list == CustomListRewrite {
+		policy, err := rewritePolicy(scope.Rewrite)
+		if err != nil {
+			return nil, err
+		}
+		rule.rewrite = policy
+	}
+	return rule, nil
+}
+
+// rewritePolicy answers with an address, or with a CNAME to a host name
+func rewritePolicy(target string) (*RPZPolicy, error) {
+	hdr := dns.RR_Header{Name: ".", Class: dns.ClassINET, Ttl: rewriteTTL}
+
+	var rr dns.RR
+	if ip := net.ParseIP(target); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			hdr.Rrtype = dns.TypeA
+			rr = &dns.A{Hdr: hdr, A: ip4}
+		} else {
+			hdr.Rrtype = dns.TypeAAAA
+			rr = &dns.AAAA{Hdr: hdr, AAAA: ip}
+		}
+	} else {
+		if _, ok := dns.IsDomainName(target); !ok || target == "" {
+			return nil, fmt.Errorf("invalid rewrite target %q", target)
+		}
+		hdr.Rrtype = dns.TypeCNAME
+		rr = &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(target)}
+	}
+
+	return &RPZPolicy{
+		Zone:      customSourceName,
+		Trigger:   target,
+		Action:    RPZActionLocalData,
+		LocalData: []dns.RR{rr},
+		reason:    "rewrite:" + target,
+	}, nil
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseClientNetwork reads an IP address or a CIDR
+func parseClientNetwork(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client %q", value)
+		}
+		return network, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid client %q", value)
+	}
+	bits := 128
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// covers reports whether the rule applies to a name that is depth labels
+// below the domain of the rule
+func (r *customRule) covers(depth int) bool {
+	switch r.scope.Match {
+	case MatchExact:
+		return depth == 0
+	case MatchWildcard:
+		return depth > 0
+	}
+	return true
+}
+
+// activeAt reports whether the expiry and schedule of the rule allow it at now
+func (r *customRule) activeAt(now time.Time) bool {
+	if r.scope.Expires != nil && !now.Before(*r.scope.Expires) {
+		return false
+	}
+	return r.scope.Schedule == nil || r.window.contains(now)
+}
+
+// appliesTo reports whether the client restrictions of the rule include ip
+func (r *customRule) appliesTo(ip net.IP, groups map[string][]*net.IPNet) bool {
+	if len(r.networks) == 0 && len(r.scope.Groups) == 0 {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, network := range r.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	for _, group := range r.scope.Groups {
+		for _, network := range groups[group] {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchScopedRule returns the first active scoped rule of list that covers
+// domain for clientIP, checking the name before its parents
+func (e *Engine) matchScopedRule(domain, clientIP, list string) *customRule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.scopedRules) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	ip := net.ParseIP(clientIP)
+	name := domain
+	for depth := 0; ; depth++ {
+		for _, rule := range e.scopedRules[name] {
+			if rule.list == list && rule.covers(depth) && rule.activeAt(now) && rule.appliesTo(ip, e.clientGroups) {
+				return rule
+			}
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return nil
+		}
+		name = name[i+1:]
+	}
+}
+
+// CheckRewrite returns the answer of a custom rewrite entry for domain and
+// clientIP, or the one that enforces SafeSearch, or nil
+func (e *Engine) CheckRewrite(domain, clientIP string) *RPZPolicy {
+	domain = normalizeDomain(domain)
+	if domain == "" {
+		return nil
+	}
+	if rule := e.matchScopedRule(domain, clientIP, CustomListRewrite); rule != nil {
+		return rule.rewrite
+	}
+	return e.checkSafeSearch(domain, clientIP)
+}
+
+// IsCacheable reports whether answers for domain may be served from the DNS
+// cache to clientIP. Names covered by scoped entries or temporary overrides
+// depend on the client or the time, and walled garden clients, clients
+// under an active schedule rule or a time budget and clients covered by a
+// ClientStage get their own answers.
+func (e *Engine) IsCacheable(domain, clientIP string) bool {
+	if e.inWalledGarden(clientIP) || e.scheduleCovers(clientIP) || e.budgetApplies(clientIP) || e.stageCovers(clientIP) {
+		return false
+	}
+	domain = normalizeDomain(domain)
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.hasOverride(domain) {
+		return false
+	}
+	if len(e.scopedRules) == 0 {
+		return true
+	}
+	for name := domain; ; {
+		if len(e.scopedRules[name]) > 0 {
+			return false
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return true
+		}
+		name = name[i+1:]
+	}
+}
+
+// loadClientGroups compiles the client groups from the config
+func loadClientGroups(groups []config.ClientGroup) (map[string][]*net.IPNet, error) {
+	compiled := make(map[string][]*net.IPNet)
+	for _, group := range groups {
+		if group.Name == "" {
+			return nil, fmt.Errorf("client group without a name")
+		}
+		for _, client := range group.Clients {
+			network, err := parseClientNetwork(client)
+			if err != nil {
+				return nil, fmt.Errorf("group %s: %v", group.Name, err)
+			}
+			compiled[group.Name] = append(compiled[group.Name], network)
+		}
+	}
+	return compiled, nil
+}
@@ -0,0 +1,103 @@
+package filter
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestScopeFromEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    CustomBlocklistEntry
+		wantList string
+		scoped   bool
+		wantErr  bool
+	}{
+		{"plain block", CustomBlocklistEntry{Domain: "a.com"}, CustomListBlock, false, false},
+		{"exact block", CustomBlocklistEntry{Domain: "a.com", Match: "EXACT"}, CustomListBlock, true, false},
+		{"allow", CustomBlocklistEntry{Domain: "a.com", Action: "allow"}, CustomListAllow, true, false},
+		{"rewrite to an address", CustomBlocklistEntry{Domain: "a.com", Action: "rewrite", Rewrite: "10.0.0.1"}, CustomListRewrite, true, false},
+		{"rewrite to a name", CustomBlocklistEntry{Domain: "a.com", Action: "rewrite", Rewrite: "safe.example.net."}, CustomListRewrite, true, false},
+		{"rewrite without target", CustomBlocklistEntry{Domain: "a.com", Action: "rewrite"}, "", false, true},
+		{"unknown action", CustomBlocklistEntry{Domain: "a.com", Action: "drop"}, "", false, true},
+		{"unknown match", CustomBlocklistEntry{Domain: "a.com", Match: "prefix"}, "", false, true},
+		{"group", CustomBlocklistEntry{Domain: "a.com", Groups: []string{"kids"}}, CustomListBlock, true, false},
+		{"bad client", CustomBlocklistEntry{Domain: "a.com", Clients: []string{"10.0.0.300"}}, "", false, true},
+		{"date expiry", CustomBlocklistEntry{Domain: "a.com", Expires: "2030-01-31"}, CustomListBlock, true, false},
+		{"bad expiry", CustomBlocklistEntry{Domain: "a.com", Expires: "tomorrow"}, "", false, true},
+		{"bad schedule", CustomBlocklistEntry{Domain: "a.com", Schedule: &EntrySchedule{Days: []string{"funday"}, Start: "08:00", End: "09:00"}}, "", false, true},
+	}
+	for _, tt := range tests {
+		list, scope, err := scopeFromEntry(tt.entry)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if list != tt.wantList || (scope != nil) != tt.scoped {
+			t.Errorf("%s: list %s scoped %v, want %s scoped %v", tt.name, list, scope != nil, tt.wantList, tt.scoped)
+		}
+		if scope != nil {
+			decoded, err := decodeScope(encodeScope(scope))
+			if err != nil || decoded.Match != scope.Match || decoded.Rewrite != scope.Rewrite {
+				t.Errorf("%s: scope does not survive storage: %+v, %v", tt.name, decoded, err)
+			}
+		}
+	}
+}
+
+func TestMatchScopedRule(t *testing.T) {
+	_, kids, _ := net.ParseCIDR("192.168.1.64/28")
+	past := time.Now().Add(-time.Hour)
+
+	rules := []struct {
+		domain, list string
+		scope        EntryScope
+	}{
+		// Two entries for one domain and list that differ only in scope
+		{"discord.com", CustomListBlock, EntryScope{Match: MatchSubtree, Groups: []string{"kids"}}},
+		{"discord.com", CustomListBlock, EntryScope{Match: MatchSubtree, Clients: []string{"10.0.0.5"}}},
+		{"exact.com", CustomListBlock, EntryScope{Match: MatchExact}},
+		{"wild.com", CustomListBlock, EntryScope{Match: MatchWildcard}},
+		{"expired.com", CustomListBlock, EntryScope{Match: MatchSubtree, Expires: &past}},
+		{"allowed.com", CustomListAllow, EntryScope{Match: MatchSubtree}},
+	}
+
+	e := &Engine{
+		scopedRules:  make(map[string][]*customRule),
+		clientGroups: map[string][]*net.IPNet{"kids": {kids}},
+	}
+	for _, r := range rules {
+		rule, err := compileCustomRule(r.domain, r.list, r.scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.scopedRules[r.domain] = append(e.scopedRules[r.domain], rule)
+	}
+
+	tests := []struct {
+		domain, client, list string
+		want                 bool
+	}{
+		{"discord.com", "192.168.1.70", CustomListBlock, true},
+		{"cdn.discord.com", "192.168.1.70", CustomListBlock, true},
+		{"discord.com", "10.0.0.5", CustomListBlock, true},
+		{"discord.com", "10.0.0.6", CustomListBlock, false},
+		{"discord.com", "", CustomListBlock, false},
+		{"exact.com", "10.0.0.6", CustomListBlock, true},
+		{"www.exact.com", "10.0.0.6", CustomListBlock, false},
+		{"wild.com", "10.0.0.6", CustomListBlock, false},
+		{"a.b.wild.com", "10.0.0.6", CustomListBlock, true},
+		{"expired.com", "10.0.0.6", CustomListBlock, false},
+		{"allowed.com", "10.0.0.6", CustomListBlock, false},
+		{"www.allowed.com", "10.0.0.6", CustomListAllow, true},
+	}
+	for _, tt := range tests {
+		if got := e.matchScopedRule(tt.domain, tt.client, tt.list) != nil; got != tt.want {
+			t.Errorf("matchScopedRule(%s, %q, %s) = %v, want %v", tt.domain, tt.client, tt.list, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package filter
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/database"
+)
+
+// ─── Shadow Mode ──────────────────────────────────────────────────────────────
+//
+// A blocklist source, category, keyword list or the AI stage can run in
+// shadow: it is evaluated on every query but never changes the answer, and
+// what it would have blocked is logged and counted. Counts are kept per stage
+// and domain together with how many of those queries were blocked anyway, so
+// a report can show what enabling the stage would add to the live blocks.
+
+const (
+	shadowKeepDays  = 30
+	shadowTopLimit  = 20
+	maxShadowCounts = 10000 // distinct stage and domain pairs between flushes
+)
+
+// shadowCount is what one shadow stage would have blocked for one domain
+// since the last flush
+type shadowCount struct {
+	hits        int64
+	liveBlocked int64
+	lastSeen    time.Time
+}
+
+// ShadowStage is the report of one shadow stage
+type ShadowStage struct {
+	Stage       string         `json:"stage"`
+	WouldBlock  int64          `json:"would_block"`
+	AlreadyLive int64          `json:"already_blocked"` // blocked by a live stage anyway
+	NewBlocks   int64          `json:"new_blocks"`      // would only be blocked by this stage
+	Domains     int            `json:"domains"`
+	TopDomains  []ShadowDomain `json:"top_domains"`
+}
+
+// ShadowDomain is a domain a shadow stage would block
+type ShadowDomain struct {
+	Domain      string    `json:"domain"`
+	Hits        int64     `json:"hits"`
+	LiveBlocked int64     `json:"already_blocked"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// ShadowReport compares what the shadow stages would block with the live
+// blocks over the same days
+type ShadowReport struct {
+	Since       time.Time              `json:"since"`
+	Stages      []ShadowStage          `json:"stages"`
+	LiveBlocked int64                  `json:"live_blocked"`
+	LiveTop     []database.DomainCount `json:"live_top_domains"`
+}
+
+// shadowCategory reports whether category runs in shadow
+func (e *Engine) shadowCategory(category string) bool {
+	for _, c := range e.cfg.Filtering.Shadow.Categories {
+		if strings.EqualFold(c, category) {
+			return true
+		}
+	}
+	return false
+}
+
+// shadowKeywordLists returns the keyword lists that run in shadow, or nil
+func (e *Engine) shadowKeywordLists() map[string]bool {
+	lists := e.cfg.Filtering.Shadow.KeywordLists
+	if len(lists) == 0 {
+		return nil
+	}
+	skip := make(map[string]bool, len(lists))
+	for _, list := range lists {
+		skip[list] = true
+	}
+	return skip
+}
+
+// liveSources drops the shadow sources from the sources that list a domain.
+// It reports false when only shadow sources list it.
+func (e *Engine) liveSources(sources []string) ([]string, bool) {
+	if len(sources) == 0 {
+		return sources, true
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	live := sources[:0:0]
+	for _, name := range sources {
+		if !e.isShadowSource(name) {
+			live = append(live, name)
+		}
+	}
+	return live, len(live) > 0
+}
+
+// isShadowSource reports whether a source runs in shadow. Caller holds e.mu.
+func (e *Engine) isShadowSource(name string) bool {
+	for _, source := range e.sources {
+		if source.Name == name {
+			return source.Shadow
+		}
+	}
+	return false
+}
+
+// hasShadowSources reports whether any enabled source runs in shadow
+func (e *Engine) hasShadowSources() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, source := range e.sources {
+		if source.Enabled && source.Shadow {
+			return true
+		}
+	}
+	return false
+}
+
+// shadowMatches runs the shadow stages for domain and returns the ones that
+// would block it, as "source:<name>", "rpz:<zone>", "category:<id>",
+// "keyword:<list>" or "ai"
+func (e *Engine) shadowMatches(domain string) []string {
+	cfg := e.cfg.Filtering.Shadow
+	var stages []string
+
+	if e.hasShadowSources() {
+		seen := make(map[string]bool)
+		e.mu.RLock()
+		for name := domain; ; {
+			for _, source := range e.blockedDomains[name] {
+				if !seen[source] && e.isShadowSource(source) {
+					seen[source] = true
+					stages = append(stages, "source:"+source)
+				}
+			}
+			i := strings.Index(name, ".")
+			if i < 0 {
+				break
+			}
+			name = name[i+1:]
+		}
+		for _, zone := range e.rpzZonesInOrder(true) {
+			if p := zone.matchName(domain); p != nil && p.Action != RPZActionPassthru {
+				stages = append(stages, "rpz:"+zone.name)
+			}
+		}
+		e.mu.RUnlock()
+	}
+
+	if len(cfg.Categories) > 0 && e.categoryMgr != nil {
+		if category := e.categoryMgr.Lookup(domain); category != "" && e.shadowCategory(category) {
+			stages = append(stages, "category:"+category)
+		}
+	}
+
+	if e.keywordMgr != nil {
+		for _, list := range cfg.KeywordLists {
+			if len(e.keywordMgr.MatchList(list, domain)) > 0 {
+				stages = append(stages, "keyword:"+list)
+			}
+		}
+	}
+
+	if cfg.AI && e.aiBlocker != nil {
+		result := e.aiBlocker.Predict(domain)
+		blocked := result.Blocked
+		if cfg.AIThreshold > 0 {
+			blocked = result.Category != "safe" && result.Confidence >= cfg.AIThreshold
+		}
+		if blocked {
+			stages = append(stages, "ai")
+		}
+	}
+
+	return stages
+}
+
+// recordShadow counts and logs what the shadow stages would block for a
+// query that the live stages blocked or left alone. Queries a rule allowed
+// explicitly are skipped: no shadow stage would block them once live either.
+func (e *Engine) recordShadow(domain, clientIP string, blocked bool, reason string) {
+	if !blocked && reason != "" {
+		return
+	}
+	stages := e.shadowMatches(domain)
+	if len(stages) == 0 {
+		return
+	}
+
+	if !blocked {
+		e.log.Infof("👁️  WOULD BLOCK: %s from %s (shadow: %s)", domain, clientIP, strings.Join(stages, ", "))
+	}
+
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, stage := range stages {
+		key := stage + "\x00" + domain
+		c, ok := e.shadowCounts[key]
+		if !ok {
+			if len(e.shadowCounts) >= maxShadowCounts {
+				continue
+			}
+			c = &shadowCount{}
+			e.shadowCounts[key] = c
+		}
+		c.hits++
+		if blocked {
+			c.liveBlocked++
+		}
+		c.lastSeen = now
+	}
+}
+
+// flushShadowCounts adds the counts since the last flush to the database
+func (e *Engine) flushShadowCounts() {
+	e.mu.Lock()
+	counts := e.shadowCounts
+	e.shadowCounts = make(map[string]*shadowCount)
+	e.mu.Unlock()
+
+	if len(counts) == 0 {
+		return
+	}
+
+	day := time.Now().Format("2006-01-02")
+	hits := make([]database.ShadowHit, 0, len(counts))
+	for key, c := range counts {
+		stage, domain, _ := strings.Cut(key, "\x00")
+		hits = append(hits, database.ShadowHit{
+			Stage:       stage,
+			Domain:      domain,
+			Day:         day,
+			Hits:        c.hits,
+			LiveBlocked: c.liveBlocked,
+			LastSeen:    c.lastSeen,
+		})
+	}
+	if err := e.db.AddShadowHits(hits); err != nil {
+		e.log.Warnf("Failed to save shadow counts: %v", err)
+	}
+	oldest := time.Now().AddDate(0, 0, -shadowKeepDays).Format("2006-01-02")
+	if err := e.db.DeleteShadowHitsBefore(oldest); err != nil {
+		e.log.Warnf("Failed to delete old shadow counts: %v", err)
+	}
+}
+
+// GetShadowReport compares the shadow stages with the live blocks over the
+// last days, today included
+func (e *Engine) GetShadowReport(days int) (*ShadowReport, error) {
+	if days < 1 {
+		days = 1
+	}
+	e.flushShadowCounts()
+
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1-days)
+	hits, err := e.db.LoadShadowHits(since.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+
+	stages := make(map[string]*ShadowStage)
+	for _, h := range hits {
+		s, ok := stages[h.Stage]
+		if !ok {
+			s = &ShadowStage{Stage: h.Stage}
+			stages[h.Stage] = s
+		}
+		s.WouldBlock += h.Hits
+		s.AlreadyLive += h.LiveBlocked
+		s.Domains++
+		s.TopDomains = append(s.TopDomains, ShadowDomain{
+			Domain:      h.Domain,
+			Hits:        h.Hits,
+			LiveBlocked: h.LiveBlocked,
+			LastSeen:    h.LastSeen,
+		})
+	}
+
+	report := &ShadowReport{Since: since, Stages: make([]ShadowStage, 0, len(stages))}
+	for _, s := range stages {
+		s.NewBlocks = s.WouldBlock - s.AlreadyLive
+		sort.Slice(s.TopDomains, func(i, j int) bool { return s.TopDomains[i].Hits > s.TopDomains[j].Hits })
+		if len(s.TopDomains) > shadowTopLimit {
+			s.TopDomains = s.TopDomains[:shadowTopLimit]
+		}
+		report.Stages = append(report.Stages, *s)
+	}
+	sort.Slice(report.Stages, func(i, j int) bool { return report.Stages[i].Stage < report.Stages[j].Stage })
+
+	report.LiveBlocked, report.LiveTop, err = e.db.GetBlockedSince(since, shadowTopLimit)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,718 @@
+package filter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+	"github.com/RDXFGXY1/dns-filter-app/internal/database"
+)
+
+// ─── Blocklist Sources ────────────────────────────────────────────────────────
+
+const (
+	// customSourceName attributes domains from custom YAML blocklists
+	customSourceName = "custom"
+
+	SourceOriginConfig = "config"
+	SourceOriginAPI    = "api"
+
+	defaultPreviewLimit = 20
+	maxPreviewLimit     = 1000
+)
+
+// SourceStatus describes the last fetch of a blocklist source
+type SourceStatus struct {
+	Name         string    `json:"name"`
+	URL          string    `json:"url"`
+	Category     string    `json:"category"`
+	Format       string    `json:"format"`
+	Detected     string    `json:"detected_format"`
+	Zone         string    `json:"zone,omitempty"`
+	Origin       string    `json:"origin"`
+	Enabled      bool      `json:"enabled"`
+	Shadow       bool      `json:"shadow"`
+	Interval     int       `json:"update_interval"`
+	Verification string    `json:"verification,omitempty"`
+	Verified     bool      `json:"verified"`
+	DomainCount  int       `json:"domain_count"`
+	Lines        int       `json:"lines"`
+	ParseErrors  int       `json:"parse_errors"`
+	Hits         uint64    `json:"hits"`
+	ErrorSamples []string  `json:"error_samples,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Checksum     string    `json:"checksum,omitempty"`
+	LastChecked  time.Time `json:"last_checked"`
+	LastUpdated  time.Time `json:"last_updated"`
+}
+
+// sourceFetch is the outcome of one conditional fetch. result is nil when
+// the content is unchanged since the previous fetch.
+type sourceFetch struct {
+	result       *parseResult
+	etag         string
+	lastModified string
+	checksum     string
+}
+
+// fetchBlocklist downloads a source unless it is unchanged. The validators of
+// the previous fetch are only sent while its domains are still in memory.
+func (e *Engine) fetchBlocklist(source config.BlocklistSource) (*sourceFetch, error) {
+	format := source.Format
+	if format == "" {
+		format = FormatAuto
+	}
+
+	var prev SourceStatus
+	e.mu.RLock()
+	if status, ok := e.sourceStatus[source.Name]; ok {
+		prev = *status
+	}
+	_, cached := e.sourceDomains[source.Name]
+	e.mu.RUnlock()
+
+	reusable := cached && prev.URL == source.URL && prev.Format == format
+	if !reusable {
+		prev = SourceStatus{}
+	}
+
+	body, header, err := e.openBlocklistIfModified(source.URL, prev.ETag, prev.LastModified)
+	if err == errNotModified {
+		return &sourceFetch{etag: prev.ETag, lastModified: prev.LastModified, checksum: prev.Checksum}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.verifyContent(source, data); err != nil {
+		return nil, &verifyError{err}
+	}
+
+	sum := sha256.Sum256(data)
+	fetch := &sourceFetch{checksum: hex.EncodeToString(sum[:])}
+	if header != nil {
+		fetch.etag = header.Get("ETag")
+		fetch.lastModified = header.Get("Last-Modified")
+	}
+	if reusable && fetch.checksum == prev.Checksum {
+		return fetch, nil
+	}
+
+	result, err := parseBlocklist(bytes.NewReader(data), source.Format)
+	if err != nil {
+		return nil, err
+	}
+	fetch.result = result
+	return fetch, nil
+}
+
+func (e *Engine) recordSourceStatus(source config.BlocklistSource, fetch *sourceFetch, fetchErr error) {
+	now := time.Now()
+
+	e.mu.Lock()
+	status, ok := e.sourceStatus[source.Name]
+	if !ok {
+		status = &SourceStatus{Name: source.Name}
+		e.sourceStatus[source.Name] = status
+	}
+
+	status.URL = source.URL
+	status.Category = source.Category
+	status.Format = source.Format
+	if status.Format == "" {
+		status.Format = FormatAuto
+	}
+	status.Enabled = source.Enabled
+	status.LastChecked = now
+
+	status.Verification = verificationMethod(source)
+
+	if fetchErr != nil {
+		status.LastError = fetchErr.Error()
+		if _, failed := fetchErr.(*verifyError); failed {
+			status.Verified = false
+		}
+	} else {
+		status.LastError = ""
+		status.Verified = status.Verification != VerifyNone
+		status.ETag = fetch.etag
+		status.LastModified = fetch.lastModified
+		status.Checksum = fetch.checksum
+
+		if result := fetch.result; result != nil {
+			status.Detected = result.Format
+			status.DomainCount = len(result.Domains) + result.Rules
+			status.Lines = result.Lines
+			status.ParseErrors = result.ParseErrors
+			status.ErrorSamples = result.ErrorSamples
+			status.LastUpdated = now
+		}
+	}
+	saved := *status
+	e.mu.Unlock()
+
+	e.saveSourceStatus(saved)
+}
+
+func (e *Engine) saveSourceStatus(status SourceStatus) {
+	err := e.db.SaveBlocklistSource(database.BlocklistSource{
+		Name:         status.Name,
+		URL:          status.URL,
+		Category:     status.Category,
+		Format:       status.Format,
+		Enabled:      status.Enabled,
+		ETag:         status.ETag,
+		LastModified: status.LastModified,
+		Checksum:     status.Checksum,
+		DomainCount:  status.DomainCount,
+		ParseErrors:  status.ParseErrors,
+		LastError:    status.LastError,
+		LastChecked:  status.LastChecked,
+		LastUpdated:  status.LastUpdated,
+	})
+	if err != nil {
+		e.log.Warnf("Failed to save state of source %s: %v", status.Name, err)
+	}
+}
+
+// loadSources restores the state of every source from the database, adds the
+// sources created through the API and applies enabled overrides to the ones
+// from the config
+func (e *Engine) loadSources() error {
+	rows, err := e.db.LoadBlocklistSources()
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.sources = append([]config.BlocklistSource(nil), e.cfg.Blocklists.Sources...)
+	index := make(map[string]int)
+	for i, source := range e.sources {
+		index[source.Name] = i
+	}
+
+	for _, src := range rows {
+		e.sourceStatus[src.Name] = &SourceStatus{
+			Name:         src.Name,
+			URL:          src.URL,
+			Category:     src.Category,
+			Format:       src.Format,
+			Enabled:      src.Enabled,
+			DomainCount:  src.DomainCount,
+			ParseErrors:  src.ParseErrors,
+			LastError:    src.LastError,
+			ETag:         src.ETag,
+			LastModified: src.LastModified,
+			Checksum:     src.Checksum,
+			LastChecked:  src.LastChecked,
+			LastUpdated:  src.LastUpdated,
+		}
+
+		counter := new(uint64)
+		*counter = uint64(src.Hits)
+		e.sourceHits[src.Name] = counter
+
+		if i, ok := index[src.Name]; ok {
+			if src.EnabledOverride != nil {
+				e.sources[i].Enabled = *src.EnabledOverride
+			}
+			continue
+		}
+		if src.Origin == SourceOriginAPI {
+			e.sources = append(e.sources, config.BlocklistSource{
+				Name:           src.Name,
+				URL:            src.URL,
+				Category:       src.Category,
+				Format:         src.Format,
+				Zone:           src.Zone,
+				Enabled:        src.Enabled,
+				UpdateInterval: src.UpdateInterval,
+				SHA256:         src.SHA256,
+				ChecksumURL:    src.ChecksumURL,
+				SignatureURL:   src.SignatureURL,
+				PublicKey:      src.PublicKey,
+				Shadow:         src.Shadow,
+			})
+			e.apiSources[src.Name] = true
+		}
+	}
+	return nil
+}
+
+// getSources returns a copy of the current source list
+func (e *Engine) getSources() []config.BlocklistSource {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return append([]config.BlocklistSource(nil), e.sources...)
+}
+
+// dueSources names the enabled sources whose update interval has passed.
+// Sources without an interval of their own use def; if that is zero too
+// they are only updated on demand.
+func (e *Engine) dueSources(def time.Duration) map[string]bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	due := make(map[string]bool)
+	for _, source := range e.sources {
+		if !source.Enabled {
+			continue
+		}
+		interval := def
+		if source.UpdateInterval > 0 {
+			interval = time.Duration(source.UpdateInterval) * time.Hour
+		}
+		if interval <= 0 {
+			continue
+		}
+		status, ok := e.sourceStatus[source.Name]
+		if !ok || time.Since(status.LastChecked) >= interval {
+			due[source.Name] = true
+		}
+	}
+	return due
+}
+
+// GetSourceStatus lists every source with its last fetch outcome
+func (e *Engine) GetSourceStatus() []SourceStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	list := make([]SourceStatus, 0, len(e.sources))
+	for _, source := range e.sources {
+		status := SourceStatus{Name: source.Name}
+		if known, ok := e.sourceStatus[source.Name]; ok {
+			status = *known
+		}
+		status.URL = source.URL
+		status.Category = source.Category
+		status.Format = source.Format
+		if status.Format == "" {
+			status.Format = FormatAuto
+		}
+		status.Zone = source.Zone
+		status.Enabled = source.Enabled
+		status.Shadow = source.Shadow
+		status.Interval = source.UpdateInterval
+		status.Verification = verificationMethod(source)
+		status.Origin = SourceOriginConfig
+		if e.apiSources[source.Name] {
+			status.Origin = SourceOriginAPI
+		}
+		if counter, ok := e.sourceHits[source.Name]; ok {
+			status.Hits = atomic.LoadUint64(counter)
+		}
+		list = append(list, status)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// ─── Runtime Management ───────────────────────────────────────────────────────
+//
+// These methods only change the source list; call RebuildBlocklist afterwards
+// to merge the result. Sources from config.yaml can be enabled or disabled but
+// are otherwise edited in the file.
+
+// GetSource returns the source with the given name
+func (e *Engine) GetSource(name string) (config.BlocklistSource, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, source := range e.sources {
+		if source.Name == name {
+			return source, true
+		}
+	}
+	return config.BlocklistSource{}, false
+}
+
+// ValidateSource checks the fields of a source without storing it
+func ValidateSource(source config.BlocklistSource) error {
+	if strings.TrimSpace(source.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if source.Name == customSourceName {
+		return fmt.Errorf("name %q is reserved", customSourceName)
+	}
+
+	switch {
+	case strings.HasPrefix(source.URL, "http://"), strings.HasPrefix(source.URL, "https://"),
+		strings.HasPrefix(source.URL, "file://"):
+	case strings.HasPrefix(source.URL, "axfr://"):
+		if source.Zone == "" {
+			return fmt.Errorf("zone is required for zone transfers")
+		}
+	default:
+		return fmt.Errorf("url must start with http://, https://, file:// or axfr://")
+	}
+
+	if source.Format != "" && source.Format != FormatAuto {
+		if _, ok := newListParser(source.Format); !ok {
+			return fmt.Errorf("unknown format %q", source.Format)
+		}
+	}
+	if source.UpdateInterval < 0 {
+		return fmt.Errorf("update_interval cannot be negative")
+	}
+
+	if source.SHA256 != "" {
+		if b, err := hex.DecodeString(strings.TrimSpace(source.SHA256)); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("sha256 must be 64 hex characters")
+		}
+	}
+	if source.SignatureURL != "" {
+		if _, _, err := parsePublicKey(source.PublicKey); err != nil {
+			return fmt.Errorf("signature_url needs a valid public_key: %v", err)
+		}
+	}
+	if strings.HasPrefix(source.URL, "axfr://") && verificationMethod(source) != VerifyNone {
+		return fmt.Errorf("zone transfers cannot be verified")
+	}
+	return nil
+}
+
+// checkAPISource refuses sources from the API that read local files or
+// transfer zones, unless blocklists.allow_local_sources is set. A preview
+// returns what it read, so such a source would expose any readable file.
+func (e *Engine) checkAPISource(source config.BlocklistSource) error {
+	if e.cfg.Blocklists.AllowLocalSources {
+		return nil
+	}
+	for _, u := range []string{source.URL, source.ChecksumURL, source.SignatureURL} {
+		if u != "" && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return fmt.Errorf("only http:// and https:// URLs can be used through the API, set blocklists.allow_local_sources to allow %q", u)
+		}
+	}
+	return nil
+}
+
+// AddSource stores a new source
+func (e *Engine) AddSource(source config.BlocklistSource) error {
+	source.Name = strings.TrimSpace(source.Name)
+	if err := ValidateSource(source); err != nil {
+		return err
+	}
+	if err := e.checkAPISource(source); err != nil {
+		return err
+	}
+	if _, exists := e.GetSource(source.Name); exists {
+		return fmt.Errorf("source %q already exists", source.Name)
+	}
+
+	err := e.db.AddBlocklistSource(database.BlocklistSource{
+		Name:           source.Name,
+		URL:            source.URL,
+		Category:       source.Category,
+		Format:         source.Format,
+		Zone:           source.Zone,
+		UpdateInterval: source.UpdateInterval,
+		Enabled:        source.Enabled,
+		SHA256:         source.SHA256,
+		ChecksumURL:    source.ChecksumURL,
+		SignatureURL:   source.SignatureURL,
+		PublicKey:      source.PublicKey,
+		Shadow:         source.Shadow,
+	})
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	e.sources = append(e.sources, source)
+	e.apiSources[source.Name] = true
+	e.mu.Unlock()
+
+	e.log.Infof("Added blocklist source %s (%s)", source.Name, source.URL)
+	return nil
+}
+
+// UpdateSource replaces the definition of a source. Changing the URL, format,
+// zone or verification drops its content so the next rebuild downloads it again.
+func (e *Engine) UpdateSource(name string, source config.BlocklistSource) error {
+	current, ok := e.GetSource(name)
+	if !ok {
+		return fmt.Errorf("source %q not found", name)
+	}
+	source.Name = name
+
+	e.mu.RLock()
+	managed := e.apiSources[name]
+	e.mu.RUnlock()
+
+	if !managed {
+		onlyEnabled := current
+		onlyEnabled.Enabled = source.Enabled
+		if onlyEnabled != source {
+			return fmt.Errorf("source %q is defined in config.yaml, only enabled can be changed", name)
+		}
+		return e.SetSourceEnabled(name, source.Enabled)
+	}
+
+	if err := ValidateSource(source); err != nil {
+		return err
+	}
+	if err := e.checkAPISource(source); err != nil {
+		return err
+	}
+
+	err := e.db.UpdateBlocklistSource(database.BlocklistSource{
+		Name:           source.Name,
+		URL:            source.URL,
+		Category:       source.Category,
+		Format:         source.Format,
+		Zone:           source.Zone,
+		UpdateInterval: source.UpdateInterval,
+		Enabled:        source.Enabled,
+		SHA256:         source.SHA256,
+		ChecksumURL:    source.ChecksumURL,
+		SignatureURL:   source.SignatureURL,
+		PublicKey:      source.PublicKey,
+		Shadow:         source.Shadow,
+	})
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	for i := range e.sources {
+		if e.sources[i].Name == name {
+			e.sources[i] = source
+		}
+	}
+	if source.URL != current.URL || source.Format != current.Format || source.Zone != current.Zone ||
+		source.SHA256 != current.SHA256 || source.ChecksumURL != current.ChecksumURL ||
+		source.SignatureURL != current.SignatureURL || source.PublicKey != current.PublicKey {
+		delete(e.sourceDomains, name)
+		delete(e.rpzZones, name)
+	}
+	e.mu.Unlock()
+
+	e.log.Infof("Updated blocklist source %s", name)
+	return nil
+}
+
+// SetSourceEnabled enables or disables a source
+func (e *Engine) SetSourceEnabled(name string, enabled bool) error {
+	source, ok := e.GetSource(name)
+	if !ok {
+		return fmt.Errorf("source %q not found", name)
+	}
+
+	if err := e.db.SetBlocklistSourceEnabled(name, source.URL, enabled); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	for i := range e.sources {
+		if e.sources[i].Name == name {
+			e.sources[i].Enabled = enabled
+		}
+	}
+	e.mu.Unlock()
+
+	e.log.Infof("Blocklist source %s enabled: %v", name, enabled)
+	return nil
+}
+
+// RemoveSource deletes a source added through the API
+func (e *Engine) RemoveSource(name string) error {
+	if _, ok := e.GetSource(name); !ok {
+		return fmt.Errorf("source %q not found", name)
+	}
+
+	e.mu.RLock()
+	managed := e.apiSources[name]
+	e.mu.RUnlock()
+	if !managed {
+		return fmt.Errorf("source %q is defined in config.yaml", name)
+	}
+
+	if err := e.db.DeleteBlocklistSource(name); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	for i := range e.sources {
+		if e.sources[i].Name == name {
+			e.sources = append(e.sources[:i], e.sources[i+1:]...)
+			break
+		}
+	}
+	delete(e.apiSources, name)
+	delete(e.sourceDomains, name)
+	delete(e.sourceStatus, name)
+	delete(e.sourceHits, name)
+	delete(e.rpzZones, name)
+	e.mu.Unlock()
+
+	e.log.Infof("Removed blocklist source %s", name)
+	return nil
+}
+
+// SourcePreview is the outcome of a test fetch
+type SourcePreview struct {
+	Format       string   `json:"format"`
+	DomainCount  int      `json:"domain_count"`
+	Lines        int      `json:"lines"`
+	ParseErrors  int      `json:"parse_errors"`
+	ErrorSamples []string `json:"error_samples,omitempty"`
+	Domains      []string `json:"domains"`
+}
+
+// PreviewSource fetches and parses a source without storing anything and
+// returns the first limit domains
+func (e *Engine) PreviewSource(source config.BlocklistSource, limit int) (*SourcePreview, error) {
+	if source.Name == "" {
+		source.Name = "preview"
+	}
+	if err := ValidateSource(source); err != nil {
+		return nil, err
+	}
+	// A source as it is configured may be previewed whatever its URL
+	if existing, ok := e.GetSource(source.Name); !ok || existing != source {
+		if err := e.checkAPISource(source); err != nil {
+			return nil, err
+		}
+	}
+	if limit <= 0 {
+		limit = defaultPreviewLimit
+	}
+	if limit > maxPreviewLimit {
+		limit = maxPreviewLimit
+	}
+
+	preview := &SourcePreview{}
+
+	if isRPZSource(source) {
+		zone, err := e.fetchRPZZone(source)
+		if err != nil {
+			return nil, err
+		}
+		var triggers []string
+		for name := range zone.exact {
+			triggers = append(triggers, name)
+		}
+		for name := range zone.wildcards {
+			triggers = append(triggers, "*."+name)
+		}
+		sort.Strings(triggers)
+
+		preview.Format = FormatRPZ
+		preview.DomainCount = zone.count()
+		preview.Lines = zone.count() + zone.skipped
+		preview.ParseErrors = zone.skipped
+		preview.Domains = triggers
+	} else {
+		body, err := e.openBlocklist(source.URL)
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// Verified as on a refresh, so a preview never shows a list that
+		// would be refused
+		if err := e.verifyContent(source, data); err != nil {
+			return nil, &verifyError{err}
+		}
+
+		result, err := parseBlocklist(bytes.NewReader(data), source.Format)
+		if err != nil {
+			return nil, err
+		}
+		preview.Format = result.Format
+		preview.DomainCount = len(result.Domains)
+		preview.Lines = result.Lines
+		preview.ParseErrors = result.ParseErrors
+		preview.ErrorSamples = result.ErrorSamples
+		preview.Domains = result.Domains
+	}
+
+	if len(preview.Domains) > limit {
+		preview.Domains = preview.Domains[:limit]
+	}
+	return preview, nil
+}
+
+// ─── Attribution ──────────────────────────────────────────────────────────────
+
+// countSourceHits counts a hit for every source listing a blocked domain
+func (e *Engine) countSourceHits(sources []string) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, name := range sources {
+		if counter, ok := e.sourceHits[name]; ok {
+			atomic.AddUint64(counter, 1)
+		}
+	}
+}
+
+// blocklistReason names the sources listing a domain in the block reason
+func blocklistReason(sources []string) string {
+	if len(sources) == 0 {
+		return "blocklist"
+	}
+	return "blocklist:" + strings.Join(sources, ",")
+}
+
+// ensureSourceCounters creates hit counters for every source named in
+// domains. Caller holds e.mu.
+func (e *Engine) ensureSourceCounters(domains map[string][]string) {
+	for _, sources := range domains {
+		for _, name := range sources {
+			if _, ok := e.sourceHits[name]; !ok {
+				e.sourceHits[name] = new(uint64)
+			}
+		}
+	}
+}
+
+func (e *Engine) flushSourceHits() {
+	hits := make(map[string]uint64)
+
+	e.mu.RLock()
+	for name, counter := range e.sourceHits {
+		hits[name] = atomic.LoadUint64(counter)
+	}
+	e.mu.RUnlock()
+
+	if len(hits) == 0 {
+		return
+	}
+	if err := e.db.SaveBlocklistSourceHits(hits); err != nil {
+		e.log.Warnf("Failed to save blocklist source hits: %v", err)
+	}
+}
+
+func equalSources(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,477 @@
+package filter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ─── Filter Stages ────────────────────────────────────────────────────────────
+//
+// ShouldBlock runs a pipeline of stages from the lowest priority up. Each stage
+// looks at the query and allows it, blocks it, rewrites it or lets the next
+// stage decide; the first stage that does not continue decides the query. The
+// subsystems of the engine are registered as built-in stages, the config can
+// move or turn off any stage by name, and RegisterStage adds new ones such as
+// content inspection or threat intelligence lookups.
+//
+// Custom rewrites, SafeSearch and response policy zones are answered by the
+// DNS server before the pipeline runs and are not stages.
+
+// Verdict is what a stage decides for a query
+type Verdict int
+
+const (
+	VerdictContinue Verdict = iota // let the next stage decide
+	VerdictAllow
+	VerdictBlock
+	VerdictRewrite
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case VerdictAllow:
+		return "allow"
+	case VerdictBlock:
+		return "block"
+	case VerdictRewrite:
+		return "rewrite"
+	}
+	return "continue"
+}
+
+// Priorities of the built-in stages. They leave room for stages in between.
+const (
+	PriorityOverride     = 100
+	PriorityDNSCanary    = 150
+	PriorityWalledGarden = 200
+	PriorityWhitelist    = 300
+	PriorityEncryptedDNS = 320
+	PriorityTunnel       = 350
+	PrioritySchedule     = 400
+	PriorityHomograph    = 450
+	PriorityCategory     = 500
+	PriorityKeyword      = 600
+	PriorityCustom       = 700
+	PriorityBlocklist    = 800
+	PriorityPattern      = 900
+	PriorityNewDomain    = 950
+	PriorityAI           = 1000
+	PriorityBudget       = 1100
+)
+
+// Query is the query a stage looks at. Domain is normalized. A DryRun query
+// only asks what the stage would decide: the stage must not count hits,
+// charge budgets, learn or log anything for it.
+type Query struct {
+	Domain   string
+	ClientIP string
+	DryRun   bool
+}
+
+// Result is the decision of a stage. Rewrite holds the answer for
+// VerdictRewrite.
+type Result struct {
+	Verdict Verdict
+	Reason  string
+	Rewrite *RPZPolicy
+}
+
+// Continue is the result of a stage with nothing to say about a query
+var Continue = Result{}
+
+// Stage is one step of the filter pipeline
+type Stage interface {
+	Name() string
+	Priority() int
+	Check(q *Query) Result
+}
+
+// ClientStage is implemented by stages whose decision depends on the client
+// or the time. Answers to the clients a stage covers are not cached.
+type ClientStage interface {
+	Stage
+	Covers(clientIP string) bool
+}
+
+// StageFunc turns a function into a stage
+type StageFunc struct {
+	StageName     string
+	StagePriority int
+	Func          func(q *Query) Result
+}
+
+func (s *StageFunc) Name() string          { return s.StageName }
+func (s *StageFunc) Priority() int         { return s.StagePriority }
+func (s *StageFunc) Check(q *Query) Result { return s.Func(q) }
+
+// builtinStage is a stage of the engine itself, which Explain can trace
+// without side effects
+type builtinStage struct {
+	StageFunc
+	explain func(x *explainer, q *Query)
+}
+
+// StageStatus describes a registered stage
+type StageStatus struct {
+	Name     string `json:"name"`
+	Priority int    `json:"priority"`
+	Enabled  bool   `json:"enabled"`
+	Builtin  bool   `json:"builtin"`
+}
+
+// registerBuiltinStages registers the subsystems of the engine as stages
+func (e *Engine) registerBuiltinStages() {
+	builtin := []*builtinStage{
+		{StageFunc{"override", PriorityOverride, e.overrideStage}, e.explainOverride},
+		{StageFunc{"dns-canary", PriorityDNSCanary, e.dnsCanaryStage}, e.explainDNSCanary},
+		{StageFunc{"walled-garden", PriorityWalledGarden, e.walledGardenStage}, e.explainWalledGarden},
+		{StageFunc{"whitelist", PriorityWhitelist, e.whitelistStage}, e.explainWhitelist},
+		{StageFunc{"encrypted-dns", PriorityEncryptedDNS, e.encryptedDNSStage}, e.explainEncryptedDNS},
+		{StageFunc{"tunnel", PriorityTunnel, e.tunnelStage}, e.explainTunnel},
+		{StageFunc{"schedule", PrioritySchedule, e.scheduleStage}, e.explainSchedule},
+		{StageFunc{"homograph", PriorityHomograph, e.homographStage}, e.explainHomograph},
+		{StageFunc{"category", PriorityCategory, e.categoryStage}, e.explainCategory},
+		{StageFunc{"keyword", PriorityKeyword, e.keywordStage}, e.explainKeywords},
+		{StageFunc{"custom", PriorityCustom, e.customStage}, e.explainCustom},
+		{StageFunc{"blocklist", PriorityBlocklist, e.blocklistStage}, e.explainBlocklist},
+		{StageFunc{"pattern", PriorityPattern, e.patternStage}, e.explainPattern},
+		{StageFunc{"new-domain", PriorityNewDomain, e.newDomainStage}, e.explainNewDomain},
+		{StageFunc{"ai", PriorityAI, e.aiStage}, e.explainAI},
+		{StageFunc{"budget", PriorityBudget, e.budgetStage}, e.explainBudget},
+	}
+	for _, s := range builtin {
+		if err := e.RegisterStage(s); err != nil {
+			e.log.Warnf("Failed to register stage %s: %v", s.Name(), err)
+		}
+	}
+}
+
+// RegisterStage adds a stage to the pipeline. The config can still move it
+// or turn it off by name.
+func (e *Engine) RegisterStage(s Stage) error {
+	name := s.Name()
+	if name == "" {
+		return fmt.Errorf("stage has no name")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, existing := range e.stages {
+		if existing.Name() == name {
+			return fmt.Errorf("stage %s is already registered", name)
+		}
+	}
+	e.stages = append(e.stages, s)
+	e.sortStages()
+	return nil
+}
+
+// stagePriority is the priority of a stage after the config. Caller holds e.mu.
+func (e *Engine) stagePriority(s Stage) int {
+	if c, ok := e.cfg.Filtering.Stages[s.Name()]; ok && c.Priority != 0 {
+		return c.Priority
+	}
+	return s.Priority()
+}
+
+// stageEnabled reports whether the config leaves a stage on
+func (e *Engine) stageEnabled(s Stage) bool {
+	return e.stageNameEnabled(s.Name())
+}
+
+func (e *Engine) stageNameEnabled(name string) bool {
+	c, ok := e.cfg.Filtering.Stages[name]
+	return !ok || c.Enabled == nil || *c.Enabled
+}
+
+// sortStages orders the pipeline by priority. Caller holds e.mu.
+func (e *Engine) sortStages() {
+	sort.SliceStable(e.stages, func(i, j int) bool {
+		return e.stagePriority(e.stages[i]) < e.stagePriority(e.stages[j])
+	})
+}
+
+// pipeline returns the enabled stages in the order they run
+func (e *Engine) pipeline() []Stage {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	stages := make([]Stage, 0, len(e.stages))
+	for _, s := range e.stages {
+		if e.stageEnabled(s) {
+			stages = append(stages, s)
+		}
+	}
+	return stages
+}
+
+// stageCovers reports whether an enabled ClientStage covers clientIP
+func (e *Engine) stageCovers(clientIP string) bool {
+	for _, s := range e.pipeline() {
+		if c, ok := s.(ClientStage); ok && c.Covers(clientIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetStages lists every registered stage in the order they run
+func (e *Engine) GetStages() []StageStatus {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	list := make([]StageStatus, 0, len(e.stages))
+	for _, s := range e.stages {
+		_, builtin := s.(*builtinStage)
+		list = append(list, StageStatus{
+			Name:     s.Name(),
+			Priority: e.stagePriority(s),
+			Enabled:  e.stageEnabled(s),
+			Builtin:  builtin,
+		})
+	}
+	return list
+}
+
+// warnUnknownStages reports stages named in the config that are not
+// registered, most likely misspelled
+func (e *Engine) warnUnknownStages() {
+	known := make(map[string]bool)
+	for _, s := range e.GetStages() {
+		known[s.Name] = true
+	}
+	for name := range e.cfg.Filtering.Stages {
+		if !known[name] {
+			e.log.Warnf("Unknown filter stage %q in config", name)
+		}
+	}
+}
+
+// Decide runs the pipeline for domain and clientIP and returns the result of
+// the first stage that decides, or Continue when none does
+func (e *Engine) Decide(domain, clientIP string) Result {
+	domain = normalizeDomain(domain)
+
+	// Never block empty domains
+	if domain == "" {
+		return Continue
+	}
+
+	r := e.evaluate(&Query{Domain: domain, ClientIP: clientIP})
+	switch r.Verdict {
+	case VerdictBlock:
+		e.trackBlockAttempt(domain, true, r.Reason)
+	case VerdictContinue:
+		e.trackBlockAttempt(domain, false, "")
+	}
+	e.recordShadow(domain, clientIP, r.Verdict == VerdictBlock, r.Reason)
+	return r
+}
+
+// WouldBlock reports whether domain would be blocked for clientIP if
+// filtering were not paused, and why. It is a dry run of the pipeline, so it
+// counts and charges nothing and is safe to call for paused queries.
+func (e *Engine) WouldBlock(domain, clientIP string) (bool, string) {
+	domain = normalizeDomain(domain)
+	if domain == "" {
+		return false, ""
+	}
+
+	r := e.evaluate(&Query{Domain: domain, ClientIP: clientIP, DryRun: true})
+	return r.Verdict == VerdictBlock, r.Reason
+}
+
+// evaluate runs the pipeline for a query on a normalized domain
+func (e *Engine) evaluate(q *Query) Result {
+	for _, s := range e.pipeline() {
+		r := s.Check(q)
+		if r.Verdict == VerdictContinue {
+			continue
+		}
+		if r.Verdict == VerdictRewrite && r.Rewrite == nil {
+			e.log.Warnf("Stage %s rewrote %s without an answer", s.Name(), q.Domain)
+			continue
+		}
+		return r
+	}
+	return Continue
+}
+
+// ─── Built-in Stages ──────────────────────────────────────────────────────────
+
+// Temporary overrides win over every other rule
+func (e *Engine) overrideStage(q *Query) Result {
+	o := e.matchOverride(q.Domain, q.ClientIP)
+	if o == nil {
+		return Continue
+	}
+	if o.Action == OverrideAllow {
+		return Result{Verdict: VerdictAllow, Reason: "override"}
+	}
+	return Result{Verdict: VerdictBlock, Reason: "override"}
+}
+
+// Clients in the walled garden only reach the allowlist
+func (e *Engine) walledGardenStage(q *Query) Result {
+	if !e.inWalledGarden(q.ClientIP) {
+		return Continue
+	}
+	check := e.checkWalledGarden
+	if q.DryRun {
+		// Only look the site up, without learning
+		check = func(domain, _ string) string { return e.gardenSite(domain) }
+	}
+	if site := check(q.Domain, q.ClientIP); site != "" {
+		return Result{Verdict: VerdictAllow, Reason: "walled-garden:" + site}
+	}
+	return Result{Verdict: VerdictBlock, Reason: "walled-garden"}
+}
+
+// The whitelist, including allow entries limited to some clients or times
+func (e *Engine) whitelistStage(q *Query) Result {
+	if e.whitelisted(q.Domain, !q.DryRun) || e.matchScopedRule(q.Domain, q.ClientIP, CustomListAllow) != nil {
+		return Result{Verdict: VerdictAllow, Reason: "whitelisted"}
+	}
+	return Continue
+}
+
+// The schedule rules active for this client
+func (e *Engine) scheduleStage(q *Query) Result {
+	if reason := e.checkSchedule(q.Domain, q.ClientIP); reason != "" {
+		return Result{Verdict: VerdictBlock, Reason: reason}
+	}
+	return Continue
+}
+
+// Categories are fast and come before the lists
+func (e *Engine) categoryStage(q *Query) Result {
+	if e.categoryMgr == nil {
+		return Continue
+	}
+	if blocked, category := e.categoryMgr.IsBlocked(q.Domain); blocked && !e.shadowCategory(category) {
+		return Result{Verdict: VerdictBlock, Reason: "category:" + category}
+	}
+	return Continue
+}
+
+// Keywords catch names no list has seen yet
+func (e *Engine) keywordStage(q *Query) Result {
+	if e.keywordMgr == nil {
+		return Continue
+	}
+	if q.DryRun {
+		// CheckDomain logs the match
+		listID, keywords := e.keywordMgr.FindKeywords(q.Domain, e.shadowKeywordLists())
+		if listID == "" {
+			return Continue
+		}
+		return Result{Verdict: VerdictBlock, Reason: fmt.Sprintf("keyword:%s:%s", listID, keywords[0])}
+	}
+
+	blocked, keywords, listID := e.keywordMgr.CheckDomain(q.Domain)
+	if shadow := e.shadowKeywordLists(); blocked && shadow[listID] {
+		// Matched a list in shadow, look for a live one
+		listID, keywords = e.keywordMgr.FindKeywords(q.Domain, shadow)
+		blocked = listID != ""
+	}
+	if !blocked {
+		return Continue
+	}
+	reason := fmt.Sprintf("keyword:%s", listID)
+	if len(keywords) > 0 {
+		reason = fmt.Sprintf("keyword:%s:%s", listID, keywords[0])
+	}
+	return Result{Verdict: VerdictBlock, Reason: reason}
+}
+
+// The custom blocklist, for the name and its parents
+func (e *Engine) customStage(q *Query) Result {
+	if e.matchScopedRule(q.Domain, q.ClientIP, CustomListBlock) != nil {
+		return Result{Verdict: VerdictBlock, Reason: "custom"}
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for name := q.Domain; ; {
+		if e.customBlocked[name] {
+			return Result{Verdict: VerdictBlock, Reason: "custom"}
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return Continue
+		}
+		name = name[i+1:]
+	}
+}
+
+// The blocklist sources, for the name and its parents (e.g.
+// ads.example.com -> example.com)
+func (e *Engine) blocklistStage(q *Query) Result {
+	for name := q.Domain; ; {
+		e.mu.RLock()
+		sources, listed := e.blockedDomains[name]
+		e.mu.RUnlock()
+
+		if listed {
+			if live, ok := e.liveSources(sources); ok {
+				if !q.DryRun {
+					e.countSourceHits(live)
+				}
+				return Result{Verdict: VerdictBlock, Reason: blocklistReason(live)}
+			}
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return Continue
+		}
+		name = name[i+1:]
+	}
+}
+
+// Regex and glob rules
+func (e *Engine) patternStage(q *Query) Result {
+	e.mu.RLock()
+	blockPatterns := e.blockPatterns
+	e.mu.RUnlock()
+
+	match := blockPatterns.Match
+	if q.DryRun {
+		match = blockPatterns.find
+	}
+	if rule := match(q.Domain); rule != nil {
+		return Result{Verdict: VerdictBlock, Reason: "pattern:" + rule.Pattern}
+	}
+	return Continue
+}
+
+// The AI is slower and runs last, but catches new threats
+func (e *Engine) aiStage(q *Query) Result {
+	if e.aiBlocker == nil || e.cfg.Filtering.Shadow.AI {
+		return Continue
+	}
+	predict := e.aiBlocker.Predict
+	if q.DryRun {
+		predict = e.aiBlocker.Evaluate
+	}
+	if result := predict(q.Domain); result.Blocked {
+		return Result{Verdict: VerdictBlock, Reason: fmt.Sprintf("ai:%.0f%%", result.Confidence)}
+	}
+	return Continue
+}
+
+// Time budgets are charged only for queries no earlier stage decided
+func (e *Engine) budgetStage(q *Query) Result {
+	if q.DryRun {
+		if b := e.budgetFor(q.Domain, q.ClientIP); b != nil && b.Exhausted {
+			return Result{Verdict: VerdictBlock, Reason: "budget:" + b.Name}
+		}
+		return Continue
+	}
+	if reason := e.checkBudget(q.Domain, q.ClientIP); reason != "" {
+		return Result{Verdict: VerdictBlock, Reason: reason}
+	}
+	return Continue
+}
@@ -0,0 +1,447 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
+)
+
+// ─── Tunneling and DGA Detection ──────────────────────────────────────────────
+//
+// Tunnels and malware that generates domain names are invisible in single
+// names but obvious in the query stream. Every query is added to a sliding
+// window per client and parent domain, and per client. A tunnel shows up as
+// many distinct high-entropy subdomains of one parent, as long labels or as
+// heavy TXT and NULL traffic; a domain generation algorithm as many distinct
+// random-looking domains. Each pattern raises an alert once per window and the
+// parent of a tunnel can be blocked for a while as tunnel:<parent>.
+
+const (
+	TunnelKindSubdomains = "subdomains"
+	TunnelKindLongLabels = "long-labels"
+	TunnelKindTXT        = "txt"
+	TunnelKindDGA        = "dga"
+
+	maxTunnelWindows = 20000 // client and parent pairs tracked at once
+	maxWindowQueries = 4096  // queries kept per window
+	maxTunnelAlerts  = 200
+)
+
+// TunnelAlert is a tunneling or DGA pattern seen from a client
+type TunnelAlert struct {
+	Kind    string    `json:"kind"`
+	Client  string    `json:"client"`
+	Domain  string    `json:"domain"` // the parent, or the last domain for dga
+	Count   int       `json:"count"`
+	Detail  string    `json:"detail"`
+	At      time.Time `json:"at"`
+	Blocked bool      `json:"blocked"`
+}
+
+// TunnelBlock is a parent domain blocked after a tunnel alert
+type TunnelBlock struct {
+	Domain string    `json:"domain"`
+	Client string    `json:"client"` // the client that raised the alert
+	Until  time.Time `json:"until"`
+}
+
+// tunnelQuery is one query kept in a window
+type tunnelQuery struct {
+	at   time.Time
+	name string // the subdomain part, or the domain for dga windows
+	long bool
+	txt  bool
+}
+
+// tunnelWindow counts the queries of the last window for one key
+type tunnelWindow struct {
+	queries []tunnelQuery
+	names   map[string]int // distinct names with their number of queries
+	entropy float64        // summed over the distinct names
+	long    int
+	txt     int
+	alerted map[string]time.Time
+}
+
+func newTunnelWindow() *tunnelWindow {
+	return &tunnelWindow{names: make(map[string]int), alerted: make(map[string]time.Time)}
+}
+
+func (w *tunnelWindow) add(q tunnelQuery) {
+	if len(w.queries) >= maxWindowQueries {
+		w.drop(1)
+	}
+	w.queries = append(w.queries, q)
+	if q.name != "" {
+		if w.names[q.name]++; w.names[q.name] == 1 {
+			w.entropy += labelEntropy(q.name)
+		}
+	}
+	if q.long {
+		w.long++
+	}
+	if q.txt {
+		w.txt++
+	}
+}
+
+// prune forgets the queries older than since
+func (w *tunnelWindow) prune(since time.Time) {
+	n := 0
+	for n < len(w.queries) && w.queries[n].at.Before(since) {
+		n++
+	}
+	w.drop(n)
+}
+
+func (w *tunnelWindow) drop(n int) {
+	for _, q := range w.queries[:n] {
+		if q.name != "" {
+			if w.names[q.name]--; w.names[q.name] == 0 {
+				delete(w.names, q.name)
+				w.entropy -= labelEntropy(q.name)
+			}
+		}
+		if q.long {
+			w.long--
+		}
+		if q.txt {
+			w.txt--
+		}
+	}
+	w.queries = append(w.queries[:0], w.queries[n:]...)
+}
+
+func (w *tunnelWindow) lastSeen() time.Time {
+	if len(w.queries) == 0 {
+		return time.Time{}
+	}
+	return w.queries[len(w.queries)-1].at
+}
+
+// tunnelState is the analyser state, under its own lock so queries from
+// different clients do not wait on the engine lock
+type tunnelState struct {
+	mu      sync.Mutex
+	windows map[string]*tunnelWindow // client + "\x00" + parent, or client for dga
+	blocks  map[string]*TunnelBlock
+	alerts  []TunnelAlert
+}
+
+func newTunnelState() *tunnelState {
+	return &tunnelState{
+		windows: make(map[string]*tunnelWindow),
+		blocks:  make(map[string]*TunnelBlock),
+	}
+}
+
+// tunnelLimits are the thresholds of the config with their defaults
+type tunnelLimits struct {
+	window      time.Duration
+	unique      int
+	minEntropy  float64
+	longLabel   int
+	longQueries int
+	txtQueries  int
+	dgaDomains  int
+	blockFor    time.Duration
+}
+
+func (e *Engine) tunnelLimits() tunnelLimits {
+	cfg := e.cfg.Filtering.Tunnel
+	orDefault := func(v, def int) int {
+		if v > 0 {
+			return v
+		}
+		return def
+	}
+	l := tunnelLimits{
+		window:      time.Duration(orDefault(cfg.Window, 300)) * time.Second,
+		unique:      orDefault(cfg.UniqueSubdomains, 50),
+		minEntropy:  cfg.MinEntropy,
+		longLabel:   orDefault(cfg.LongLabel, 40),
+		longQueries: orDefault(cfg.LongQueries, 10),
+		txtQueries:  orDefault(cfg.TXTQueries, 50),
+		dgaDomains:  orDefault(cfg.DGADomains, 30),
+		blockFor:    time.Duration(orDefault(cfg.BlockMinutes, 60)) * time.Minute,
+	}
+	if l.minEntropy <= 0 {
+		l.minEntropy = 3.5
+	}
+	return l
+}
+
+// labelEntropy is the Shannon entropy of s in bits per character
+func labelEntropy(s string) float64 {
+	s = strings.ReplaceAll(s, ".", "")
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	var entropy float64
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// randomLooking reports whether a label looks generated rather than chosen:
+// long, high in entropy and poor in vowels
+func randomLooking(label string) bool {
+	if len(label) < 10 {
+		return false
+	}
+	vowels := 0
+	for _, r := range label {
+		if strings.ContainsRune("aeiou", r) {
+			vowels++
+		}
+	}
+	return labelEntropy(label) >= 3.0 && float64(vowels)/float64(len(label)) <= 0.3
+}
+
+// tunnelIgnored reports whether parent is left out of the analysis
+func (e *Engine) tunnelIgnored(parent string) bool {
+	for _, ignored := range e.cfg.Filtering.Tunnel.Ignore {
+		ignored = normalizeDomain(ignored)
+		if parent == ignored || strings.HasSuffix(parent, "."+ignored) {
+			return true
+		}
+	}
+	return e.isWhitelisted(parent)
+}
+
+// observeTunnel adds a query to the windows of its client
+func (e *Engine) observeTunnel(domain, clientIP string, qtype uint16) {
+	if !e.cfg.Filtering.Tunnel.Enabled {
+		return
+	}
+	parent, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil || e.tunnelIgnored(parent) {
+		return
+	}
+
+	l := e.tunnelLimits()
+	now := time.Now()
+	since := now.Add(-l.window)
+
+	q := tunnelQuery{at: now, txt: qtype == dns.TypeTXT || qtype == dns.TypeNULL}
+	if sub := strings.TrimSuffix(strings.TrimSuffix(domain, parent), "."); sub != "" {
+		q.name = sub
+		for _, label := range strings.Split(sub, ".") {
+			if len(label) >= l.longLabel {
+				q.long = true
+			}
+		}
+	}
+
+	var alerts []TunnelAlert
+	t := e.tunnel
+	t.mu.Lock()
+
+	key := clientIP + "\x00" + parent
+	w := t.window(key)
+	if w != nil {
+		w.prune(since)
+		w.add(q)
+		if len(w.names) >= l.unique && w.entropy/float64(len(w.names)) >= l.minEntropy {
+			alerts = t.alert(w, alerts, TunnelAlert{
+				Kind: TunnelKindSubdomains, Client: clientIP, Domain: parent, Count: len(w.names),
+				Detail: fmt.Sprintf("%d distinct subdomains, %.1f bits per character", len(w.names), w.entropy/float64(len(w.names))),
+			}, now, l.window)
+		}
+		if w.long >= l.longQueries {
+			alerts = t.alert(w, alerts, TunnelAlert{
+				Kind: TunnelKindLongLabels, Client: clientIP, Domain: parent, Count: w.long,
+				Detail: fmt.Sprintf("%d queries with labels of %d characters or more", w.long, l.longLabel),
+			}, now, l.window)
+		}
+		if w.txt >= l.txtQueries {
+			alerts = t.alert(w, alerts, TunnelAlert{
+				Kind: TunnelKindTXT, Client: clientIP, Domain: parent, Count: w.txt,
+				Detail: fmt.Sprintf("%d TXT and NULL queries", w.txt),
+			}, now, l.window)
+		}
+	}
+
+	if label := strings.SplitN(parent, ".", 2)[0]; randomLooking(label) {
+		if d := t.window(clientIP); d != nil {
+			d.prune(since)
+			d.add(tunnelQuery{at: now, name: parent})
+			if len(d.names) >= l.dgaDomains {
+				alerts = t.alert(d, alerts, TunnelAlert{
+					Kind: TunnelKindDGA, Client: clientIP, Domain: parent, Count: len(d.names),
+					Detail: fmt.Sprintf("%d random-looking domains", len(d.names)),
+				}, now, l.window)
+			}
+		}
+	}
+
+	var blocked []string
+	for i := range alerts {
+		a := &alerts[i]
+		if e.cfg.Filtering.Tunnel.Block && a.Kind != TunnelKindDGA {
+			if _, ok := t.blocks[a.Domain]; !ok {
+				blocked = append(blocked, a.Domain)
+			}
+			t.blocks[a.Domain] = &TunnelBlock{Domain: a.Domain, Client: a.Client, Until: now.Add(l.blockFor)}
+			a.Blocked = true
+		}
+		t.alerts = append(t.alerts, *a)
+	}
+	if n := len(t.alerts) - maxTunnelAlerts; n > 0 {
+		t.alerts = append(t.alerts[:0], t.alerts[n:]...)
+	}
+	t.mu.Unlock()
+
+	for _, a := range alerts {
+		action := "alert"
+		if a.Blocked {
+			action = "blocked until " + now.Add(l.blockFor).Format("15:04:05")
+		}
+		e.log.Warnf("🚨 TUNNEL %s: %s from %s, %s (%s)", a.Kind, a.Domain, a.Client, a.Detail, action)
+	}
+	if len(blocked) > 0 {
+		e.notifyCustomChange(blocked)
+	}
+}
+
+// window returns the window of key, creating it unless too many are tracked.
+// Caller holds t.mu.
+func (t *tunnelState) window(key string) *tunnelWindow {
+	w, ok := t.windows[key]
+	if !ok {
+		if len(t.windows) >= maxTunnelWindows {
+			return nil
+		}
+		w = newTunnelWindow()
+		t.windows[key] = w
+	}
+	return w
+}
+
+// alert adds a to alerts unless the window raised the same kind of alert
+// within the last window. Caller holds t.mu.
+func (t *tunnelState) alert(w *tunnelWindow, alerts []TunnelAlert, a TunnelAlert, now time.Time, window time.Duration) []TunnelAlert {
+	if last, ok := w.alerted[a.Kind]; ok && now.Sub(last) < window {
+		return alerts
+	}
+	w.alerted[a.Kind] = now
+	a.At = now
+	return append(alerts, a)
+}
+
+// expireTunnels drops idle windows and expired blocks
+func (e *Engine) expireTunnels() {
+	now := time.Now()
+	since := now.Add(-e.tunnelLimits().window)
+	var unblocked []string
+
+	t := e.tunnel
+	t.mu.Lock()
+	for key, w := range t.windows {
+		if w.lastSeen().Before(since) {
+			delete(t.windows, key)
+		}
+	}
+	for name, b := range t.blocks {
+		if !now.Before(b.Until) {
+			delete(t.blocks, name)
+			unblocked = append(unblocked, name)
+		}
+	}
+	t.mu.Unlock()
+
+	if len(unblocked) > 0 {
+		e.log.Infof("Tunnel blocks expired: %s", strings.Join(unblocked, ", "))
+		e.notifyCustomChange(unblocked)
+	}
+}
+
+// tunnelBlock returns the block covering domain, or nil
+func (e *Engine) tunnelBlock(domain string) *TunnelBlock {
+	parent, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return nil
+	}
+
+	t := e.tunnel
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if b, ok := t.blocks[parent]; ok && time.Now().Before(b.Until) {
+		block := *b
+		return &block
+	}
+	return nil
+}
+
+// tunnelStage blocks the parents of detected tunnels
+func (e *Engine) tunnelStage(q *Query) Result {
+	if b := e.tunnelBlock(q.Domain); b != nil {
+		return Result{Verdict: VerdictBlock, Reason: "tunnel:" + b.Domain}
+	}
+	return Continue
+}
+
+func (e *Engine) explainTunnel(x *explainer, q *Query) {
+	b := e.tunnelBlock(q.Domain)
+	if b == nil {
+		x.miss("tunnel", "")
+		return
+	}
+	x.hit(TraceStep{
+		Stage:  "tunnel",
+		Effect: EffectBlock,
+		Rule:   b.Domain,
+		Source: b.Client,
+		Detail: "until " + b.Until.Format(time.RFC3339),
+	}, "tunnel:"+b.Domain)
+}
+
+// GetTunnelAlerts returns the recent alerts, newest first, and the active
+// blocks
+func (e *Engine) GetTunnelAlerts() ([]TunnelAlert, []TunnelBlock) {
+	t := e.tunnel
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	alerts := make([]TunnelAlert, len(t.alerts))
+	for i, a := range t.alerts {
+		alerts[len(alerts)-1-i] = a
+	}
+	blocks := make([]TunnelBlock, 0, len(t.blocks))
+	for _, b := range t.blocks {
+		blocks = append(blocks, *b)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Domain < blocks[j].Domain })
+	return alerts, blocks
+}
+
+// RemoveTunnelBlock lifts the block of a parent domain before it expires
+func (e *Engine) RemoveTunnelBlock(domain string) error {
+	domain = normalizeDomain(domain)
+
+	t := e.tunnel
+	t.mu.Lock()
+	_, ok := t.blocks[domain]
+	delete(t.blocks, domain)
+	t.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%s is not blocked", domain)
+	}
+	e.notifyCustomChange([]string{domain})
+	return nil
+}
@@ -0,0 +1,162 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+	"github.com/RDXFGXY1/dns-filter-app/pkg/logger"
+)
+
+func TestLabelEntropy(t *testing.T) {
+	tests := []struct {
+		s    string
+		want float64
+	}{
+		{"", 0},
+		{"aaaa", 0},
+		{"ab", 1},
+		{"abcd", 2},
+		{"a.b", 1},
+		{"abcdefgh", 3},
+	}
+	for _, tt := range tests {
+		if got := labelEntropy(tt.s); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("labelEntropy(%q) = %f, want %f", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestRandomLooking(t *testing.T) {
+	tests := []struct {
+		label string
+		want  bool
+	}{
+		{"xkqwzvbtrplm", true},
+		{"q8zk3x9vj2wf", true},
+		{"google", false},
+		{"xkqwzvbtr", false}, // too short
+		{"wikipediaorganisation", false},
+		{"bbbbbbbbbbbb", false},
+	}
+	for _, tt := range tests {
+		if got := randomLooking(tt.label); got != tt.want {
+			t.Errorf("randomLooking(%s) = %v, want %v", tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestTunnelWindow(t *testing.T) {
+	w := newTunnelWindow()
+	start := time.Now()
+	queries := []tunnelQuery{
+		{at: start, name: "abcd", long: true},
+		{at: start.Add(time.Second), name: "abcd", txt: true},
+		{at: start.Add(2 * time.Second), name: "ab"},
+		{at: start.Add(3 * time.Second), txt: true},
+	}
+	for _, q := range queries {
+		w.add(q)
+	}
+
+	tests := []struct {
+		prune            time.Duration
+		names, long, txt int
+		entropy          float64
+	}{
+		{0, 2, 1, 2, 3},
+		{time.Second, 2, 0, 2, 3},
+		{2 * time.Second, 1, 0, 1, 1},
+		{4 * time.Second, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		w.prune(start.Add(tt.prune))
+		if len(w.names) != tt.names || w.long != tt.long || w.txt != tt.txt || math.Abs(w.entropy-tt.entropy) > 1e-9 {
+			t.Errorf("after pruning %s: %d names, %d long, %d txt, entropy %f; want %d, %d, %d, %f",
+				tt.prune, len(w.names), w.long, w.txt, w.entropy, tt.names, tt.long, tt.txt, tt.entropy)
+		}
+	}
+	if !w.lastSeen().IsZero() {
+		t.Errorf("an empty window was last seen at %s", w.lastSeen())
+	}
+}
+
+func TestObserveTunnel(t *testing.T) {
+	random := func(i int) string {
+		return fmt.Sprintf("q%dzx%dkv%dwj", i, i*7, i*13)
+	}
+
+	tests := []struct {
+		name    string
+		queries func(i int) (string, uint16)
+		want    string // kind of the alert, "" for none
+		blocked bool
+	}{
+		{"random subdomains", func(i int) (string, uint16) {
+			return random(i) + ".tunnel.example.com", dns.TypeA
+		}, TunnelKindSubdomains, true},
+		{"repeated subdomain", func(i int) (string, uint16) {
+			return "www.example.com", dns.TypeA
+		}, "", false},
+		{"long labels", func(i int) (string, uint16) {
+			return fmt.Sprintf("%040d.example.com", i%2), dns.TypeA
+		}, TunnelKindLongLabels, true},
+		{"txt flood", func(i int) (string, uint16) {
+			return "example.com", dns.TypeTXT
+		}, TunnelKindTXT, true},
+		{"generated domains", func(i int) (string, uint16) {
+			return random(i) + ".com", dns.TypeA
+		}, TunnelKindDGA, false},
+		{"ignored parent", func(i int) (string, uint16) {
+			return random(i) + ".cloudfront.net", dns.TypeA
+		}, "", false},
+	}
+	for _, tt := range tests {
+		e := &Engine{cfg: &config.Config{}, log: logger.Get(), tunnel: newTunnelState()}
+		e.cfg.Filtering.Tunnel = config.TunnelConfig{
+			Enabled:          true,
+			UniqueSubdomains: 10,
+			MinEntropy:       3,
+			LongQueries:      5,
+			TXTQueries:       10,
+			DGADomains:       10,
+			Block:            true,
+			Ignore:           []string{"cloudfront.net"},
+		}
+
+		for i := 0; i < 20; i++ {
+			domain, qtype := tt.queries(i)
+			e.observeTunnel(domain, "10.0.0.5", qtype)
+		}
+
+		alerts, blocks := e.GetTunnelAlerts()
+		if tt.want == "" {
+			if len(alerts) != 0 {
+				t.Errorf("%s: unexpected alerts %+v", tt.name, alerts)
+			}
+			continue
+		}
+		if len(alerts) != 1 || alerts[0].Kind != tt.want {
+			t.Errorf("%s: alerts %+v, want one %s alert", tt.name, alerts, tt.want)
+			continue
+		}
+		if (len(blocks) > 0) != tt.blocked {
+			t.Errorf("%s: blocks %+v, want blocked %v", tt.name, blocks, tt.blocked)
+		}
+		if tt.blocked {
+			if got := e.tunnelStage(&Query{Domain: "other.example.com"}); got.Verdict != VerdictBlock {
+				t.Errorf("%s: the parent is not blocked", tt.name)
+			}
+			if err := e.RemoveTunnelBlock("example.com"); err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			if got := e.tunnelStage(&Query{Domain: "other.example.com"}); got.Verdict != VerdictContinue {
+				t.Errorf("%s: the parent is still blocked after removing the block", tt.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package filter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/config"
+)
+
+// ─── Integrity Verification ───────────────────────────────────────────────────
+//
+// A source may pin the SHA-256 of its content, point to a checksum file, or
+// point to a detached signature made with minisign or a bare ed25519 key.
+// Content that fails verification is treated like a failed download: the
+// source keeps its last good content and the error is reported.
+
+const (
+	VerifyNone      = ""
+	VerifySHA256    = "sha256"
+	VerifyChecksum  = "checksum_file"
+	VerifySignature = "signature"
+
+	maxSidecarSize = 64 * 1024
+)
+
+// verifyError marks content that was downloaded but failed verification
+type verifyError struct {
+	err error
+}
+
+func (v *verifyError) Error() string {
+	return "verification failed: " + v.err.Error()
+}
+
+// verificationMethod names the check configured for a source
+func verificationMethod(source config.BlocklistSource) string {
+	switch {
+	case source.SignatureURL != "":
+		return VerifySignature
+	case source.ChecksumURL != "":
+		return VerifyChecksum
+	case source.SHA256 != "":
+		return VerifySHA256
+	}
+	return VerifyNone
+}
+
+// verifyContent checks data against every method configured for source
+func (e *Engine) verifyContent(source config.BlocklistSource, data []byte) error {
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+
+	if source.SHA256 != "" && !strings.EqualFold(strings.TrimSpace(source.SHA256), actual) {
+		return fmt.Errorf("sha256 mismatch: got %s", actual)
+	}
+
+	if source.ChecksumURL != "" {
+		sidecar, err := e.fetchSidecar(source.ChecksumURL)
+		if err != nil {
+			return fmt.Errorf("checksum file: %w", err)
+		}
+		expected, err := parseChecksumFile(sidecar, source.URL)
+		if err != nil {
+			return fmt.Errorf("checksum file: %w", err)
+		}
+		if !strings.EqualFold(expected, actual) {
+			return fmt.Errorf("sha256 mismatch with checksum file: got %s, want %s", actual, expected)
+		}
+	}
+
+	if source.SignatureURL != "" {
+		if source.PublicKey == "" {
+			return fmt.Errorf("signature_url is set but public_key is missing")
+		}
+		sig, err := e.fetchSidecar(source.SignatureURL)
+		if err != nil {
+			return fmt.Errorf("signature: %w", err)
+		}
+		if err := verifySignature(data, sig, source.PublicKey); err != nil {
+			return fmt.Errorf("signature: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (e *Engine) fetchSidecar(url string) ([]byte, error) {
+	body, err := e.openBlocklist(url)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(io.LimitReader(body, maxSidecarSize))
+}
+
+// parseChecksumFile reads a bare hex digest or sha256sum output. With several
+// lines, the one naming the file at listURL is used.
+func parseChecksumFile(data []byte, listURL string) (string, error) {
+	name := listURL[strings.LastIndex(listURL, "/")+1:]
+
+	var digests []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+			continue
+		}
+		if _, err := hex.DecodeString(fields[0]); err != nil {
+			continue
+		}
+		if len(fields) > 1 && strings.TrimPrefix(fields[len(fields)-1], "*") == name {
+			return fields[0], nil
+		}
+		digests = append(digests, fields[0])
+	}
+
+	if len(digests) != 1 {
+		return "", fmt.Errorf("no sha256 digest for %s", name)
+	}
+	return digests[0], nil
+}
+
+// verifySignature checks a minisign signature file, or a bare ed25519
+// signature (raw or base64), against the public key
+func verifySignature(data, sig []byte, publicKey string) error {
+	key, keyID, err := parsePublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	text := strings.TrimSpace(string(sig))
+	if !strings.HasPrefix(text, "untrusted comment:") {
+		raw := sig
+		if decoded, err := base64.StdEncoding.DecodeString(text); err == nil {
+			raw = decoded
+		}
+		if len(raw) != ed25519.SignatureSize {
+			return fmt.Errorf("invalid ed25519 signature")
+		}
+		if !ed25519.Verify(key, data, raw) {
+			return fmt.Errorf("ed25519 signature does not match")
+		}
+		return nil
+	}
+
+	// minisign: untrusted comment, signature, trusted comment, global signature
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) < 4 {
+		return fmt.Errorf("truncated minisign signature")
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(blob) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("invalid minisign signature")
+	}
+	alg, sigKeyID, signature := string(blob[:2]), blob[2:10], blob[10:]
+
+	if keyID != nil && !bytes.Equal(keyID, sigKeyID) {
+		return fmt.Errorf("signature was made with a different key")
+	}
+
+	message := data
+	switch alg {
+	case "Ed":
+	case "ED":
+		digest := blake2b.Sum512(data)
+		message = digest[:]
+	default:
+		return fmt.Errorf("unsupported minisign algorithm %q", alg)
+	}
+	if !ed25519.Verify(key, message, signature) {
+		return fmt.Errorf("minisign signature does not match")
+	}
+
+	trusted, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return fmt.Errorf("missing trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || !ed25519.Verify(key, append(append([]byte{}, signature...), trusted...), global) {
+		return fmt.Errorf("trusted comment signature does not match")
+	}
+	return nil
+}
+
+// parsePublicKey accepts a minisign public key (the base64 line of a .pub
+// file) or a base64 ed25519 key. The key ID is nil for bare keys.
+func parsePublicKey(publicKey string) (ed25519.PublicKey, []byte, error) {
+	lines := strings.Split(strings.TrimSpace(publicKey), "\n")
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid public key: %v", err)
+	}
+
+	switch len(raw) {
+	case ed25519.PublicKeySize:
+		return ed25519.PublicKey(raw), nil, nil
+	case 2 + 8 + ed25519.PublicKeySize:
+		if string(raw[:2]) != "Ed" {
+			return nil, nil, fmt.Errorf("unsupported minisign key algorithm")
+		}
+		return ed25519.PublicKey(raw[10:]), raw[2:10], nil
+	}
+	return nil, nil, fmt.Errorf("invalid public key length %d", len(raw))
+}
@@ -0,0 +1,235 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/database"
+)
+
+// ─── Blocklist Versions ───────────────────────────────────────────────────────
+//
+// Every change of the compiled blocklist is recorded as a version with a
+// compressed snapshot, so a bad update can be rolled back. Updates that change
+// the size of the list by more than max_change_percent are refused and
+// recorded without a snapshot.
+
+const (
+	VersionApplied = "applied"
+	VersionRefused = "refused"
+
+	versionSampleSize   = 20
+	defaultKeepVersions = 10
+)
+
+// BlocklistVersion describes one change of the blocklist
+type BlocklistVersion struct {
+	ID            int64     `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Cause         string    `json:"cause"`
+	Status        string    `json:"status"`
+	DomainCount   int       `json:"domain_count"`
+	Added         int       `json:"added"`
+	Removed       int       `json:"removed"`
+	AddedSample   []string  `json:"added_sample,omitempty"`
+	RemovedSample []string  `json:"removed_sample,omitempty"`
+	CanRollback   bool      `json:"can_rollback"`
+}
+
+type versionSummary struct {
+	AddedSample   []string `json:"added_sample,omitempty"`
+	RemovedSample []string `json:"removed_sample,omitempty"`
+}
+
+// applyBlocklist swaps in a newly merged blocklist, writes the difference to
+// the database and records a version. With guard set, a change in size above
+// the configured limit is refused: the current list stays in place and the
+// sources fetched in this run (fetched maps each to its previous content) are
+// reset so the next update downloads them again.
+func (e *Engine) applyBlocklist(newBlocked map[string][]string, cause string, guard bool, fetched map[string][]string) error {
+	e.mu.RLock()
+	upserts := make(map[string][]string)
+	var added, removed []string
+	for domain, sources := range newBlocked {
+		old, ok := e.blockedDomains[domain]
+		if !ok {
+			added = append(added, domain)
+		}
+		if !ok || !equalSources(old, sources) {
+			upserts[domain] = sources
+		}
+	}
+	for domain := range e.blockedDomains {
+		if _, ok := newBlocked[domain]; !ok {
+			removed = append(removed, domain)
+		}
+	}
+	oldCount := len(e.blockedDomains)
+	e.mu.RUnlock()
+
+	if len(upserts) == 0 && len(removed) == 0 {
+		e.log.Infof("Blocklist unchanged: %d domains", oldCount)
+		return nil
+	}
+
+	version := database.BlocklistVersion{
+		CreatedAt:   time.Now(),
+		Cause:       cause,
+		Status:      VersionApplied,
+		DomainCount: len(newBlocked),
+		Added:       len(added),
+		Removed:     len(removed),
+		Summary:     summarizeChange(added, removed),
+	}
+
+	if limit := e.cfg.Blocklists.MaxChangePercent; guard && limit > 0 && oldCount > 0 {
+		change := float64(len(newBlocked)-oldCount) / float64(oldCount) * 100
+		if change > float64(limit) || change < -float64(limit) {
+			err := fmt.Errorf("update refused: blocklist would change from %d to %d domains (%+.0f%%, limit %d%%)",
+				oldCount, len(newBlocked), change, limit)
+
+			version.Status = VersionRefused
+			if _, dbErr := e.db.AddBlocklistVersion(version, nil); dbErr != nil {
+				e.log.Warnf("Failed to record refused blocklist version: %v", dbErr)
+			}
+			e.discardFetched(fetched, err)
+			e.log.Errorf("%v", err)
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	e.blockedDomains = newBlocked
+	e.ensureSourceCounters(newBlocked)
+	e.mu.Unlock()
+
+	if err := e.db.UpdateBlocklist(upserts, removed); err != nil {
+		e.log.Errorf("Failed to save blocklist to database: %v", err)
+	}
+
+	id, err := e.db.AddBlocklistVersion(version, newBlocked)
+	if err != nil {
+		e.log.Warnf("Failed to record blocklist version: %v", err)
+	} else {
+		keep := e.cfg.Blocklists.KeepVersions
+		if keep <= 0 {
+			keep = defaultKeepVersions
+		}
+		if err := e.db.PruneBlocklistVersions(keep); err != nil {
+			e.log.Warnf("Failed to prune blocklist versions: %v", err)
+		}
+	}
+
+	e.log.Infof("Blocklist version %d: %d domains (+%d, -%d, %s)", id, len(newBlocked), len(added), len(removed), cause)
+	return nil
+}
+
+// discardFetched puts back the previous content of sources whose new content
+// was refused and clears their validators
+func (e *Engine) discardFetched(fetched map[string][]string, reason error) {
+	var statuses []SourceStatus
+
+	e.mu.Lock()
+	for name, previous := range fetched {
+		if previous == nil {
+			delete(e.sourceDomains, name)
+		} else {
+			e.sourceDomains[name] = previous
+		}
+		if status, ok := e.sourceStatus[name]; ok {
+			status.ETag = ""
+			status.LastModified = ""
+			status.Checksum = ""
+			status.LastError = reason.Error()
+			statuses = append(statuses, *status)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, status := range statuses {
+		e.saveSourceStatus(status)
+	}
+}
+
+func summarizeChange(added, removed []string) string {
+	summary := versionSummary{
+		AddedSample:   sample(added, versionSampleSize),
+		RemovedSample: sample(removed, versionSampleSize),
+	}
+	data, _ := json.Marshal(summary)
+	return string(data)
+}
+
+func sample(domains []string, n int) []string {
+	sorted := append([]string(nil), domains...)
+	sort.Strings(sorted)
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// GetBlocklistVersions lists the recorded versions, newest first
+func (e *Engine) GetBlocklistVersions(limit int) ([]BlocklistVersion, error) {
+	records, err := e.db.ListBlocklistVersions(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]BlocklistVersion, 0, len(records))
+	for _, rec := range records {
+		v := BlocklistVersion{
+			ID:          rec.ID,
+			CreatedAt:   rec.CreatedAt,
+			Cause:       rec.Cause,
+			Status:      rec.Status,
+			DomainCount: rec.DomainCount,
+			Added:       rec.Added,
+			Removed:     rec.Removed,
+			CanRollback: rec.HasSnapshot,
+		}
+		var summary versionSummary
+		if json.Unmarshal([]byte(rec.Summary), &summary) == nil {
+			v.AddedSample = summary.AddedSample
+			v.RemovedSample = summary.RemovedSample
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// RollbackBlocklist restores the blocklist of an earlier version. The restored
+// content stands in for each source until the source changes upstream.
+func (e *Engine) RollbackBlocklist(id int64) error {
+	e.updateMu.Lock()
+	defer e.updateMu.Unlock()
+
+	snapshot, err := e.db.LoadBlocklistSnapshot(id)
+	if err != nil {
+		return fmt.Errorf("version %d not found", id)
+	}
+	if snapshot == nil {
+		return fmt.Errorf("version %d has no snapshot", id)
+	}
+
+	stripCustomSource(snapshot) // versions from before custom entries had their own store
+
+	bySource := make(map[string][]string)
+	for domain, sources := range snapshot {
+		for _, name := range sources {
+			if name != customSourceName {
+				bySource[name] = append(bySource[name], domain)
+			}
+		}
+	}
+
+	e.mu.Lock()
+	for name, domains := range bySource {
+		e.sourceDomains[name] = domains
+	}
+	e.mu.Unlock()
+
+	return e.applyBlocklist(snapshot, fmt.Sprintf("rollback to version %d", id), false, nil)
+}
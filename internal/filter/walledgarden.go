@@ -0,0 +1,313 @@
+package filter
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RDXFGXY1/dns-filter-app/internal/database"
+)
+
+// ─── Walled Garden ────────────────────────────────────────────────────────────
+//
+// For the clients of the walled garden ShouldBlock inverts its logic: every
+// name is denied unless it is on the allowlist, is a dependency of an allowed
+// site, or was approved after being learned. In learn mode a denied name that
+// a client requests right after visiting an allowed site is recorded as a
+// candidate dependency of that site.
+
+const (
+	gardenLearnWindow = 30 * time.Second
+	maxGardenLearned  = 1000
+)
+
+// gardenDependencies are the domains well-known sites load their content,
+// scripts and media from
+var gardenDependencies = map[string][]string{
+	"khanacademy.org": {"kastatic.org", "kasandbox.org"},
+	"wikipedia.org":   {"wikimedia.org", "wikipedia.com"},
+	"wiktionary.org":  {"wikimedia.org"},
+	"scratch.mit.edu": {"scratchfoundation.org"},
+	"pbskids.org":     {"pbs.org"},
+}
+
+// GardenDomain is a domain of the walled garden as shown by the API
+type GardenDomain struct {
+	Domain    string    `json:"domain"`
+	Origin    string    `json:"origin"` // config, dependency, api or learned
+	Parent    string    `json:"parent,omitempty"`
+	Client    string    `json:"client,omitempty"`
+	Hits      int64     `json:"hits,omitempty"`
+	Approved  bool      `json:"approved"`
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+}
+
+// gardenVisit is the last allowed site a client of the garden requested
+type gardenVisit struct {
+	site string
+	at   time.Time
+}
+
+// loadWalledGarden compiles the clients of the walled garden and its
+// allowlist from the config and the database
+func (e *Engine) loadWalledGarden() error {
+	cfg := e.cfg.Filtering.WalledGarden
+
+	var networks []*net.IPNet
+	for _, client := range cfg.Clients {
+		network, err := parseClientNetwork(client)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, network)
+	}
+	for _, group := range cfg.Groups {
+		if _, ok := e.clientGroups[group]; !ok {
+			return fmt.Errorf("unknown client group %q", group)
+		}
+	}
+
+	allowed := make(map[string]string) // domain -> origin
+	for _, site := range cfg.Allow {
+		if site = normalizeDomain(site); site != "" {
+			allowed[site] = "config"
+			for _, dep := range gardenDependencies[site] {
+				if _, ok := allowed[dep]; !ok {
+					allowed[dep] = "dependency"
+				}
+			}
+			for _, dep := range cfg.Dependencies[site] {
+				if dep = normalizeDomain(dep); dep != "" {
+					if _, ok := allowed[dep]; !ok {
+						allowed[dep] = "dependency"
+					}
+				}
+			}
+		}
+	}
+
+	stored, err := e.db.LoadGardenDomains()
+	if err != nil {
+		return err
+	}
+	learned := make(map[string]*database.GardenDomain)
+	for i := range stored {
+		d := &stored[i]
+		if d.Approved {
+			if _, ok := allowed[d.Domain]; !ok {
+				allowed[d.Domain] = gardenOrigin(d)
+			}
+		}
+		learned[d.Domain] = d
+	}
+
+	e.mu.Lock()
+	e.gardenNetworks = networks
+	e.gardenAllowed = allowed
+	e.gardenLearned = learned
+	e.gardenVisits = make(map[string]gardenVisit)
+	e.mu.Unlock()
+	return nil
+}
+
+func gardenOrigin(d *database.GardenDomain) string {
+	if d.Parent == "" {
+		return "api"
+	}
+	return "learned"
+}
+
+// inWalledGarden reports whether clientIP is confined to the walled garden
+func (e *Engine) inWalledGarden(clientIP string) bool {
+	cfg := e.cfg.Filtering.WalledGarden
+	if !cfg.Enabled {
+		return false
+	}
+	if len(cfg.Clients) == 0 && len(cfg.Groups) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, network := range e.gardenNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	for _, group := range cfg.Groups {
+		for _, network := range e.clientGroups[group] {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkWalledGarden decides a query of a client in the walled garden. It
+// returns the allowed site that covers domain, or "" when domain is denied.
+func (e *Engine) checkWalledGarden(domain, clientIP string) string {
+	site := e.gardenSite(domain)
+	if !e.cfg.Filtering.WalledGarden.Learn {
+		return site
+	}
+
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if site != "" {
+		e.gardenVisits[clientIP] = gardenVisit{site: site, at: now}
+		return site
+	}
+
+	// Denied right after an allowed site: probably something that site needs
+	visit, ok := e.gardenVisits[clientIP]
+	if !ok || now.Sub(visit.at) > gardenLearnWindow {
+		return ""
+	}
+	if d, ok := e.gardenLearned[domain]; ok {
+		d.Hits++
+		d.LastSeen = now
+		e.gardenDirty = true
+	} else if len(e.gardenLearned) < maxGardenLearned {
+		e.gardenLearned[domain] = &database.GardenDomain{
+			Domain:    domain,
+			Parent:    visit.site,
+			Client:    clientIP,
+			Hits:      1,
+			FirstSeen: now,
+			LastSeen:  now,
+		}
+		e.gardenDirty = true
+		e.log.Infof("Walled garden learned %s (requested after %s by %s)", domain, visit.site, clientIP)
+	}
+	return ""
+}
+
+// gardenSite returns the allowed domain of the walled garden that covers
+// domain, or ""
+func (e *Engine) gardenSite(domain string) string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for name := domain; ; {
+		if _, ok := e.gardenAllowed[name]; ok {
+			return name
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			return ""
+		}
+		name = name[i+1:]
+	}
+}
+
+// flushGardenLearned stores the domains learned since the last flush
+func (e *Engine) flushGardenLearned() {
+	e.mu.Lock()
+	if !e.gardenDirty {
+		e.mu.Unlock()
+		return
+	}
+	domains := make([]database.GardenDomain, 0, len(e.gardenLearned))
+	for _, d := range e.gardenLearned {
+		domains = append(domains, *d)
+	}
+	e.gardenDirty = false
+	e.mu.Unlock()
+
+	if err := e.db.SaveGardenDomains(domains); err != nil {
+		e.log.Warnf("Failed to save walled garden domains: %v", err)
+	}
+}
+
+// GetWalledGarden lists the allowed domains and the learned ones waiting for
+// approval
+func (e *Engine) GetWalledGarden() []GardenDomain {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	list := make([]GardenDomain, 0, len(e.gardenAllowed)+len(e.gardenLearned))
+	for domain, origin := range e.gardenAllowed {
+		entry := GardenDomain{Domain: domain, Origin: origin, Approved: true}
+		if d, ok := e.gardenLearned[domain]; ok {
+			entry.Parent, entry.Client, entry.Hits = d.Parent, d.Client, d.Hits
+			entry.FirstSeen, entry.LastSeen = d.FirstSeen, d.LastSeen
+		}
+		list = append(list, entry)
+	}
+	for domain, d := range e.gardenLearned {
+		if _, ok := e.gardenAllowed[domain]; ok {
+			continue
+		}
+		list = append(list, GardenDomain{
+			Domain:    domain,
+			Origin:    gardenOrigin(d),
+			Parent:    d.Parent,
+			Client:    d.Client,
+			Hits:      d.Hits,
+			FirstSeen: d.FirstSeen,
+			LastSeen:  d.LastSeen,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Domain < list[j].Domain })
+	return list
+}
+
+// AllowInWalledGarden adds domain to the allowlist, or approves it if it was
+// learned
+func (e *Engine) AllowInWalledGarden(domain string) error {
+	domain = normalizeDomain(domain)
+	if domain == "" {
+		return fmt.Errorf("domain is required")
+	}
+
+	e.flushGardenLearned()
+	err := e.db.SetGardenApproved(domain, true)
+	if err == sql.ErrNoRows {
+		now := time.Now()
+		err = e.db.SaveGardenDomains([]database.GardenDomain{{Domain: domain, Approved: true, FirstSeen: now, LastSeen: now}})
+	}
+	if err != nil {
+		return err
+	}
+	if err := e.loadWalledGarden(); err != nil {
+		return err
+	}
+	e.notifyCustomChange([]string{domain})
+	return nil
+}
+
+// RemoveFromWalledGarden removes a domain that was added through the API or
+// learned. Domains from config.yaml cannot be removed here.
+func (e *Engine) RemoveFromWalledGarden(domain string) error {
+	domain = normalizeDomain(domain)
+
+	e.mu.RLock()
+	origin := e.gardenAllowed[domain]
+	e.mu.RUnlock()
+	if origin == "config" || origin == "dependency" {
+		return fmt.Errorf("%s is set in config.yaml", domain)
+	}
+
+	e.flushGardenLearned()
+	if err := e.db.DeleteGardenDomain(domain); err != nil {
+		return err
+	}
+	if err := e.loadWalledGarden(); err != nil {
+		return err
+	}
+	e.notifyCustomChange([]string{domain})
+	return nil
+}
@@ -0,0 +1,56 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ─── Custom File Watcher ──────────────────────────────────────────────────────
+//
+// Files matching custom_path are watched and read again shortly after they
+// change. Editors often write a file in several steps, so events are collected
+// for watchDelay before the reload runs.
+
+const watchDelay = 500 * time.Millisecond
+
+// reloadWatchedFiles reads the custom files after a change was seen
+func (e *Engine) reloadWatchedFiles() {
+	reload, err := e.syncCustomFiles()
+	if err != nil {
+		e.log.Errorf("Failed to reload custom blocklists: %v", err)
+		return
+	}
+
+	failed := 0
+	for _, file := range reload.Files {
+		if file.Error != "" {
+			failed++
+		}
+	}
+	e.log.Infof("Custom blocklists changed on disk: %d domains, %d names updated, %d files failed",
+		reload.Count, len(reload.Changed), failed)
+}
+
+// customWatchDirs returns the existing directories that files matching
+// pattern can be created in
+func customWatchDirs(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Dir(pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, dir := range matches {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+// isCustomFile reports whether path matches custom_path
+func (e *Engine) isCustomFile(path string) bool {
+	ok, _ := filepath.Match(filepath.Clean(e.cfg.Blocklists.CustomPath), filepath.Clean(path))
+	return ok
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package filter
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const customWatchMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+// WatchCustomBlocklists reloads the custom YAML files whenever a file
+// matching custom_path is written, created, renamed or deleted. It uses
+// inotify on the directories of custom_path and blocks until the watch fails.
+func (e *Engine) WatchCustomBlocklists() error {
+	dirs, err := customWatchDirs(e.cfg.Blocklists.CustomPath)
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("no directory matches %s", filepath.Dir(e.cfg.Blocklists.CustomPath))
+	}
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("inotify: %w", err)
+	}
+	defer unix.Close(fd)
+
+	watched := make(map[int32]string)
+	for _, dir := range dirs {
+		wd, err := unix.InotifyAddWatch(fd, dir, customWatchMask)
+		if err != nil {
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+		watched[int32(wd)] = dir
+	}
+	e.log.Infof("Watching %s for changes", e.cfg.Blocklists.CustomPath)
+
+	timer := time.AfterFunc(time.Hour, e.reloadWatchedFiles)
+	timer.Stop()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := unix.Read(fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("inotify read: %w", err)
+		}
+
+		changed := false
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + unix.SizeofInotifyEvent
+			name := string(bytes.TrimRight(buf[start:start+int(event.Len)], "\x00"))
+			offset = start + int(event.Len)
+
+			// A queue overflow loses events, so read everything again
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				changed = true
+				continue
+			}
+			if dir, ok := watched[event.Wd]; ok && name != "" && e.isCustomFile(filepath.Join(dir, name)) {
+				changed = true
+			}
+		}
+		if changed {
+			timer.Reset(watchDelay)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+//go:build !linux
+
+package filter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const watchPollTime = 2 * time.Second
+
+// WatchCustomBlocklists reloads the custom YAML files whenever a file
+// matching custom_path changes. Without inotify the files are polled for
+// changes in size and modification time. It blocks until the pattern becomes
+// invalid.
+func (e *Engine) WatchCustomBlocklists() error {
+	last, err := e.customFilesState()
+	if err != nil {
+		return err
+	}
+	e.log.Infof("Watching %s for changes (polling)", e.cfg.Blocklists.CustomPath)
+
+	ticker := time.NewTicker(watchPollTime)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		state, err := e.customFilesState()
+		if err != nil {
+			return err
+		}
+		if state != last {
+			last = state
+			time.Sleep(watchDelay)
+			e.reloadWatchedFiles()
+		}
+	}
+	return nil
+}
+
+// customFilesState summarises the name, size and modification time of every
+// file matching custom_path
+func (e *Engine) customFilesState() (string, error) {
+	files, err := filepath.Glob(e.cfg.Blocklists.CustomPath)
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	state := ""
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			state += fmt.Sprintf("%s|%d|%d\n", file, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return state, nil
+}
@@ -618,6 +618,29 @@ func (e *Engine) OnBlockAttempt(userID string, domain string, blocked bool) {
 	e.UpdateStreak(userID)
 }
 
+// RecordAttempts is OnBlockAttempt for a batch of attempts: the totals are
+// updated and the points awarded once for the whole batch
+func (e *Engine) RecordAttempts(userID string, blocked, allowed int) {
+	if blocked == 0 && allowed == 0 {
+		return
+	}
+
+	e.db.Exec(`
+		UPDATE gamification_users
+		SET total_blocked = total_blocked + ?,
+		    total_allowed = total_allowed + ?
+		WHERE user_id = ?
+	`, blocked, allowed, userID)
+
+	if blocked > 0 {
+		// Award points for resisting temptation
+		e.AddPoints(userID, e.pointsPerBlock*blocked, "blocked_attempt")
+	}
+
+	// Update streak
+	e.UpdateStreak(userID)
+}
+
 func (e *Engine) OnDailyLogin(userID string) {
 	e.AddPoints(userID, e.pointsPerDay, "daily_login")
 	e.UpdateStreak(userID)
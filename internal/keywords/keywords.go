@@ -349,6 +349,51 @@ func (km *KeywordManager) CheckDomain(domain string) (bool, []string, string) {
 	return false, nil, ""
 }
 
+// FindKeywords returns the first enabled list not in skip with keywords in
+// domain and the keywords it matched, without logging the match
+func (km *KeywordManager) FindKeywords(domain string, skip map[string]bool) (string, []string) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	for listID, list := range km.lists {
+		if !list.Enabled || skip[listID] {
+			continue
+		}
+		var matched []string
+		for _, keyword := range list.Keywords {
+			if strings.Contains(domain, keyword) {
+				matched = append(matched, keyword)
+			}
+		}
+		if len(matched) > 0 {
+			return listID, matched
+		}
+	}
+	return "", nil
+}
+
+// MatchList returns the keywords of one list, enabled or not, in domain
+func (km *KeywordManager) MatchList(listID, domain string) []string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	list, ok := km.lists[listID]
+	if !ok {
+		return nil
+	}
+	var matched []string
+	for _, keyword := range list.Keywords {
+		if strings.Contains(domain, keyword) {
+			matched = append(matched, keyword)
+		}
+	}
+	return matched
+}
+
 func (km *KeywordManager) logMatch(domain, keyword, listID string) {
 	km.db.Exec(`
 		INSERT INTO keyword_matches (domain, keyword, list_id)